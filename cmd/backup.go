@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"database/sql"
+	"flag"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/backup"
+	"bingo/internal/mvc/model/importer"
+	"bingo/internal/mvc/model/migration"
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// formatBackup is the format of backups written by the export command.
+const formatBackup = "bingo"
+
+// exportBackup runs the export command. It writes a backup of the database to
+// a file or stdout, which is compressed if the file name ends with .gz.
+func exportBackup(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = usage
+	output := flags.String("o", "", "")
+	since := flags.String("since", "", "")
+	until := flags.String("until", "", "")
+	uid := flags.String("user", "", "")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	var filter backup.Filter
+	var err error
+	filter.Since, err = parseDate(*since)
+	if err != nil {
+		log.Fatalf("Invalid date '%s', expected YYYY-MM-DD", *since)
+	}
+	filter.Until, err = parseDate(*until)
+	if err != nil {
+		log.Fatalf("Invalid date '%s', expected YYYY-MM-DD", *until)
+	}
+
+	db := openBackupDatabase(flags.Arg(0))
+	defer db.Close()
+
+	if *uid != "" {
+		user, err := store.NewUserStore(db).FindByUID(*uid)
+		if err == sql.ErrNoRows {
+			log.Fatalf("User '%s' doesn't exist", *uid)
+		} else if err != nil {
+			log.Fatalf("Failed to find user '%s': %s", *uid, err)
+		}
+		filter.UserID = sql.NullInt64{Int64: user.ID, Valid: true}
+	}
+
+	file := os.Stdout
+	if *output != "" {
+		file, err = os.Create(*output)
+		if err != nil {
+			log.Fatalf("Failed to create backup: %s", err)
+		}
+	}
+
+	var target io.Writer = file
+	var compressed *gzip.Writer
+	if strings.HasSuffix(*output, ".gz") {
+		compressed = gzip.NewWriter(file)
+		target = compressed
+	}
+
+	writer := bufio.NewWriter(target)
+	summary, err := backup.Export(db, writer, filter)
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil && compressed != nil {
+		err = compressed.Close()
+	}
+	if err == nil && file != os.Stdout {
+		err = file.Close()
+	}
+	if err != nil {
+		log.Fatalf("Failed to export backup: %s", err)
+	}
+
+	log.Infof("Exported %d users, %d tokens and %d pastes", summary.Users, summary.Tokens, summary.Pastes)
+}
+
+// importBackup runs the import command. It restores a backup from a file or
+// stdin, which may be compressed, or imports pastes from another pastebin.
+func importBackup(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = usage
+	input := flags.String("i", "", "")
+	format := flags.String("format", formatBackup, "")
+	keysFile := flags.String("keys", "", "")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	if *format != formatBackup {
+		importPastes(flags.Arg(0), *format, *input, *keysFile)
+		return
+	}
+
+	file := os.Stdin
+	if *input != "" {
+		var err error
+		file, err = os.Open(*input)
+		if err != nil {
+			log.Fatalf("Failed to open backup: %s", err)
+		}
+		defer file.Close()
+	}
+
+	reader, err := decompress(bufio.NewReader(file))
+	if err != nil {
+		log.Fatalf("Failed to read backup: %s", err)
+	}
+
+	db := openBackupDatabase(flags.Arg(0))
+	defer db.Close()
+
+	summary, err := backup.Import(db, reader)
+	if err != nil {
+		log.Fatalf("Failed to import backup: %s", err)
+	}
+
+	log.Infof("Imported %d users, %d tokens and %d pastes, skipped %d existing or expired records", summary.Users, summary.Tokens, summary.Pastes, summary.Skipped)
+}
+
+// importPastes imports the pastes of another pastebin in the given format from
+// the file or directory at path.
+func importPastes(configFile string, format string, path string, keysFile string) {
+	if !importer.IsFormat(format) {
+		log.Fatalf("Unknown import format '%s'", format)
+	} else if path == "" {
+		log.Fatalf("The %s format needs a file or directory to import from, see -i", format)
+	}
+
+	var keys map[string]importer.Key
+	if keysFile != "" {
+		var err error
+		keys, err = importer.ReadKeys(keysFile)
+		if err != nil {
+			log.Fatalf("Failed to read keys: %s", err)
+		}
+	}
+
+	db := openBackupDatabase(configFile)
+	defer db.Close()
+
+	summary, err := importer.Import(db, format, path, keys)
+	if err != nil {
+		log.Fatalf("Failed to import %s pastes: %s", format, err)
+	}
+
+	log.Infof("Imported %d pastes, skipped %d", summary.Pastes, summary.Skipped)
+}
+
+// openBackupDatabase opens the database of the given configuration file and
+// applies pending migrations unless they're applied separately.
+func openBackupDatabase(configFile string) *sqlx.DB {
+	config.Load(configFile)
+	if config.Get().Database.Driver == model.DriverMemory {
+		log.Fatal("The memory database can't be exported or imported")
+	}
+
+	db := model.NewDatabase()
+	if config.Get().Database.AutoMigrate {
+		err := migration.Up(db)
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %s", err)
+		}
+	}
+	return db
+}
+
+// decompress returns a reader decompressing the backup if it's compressed
+// with gzip.
+func decompress(reader *bufio.Reader) (io.Reader, error) {
+	magic, err := reader.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return reader, nil
+	}
+	return gzip.NewReader(reader)
+}
+
+// parseDate parses a date or a time in RFC 3339 format. Dates are midnight
+// in UTC and empty values are the zero time.
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Parse(time.RFC3339, value)
+	}
+	return date, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/migration"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// migrate runs the migrate command. It applies all pending migrations of the
+// database schema, reverts the latest one or prints the status of all of them.
+func migrate(args []string) {
+	if len(args) != 2 {
+		usage()
+	}
+
+	command := args[0]
+	if command != "up" && command != "down" && command != "status" {
+		usage()
+	}
+
+	config.Load(args[1])
+	if config.Get().Database.Driver == model.DriverMemory {
+		log.Fatal("The memory database has no schema to migrate")
+	}
+
+	db := model.NewDatabase()
+	defer db.Close()
+
+	var err error
+	switch command {
+	case "up":
+		err = migration.Up(db)
+	case "down":
+		err = migration.Down(db)
+	case "status":
+		err = printStatus(db)
+	}
+	if err != nil {
+		log.Fatalf("Failed to migrate database: %s", err)
+	}
+}
+
+func printStatus(db *sqlx.DB) error {
+	states, err := migration.Status(db)
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+	for _, state := range states {
+		applied := "pending"
+		if state.TimeApplied.Valid {
+			applied = state.TimeApplied.Time.UTC().Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\n", state.Version, state.Name, applied)
+	}
+	return writer.Flush()
+}
@@ -9,37 +9,87 @@ import (
 	"bingo/internal/http/middleware"
 	"bingo/internal/mvc/controller"
 	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/migration"
 	"bingo/internal/mvc/model/store"
 	"bingo/internal/session"
 	"bingo/internal/util/log"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/julienschmidt/httprouter"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "export":
+			exportBackup(os.Args[2:])
+			return
+		case "import":
+			importBackup(os.Args[2:])
+			return
+		}
+	}
+
+	if len(os.Args) != 2 {
+		usage()
+	}
+
 	config.Load(os.Args[1])
-	db := model.NewDatabase()
-	pasteStore := store.NewPasteStore(db)
-	userStore := store.NewUserStore(db)
-	session.Init(userStore)
+	db, userStore, tokenStore, pasteStore, auditStore := newStores()
+	session.Init(db, userStore, tokenStore)
 	router := httprouter.New()
 
 	errCtrl := controller.NewErrorController()
 	imageCtrl := controller.NewImageController()
 	pasteCtrl := controller.NewPasteController(errCtrl, pasteStore)
-	userCtrl := controller.NewUserController(errCtrl, userStore)
+	userCtrl := controller.NewUserController(errCtrl, userStore, tokenStore, auditStore)
 	authCtrl := controller.NewAuthController(errCtrl, userCtrl)
+	pasteAPICtrl := controller.NewPasteAPIController(pasteStore)
 
 	imageRoute(router, imageCtrl)
 	pasteRoute(router, pasteCtrl)
 	authRoute(router, authCtrl)
 	userRoute(router, userCtrl)
+	pasteAPIRoute(router, pasteAPICtrl)
 
 	router.NotFound = guestMiddleware(errCtrl.ServeNotFoundError)
 	addr := fmt.Sprintf("%s:%d", config.Get().Host, config.Get().Port)
 	log.Fatal(http.ListenAndServe(addr, router))
 }
 
+// newStores creates the stores of the configured database. The memory
+// database doesn't use a SQL database, so the returned database is nil.
+func newStores() (*sqlx.DB, store.UserRepository, store.TokenRepository, store.PasteRepository, store.AuditRepository) {
+	if config.Get().Database.Driver == model.DriverMemory {
+		memory := store.NewMemoryDatabase()
+		return nil, store.NewMemoryUserStore(memory), store.NewMemoryTokenStore(memory), store.NewMemoryPasteStore(memory), store.NewMemoryAuditStore(memory)
+	}
+
+	db := model.NewDatabase()
+	if config.Get().Database.AutoMigrate {
+		err := migration.Up(db)
+		if err != nil {
+			log.Fatalf("Failed to migrate database: %s", err)
+		}
+	}
+
+	blobStore := store.NewBlobStore(db)
+	return db, store.NewUserStore(db), store.NewTokenStore(db), store.NewPasteStore(db, blobStore), store.NewAuditStore(db)
+}
+
+// usage prints how to run the server and its commands, and exits.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  %s <config-file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s migrate up|down|status <config-file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s export [-o file] [-since date] [-until date] [-user uid] <config-file>\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s import [-format bingo|privatebin|stikked|gist] [-i path] [-keys file] <config-file>\n", os.Args[0])
+	os.Exit(2)
+}
+
 func imageRoute(router *httprouter.Router, imgCtrl *controller.ImageController) {
 	router.Handler(http.MethodGet, "/favicon.ico", guestMiddleware(imgCtrl.ServeFavicon))
 }
@@ -47,9 +97,34 @@ func imageRoute(router *httprouter.Router, imgCtrl *controller.ImageController)
 func pasteRoute(router *httprouter.Router, pasteCtrl *controller.PasteController) {
 	router.Handler(http.MethodGet, "/", viewerMiddleware(pasteCtrl.ServeWritePage))
 	router.Handler(http.MethodGet, "/pastes", viewerMiddleware(pasteCtrl.ServeListPage))
+	router.Handler(http.MethodGet, "/archive", viewerMiddleware(pasteCtrl.ServeArchive))
 	router.Handler(http.MethodGet, "/pastes/:id", guestMiddleware(pasteCtrl.ServeViewPage))
+	router.Handler(http.MethodPost, "/pastes/:id", guestMiddleware(pasteCtrl.ServeViewPage))
 	router.Handler(http.MethodGet, "/pastes/:id/raw", viewerMiddleware(pasteCtrl.ServeRawPaste))
+	router.Handler(http.MethodPost, "/pastes/:id/raw", viewerMiddleware(pasteCtrl.ServeRawPaste))
+	router.Handler(http.MethodGet, "/pastes/:id/raw/:filename", viewerMiddleware(pasteCtrl.ServeRawFile))
+	router.Handler(http.MethodPost, "/pastes/:id/raw/:filename", viewerMiddleware(pasteCtrl.ServeRawFile))
+	router.Handler(http.MethodGet, "/pastes/:id/attachments/:position", viewerMiddleware(pasteCtrl.ServeAttachment))
+	router.Handler(http.MethodPost, "/pastes/:id/attachments/:position", viewerMiddleware(pasteCtrl.ServeAttachment))
+	router.Handler(http.MethodGet, "/pastes/:id/download", viewerMiddleware(pasteCtrl.ServeDownload))
+	router.Handler(http.MethodPost, "/pastes/:id/download", viewerMiddleware(pasteCtrl.ServeDownload))
+	router.Handler(http.MethodGet, "/pastes/:id/edit", editorMiddleware(pasteCtrl.ServeEditPage))
+	router.Handler(http.MethodGet, "/pastes/:id/fork", editorMiddleware(pasteCtrl.ServeForkPage))
+	router.Handler(http.MethodGet, "/pastes/:id/claim", viewerMiddleware(pasteCtrl.ServeClaimPage))
+	router.Handler(http.MethodPost, "/pastes/:id/claim", viewerMiddleware(pasteCtrl.ClaimPaste))
+	router.Handler(http.MethodGet, "/pastes/:id/revisions", viewerMiddleware(pasteCtrl.ServeRevisionsPage))
+	router.Handler(http.MethodGet, "/pastes/:id/revisions/:revision/raw", viewerMiddleware(pasteCtrl.ServeRawRevision))
+	router.Handler(http.MethodGet, "/pastes/:id/diff", viewerMiddleware(pasteCtrl.ServeDiffPage))
 	router.Handler(http.MethodPost, "/pastes", editorMiddleware(pasteCtrl.CreatePaste))
+	router.Handler(http.MethodPost, "/pastes/:id/update", editorMiddleware(pasteCtrl.UpdatePaste))
+	router.Handler(http.MethodPost, "/pastes/:id/delete", viewerMiddleware(pasteCtrl.DeletePaste))
+	router.Handler(http.MethodPost, "/", apiEditorMiddleware(pasteCtrl.CreateRawPaste))
+}
+
+func pasteAPIRoute(router *httprouter.Router, pasteAPICtrl *controller.PasteAPIController) {
+	router.Handler(http.MethodGet, "/api/v1/pastes", apiViewerMiddleware(pasteAPICtrl.ServePastes))
+	router.Handler(http.MethodGet, "/api/v1/pastes/:id", guestMiddleware(pasteAPICtrl.ServePaste))
+	router.Handler(http.MethodPost, "/api/v1/pastes", apiEditorMiddleware(pasteAPICtrl.CreatePaste))
 }
 
 func authRoute(router *httprouter.Router, authCtrl *controller.AuthController) {
@@ -62,6 +137,30 @@ func authRoute(router *httprouter.Router, authCtrl *controller.AuthController) {
 	router.Handler(http.MethodPost, "/login", guestMiddleware(authCtrl.Login))
 	router.Handler(http.MethodPost, "/logout", guestMiddleware(authCtrl.Logout))
 
+	if config.Get().Authentication.OIDC.Enabled {
+		router.Handler(http.MethodGet, "/login/oidc", guestMiddleware(authCtrl.StartOIDCLogin))
+		router.Handler(http.MethodGet, "/login/oidc/callback", guestMiddleware(authCtrl.FinishOIDCLogin))
+	}
+
+	if config.Get().Authentication.Standard.Enabled {
+		router.Handler(http.MethodGet, "/login/totp", guestMiddleware(authCtrl.ServeTOTPLoginPage))
+		router.Handler(http.MethodPost, "/login/totp", guestMiddleware(authCtrl.LoginTOTP))
+		router.Handler(http.MethodGet, "/login/totp/enable", guestMiddleware(authCtrl.ServeTOTPLoginEnablePage))
+		router.Handler(http.MethodPost, "/login/totp/enable", guestMiddleware(authCtrl.LoginEnableTOTP))
+		router.Handler(http.MethodGet, "/profile/totp", viewerMiddleware(authCtrl.ServeTOTPEnablePage))
+		router.Handler(http.MethodGet, "/profile/totp/recovery", viewerMiddleware(authCtrl.ServeRecoveryPage))
+		router.Handler(http.MethodPost, "/profile/totp", viewerMiddleware(authCtrl.EnableTOTP))
+		router.Handler(http.MethodPost, "/profile/totp/disable", viewerMiddleware(authCtrl.DisableTOTP))
+	}
+
+	if config.Get().Authentication.Standard.Enabled && config.Get().SMTP.Enabled {
+		router.Handler(http.MethodGet, "/forgot-password", guestMiddleware(authCtrl.ServeForgotPasswordPage))
+		router.Handler(http.MethodPost, "/forgot-password", guestMiddleware(authCtrl.ForgotPassword))
+		router.Handler(http.MethodGet, "/reset-password", guestMiddleware(authCtrl.ServeResetPasswordPage))
+		router.Handler(http.MethodPost, "/reset-password", guestMiddleware(authCtrl.ResetPassword))
+		router.Handler(http.MethodGet, "/verify-email", guestMiddleware(authCtrl.VerifyEmail))
+	}
+
 	if config.Get().Authentication.Standard.AllowRegistration {
 		router.Handler(http.MethodPost, "/register", guestMiddleware(authCtrl.Register))
 	}
@@ -77,9 +176,16 @@ func userRoute(router *httprouter.Router, userCtrl *controller.UserController) {
 	router.Handler(http.MethodGet, "/users/create", adminMiddleware(userCtrl.ServeCreatePage))
 	router.Handler(http.MethodGet, "/users/edit/:id", adminMiddleware(userCtrl.ServeEditPage))
 	router.Handler(http.MethodPost, "/profile/update", viewerMiddleware(userCtrl.UpdateProfile))
+	router.Handler(http.MethodPost, "/profile/tokens/create", viewerMiddleware(userCtrl.CreateToken))
+	router.Handler(http.MethodPost, "/profile/tokens/delete/:id", viewerMiddleware(userCtrl.DeleteToken))
 	router.Handler(http.MethodPost, "/users/create", adminMiddleware(userCtrl.CreateUser))
 	router.Handler(http.MethodPost, "/users/update/:id", adminMiddleware(userCtrl.UpdateUser))
 	router.Handler(http.MethodPost, "/users/delete/:id", adminMiddleware(userCtrl.DeleteUser))
+	router.Handler(http.MethodPost, "/users/totp/disable/:id", adminMiddleware(userCtrl.DisableTOTP))
+
+	if config.Get().Authentication.Lockout.Enabled {
+		router.Handler(http.MethodPost, "/users/unlock/:id", adminMiddleware(userCtrl.UnlockUser))
+	}
 }
 
 func adminMiddleware(handler http.HandlerFunc) http.Handler {
@@ -104,6 +210,24 @@ func authMiddleware(handler http.HandlerFunc, role config.Role) http.Handler {
 	return guestMiddleware(mw.ServeHTTP)
 }
 
+func apiEditorMiddleware(handler http.HandlerFunc) http.Handler {
+	return apiAuthMiddleware(handler, config.RoleEditor)
+}
+
+func apiViewerMiddleware(handler http.HandlerFunc) http.Handler {
+	return apiAuthMiddleware(handler, config.RoleViewer)
+}
+
+func apiAuthMiddleware(handler http.HandlerFunc, role config.Role) http.Handler {
+	if !config.Get().Authentication.Enabled {
+		return guestMiddleware(handler)
+	}
+
+	mw := middleware.AuthorizeAPI(handler, role)
+	mw = middleware.AuthenticateAPI(mw)
+	return guestMiddleware(mw.ServeHTTP)
+}
+
 func guestMiddleware(handler http.HandlerFunc) http.Handler {
 	mw := middleware.StartSession(handler)
 	mw = middleware.TrimStrings(mw)
@@ -4,16 +4,24 @@ go 1.13
 
 require (
 	github.com/alecthomas/chroma v0.8.2
+	github.com/alexedwards/scs/mysqlstore v0.0.0-20230201054056-1257cb2752e3
 	github.com/alexedwards/scs/postgresstore v0.0.0-20210131110750-3ca38f9a41cc
+	github.com/alexedwards/scs/sqlite3store v0.0.0-20230201054056-1257cb2752e3
 	github.com/alexedwards/scs/v2 v2.4.0
 	github.com/dlclark/regexp2 v1.4.0 // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-redis/redis v6.15.9+incompatible
+	github.com/go-sql-driver/mysql v1.7.1
+	github.com/golang/protobuf v1.3.1 // indirect
 	github.com/jmoiron/sqlx v1.3.1
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.9.0
 	github.com/onsi/ginkgo v1.12.0 // indirect
 	github.com/onsi/gomega v1.9.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
-	google.golang.org/appengine v1.6.5 // indirect
 	gopkg.in/yaml.v2 v2.4.0
+	modernc.org/sqlite v1.17.3
 )
@@ -0,0 +1,44 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"bingo/internal/util/log"
+)
+
+// AttachmentConfig contains configuration for binary files attached to pastes.
+type AttachmentConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	MaxSize    int64  `yaml:"-"`
+	RawMaxSize string `yaml:"max_size"`
+}
+
+// DefaultAttachmentConfig creates a new AttachmentConfig with default values.
+func DefaultAttachmentConfig() AttachmentConfig {
+	return AttachmentConfig{
+		Enabled:    true,
+		MaxSize:    10 << 20,
+		RawMaxSize: "10MB",
+	}
+}
+
+// newByteSize parses a size in bytes with an optional KB, MB or GB suffix.
+func newByteSize(size string) int64 {
+	value := strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, suffix))
+			multiplier = m
+			break
+		}
+	}
+	value = strings.TrimSuffix(value, "B")
+
+	result, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || result < 0 {
+		log.Fatalf("Failed to parse size: unknown value '%s'", size)
+	}
+	return result * multiplier
+}
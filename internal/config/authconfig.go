@@ -6,6 +6,12 @@ const (
 
 	// AuthLDAP users log in using their LDAP credentials.
 	AuthLDAP = iota
+
+	// AuthProxy users are authenticated by a reverse proxy in front of bingo.
+	AuthProxy = iota
+
+	// AuthOIDC users log in with an OpenID Connect provider.
+	AuthOIDC = iota
 )
 
 const (
@@ -32,6 +38,7 @@ type AuthConfig struct {
 	RawDefaultMode string   `yaml:"default_mode"`
 	DefaultRole    Role     `yaml:"-"`
 	RawDefaultRole string   `yaml:"default_role"`
+	Secret         string   `yaml:"secret"`
 
 	Session struct {
 		Name         string `yaml:"name"`
@@ -48,8 +55,22 @@ type AuthConfig struct {
 	Standard struct {
 		Enabled           bool `yaml:"enabled"`
 		AllowRegistration bool `yaml:"allow_registration"`
+		VerifyEmail       bool `yaml:"verify_email"`
+		TOTP              struct {
+			Issuer           string `yaml:"issuer"`
+			RequireForAdmins bool   `yaml:"require_for_admins"`
+		} `yaml:"totp"`
 	} `yaml:"standard"`
 
+	Lockout struct {
+		Enabled          bool     `yaml:"enabled"`
+		FreeAttempts     int      `yaml:"free_attempts"`
+		MaxAttempts      int      `yaml:"max_attempts"`
+		MaxAttemptsPerIP int      `yaml:"max_attempts_per_ip"`
+		Duration         int      `yaml:"duration"`
+		TrustedProxies   []string `yaml:"trusted_proxies"`
+	} `yaml:"lockout"`
+
 	LDAP struct {
 		Enabled bool   `yaml:"enabled"`
 		Host    string `yaml:"host"`
@@ -68,6 +89,39 @@ type AuthConfig struct {
 		UserFilter  string `yaml:"user_filter"`
 		AdminFilter string `yaml:"admin_filter"`
 	} `yaml:"ldap"`
+
+	Proxy struct {
+		Enabled        bool     `yaml:"enabled"`
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		Headers        struct {
+			UID    string `yaml:"uid"`
+			Name   string `yaml:"name"`
+			Email  string `yaml:"email"`
+			Groups string `yaml:"groups"`
+		} `yaml:"headers"`
+		AdminGroups  []string `yaml:"admin_groups"`
+		EditorGroups []string `yaml:"editor_groups"`
+		ViewerGroups []string `yaml:"viewer_groups"`
+	} `yaml:"proxy"`
+
+	OIDC struct {
+		Enabled      bool     `yaml:"enabled"`
+		Name         string   `yaml:"name"`
+		Issuer       string   `yaml:"issuer"`
+		ClientID     string   `yaml:"client_id"`
+		ClientSecret string   `yaml:"client_secret"`
+		RedirectURL  string   `yaml:"redirect_url"`
+		Scopes       []string `yaml:"scopes"`
+		Claims       struct {
+			UID    string `yaml:"uid"`
+			Name   string `yaml:"name"`
+			Email  string `yaml:"email"`
+			Groups string `yaml:"groups"`
+		} `yaml:"claims"`
+		AdminGroups  []string `yaml:"admin_groups"`
+		EditorGroups []string `yaml:"editor_groups"`
+		ViewerGroups []string `yaml:"viewer_groups"`
+	} `yaml:"oidc"`
 }
 
 // DefaultAuthConfig creates a new AuthConfig with default values.
@@ -91,8 +145,38 @@ func DefaultAuthConfig() AuthConfig {
 
 	config.Standard.Enabled = true
 	config.Standard.AllowRegistration = false
+	config.Standard.VerifyEmail = false
+	config.Standard.TOTP.Issuer = "Bingo"
+	config.Standard.TOTP.RequireForAdmins = false
+
+	config.Lockout.Enabled = true
+	config.Lockout.FreeAttempts = 3
+	config.Lockout.MaxAttempts = 10
+	config.Lockout.MaxAttemptsPerIP = 50
+	config.Lockout.Duration = 15
+	config.Lockout.TrustedProxies = []string{}
 
 	config.LDAP.Enabled = false
+	config.LDAP.Port = 389
+	config.LDAP.UseTLS = false
+	config.LDAP.Attributes.UID = "uid"
+	config.LDAP.Attributes.Name = "cn"
+	config.LDAP.Attributes.Email = "mail"
+
+	config.Proxy.Enabled = false
+	config.Proxy.TrustedProxies = []string{"127.0.0.1/32", "::1/128"}
+	config.Proxy.Headers.UID = "X-Forwarded-User"
+	config.Proxy.Headers.Name = "X-Forwarded-Preferred-Username"
+	config.Proxy.Headers.Email = "X-Forwarded-Email"
+	config.Proxy.Headers.Groups = "X-Forwarded-Groups"
+
+	config.OIDC.Enabled = false
+	config.OIDC.Name = "OpenID Connect"
+	config.OIDC.Scopes = []string{"openid", "profile", "email"}
+	config.OIDC.Claims.UID = "preferred_username"
+	config.OIDC.Claims.Name = "name"
+	config.OIDC.Claims.Email = "email"
+	config.OIDC.Claims.Groups = "groups"
 
 	return config
 }
@@ -127,6 +211,10 @@ func (mode AuthMode) String() string {
 		return "Standard"
 	case AuthLDAP:
 		return "LDAP"
+	case AuthProxy:
+		return "Proxy"
+	case AuthOIDC:
+		return "OpenID Connect"
 	default:
 		return "<invalid_auth_mode>"
 	}
@@ -15,12 +15,17 @@ var conf *Config
 type Config struct {
 	Host           string           `yaml:"host"`
 	Port           int              `yaml:"port"`
+	BaseURL        string           `yaml:"base_url"`
 	LogLevel       log.Level        `yaml:"-"`
 	RawLogLevel    string           `yaml:"log_level"`
+	Attachments    AttachmentConfig `yaml:"attachments"`
 	Authentication AuthConfig       `yaml:"auth"`
 	Database       DatabaseConfig   `yaml:"db"`
+	Encryption     EncryptionConfig `yaml:"encryption"`
 	Expiry         ExpiryConfig     `yaml:"expiry"`
 	Highlight      HighlightConfig  `yaml:"highlight"`
+	SMTP           SMTPConfig       `yaml:"smtp"`
+	Storage        StorageConfig    `yaml:"storage"`
 	Theme          ThemeConfig      `yaml:"theme"`
 	Visibility     VisibilityConfig `yaml:"visibility"`
 }
@@ -50,9 +55,15 @@ func Load(filename string) *Config {
 	conf.Visibility.Default = newVisibility(conf.Visibility.RawDefault)
 	conf.Authentication.DefaultMode = newAuthMode(conf.Authentication.RawDefaultMode)
 	conf.Authentication.DefaultRole = newRole(conf.Authentication.RawDefaultRole)
+	conf.Attachments.MaxSize = newByteSize(conf.Attachments.RawMaxSize)
+
+	if conf.SMTP.BaseURL == "" {
+		conf.SMTP.BaseURL = conf.BaseURL
+	}
 
 	if !conf.Expiry.Enabled {
 		conf.Expiry.Durations = []time.Duration{}
+		conf.Expiry.Views = []int64{}
 	} else {
 		conf.Expiry.Durations = newDurations(conf.Expiry.RawDurations)
 	}
@@ -73,10 +84,14 @@ func NewDefaultConfig() *Config {
 	conf.Host = "0.0.0.0"
 	conf.Port = 80
 	conf.RawLogLevel = "info"
+	conf.Attachments = DefaultAttachmentConfig()
 	conf.Authentication = DefaultAuthConfig()
 	conf.Database = DefaultDatabaseConfig()
+	conf.Encryption = DefaultEncryptionConfig()
 	conf.Expiry = DefaultExpiryConfig()
 	conf.Highlight = DefaultHighlightConfig()
+	conf.SMTP = DefaultSMTPConfig()
+	conf.Storage = DefaultStorageConfig()
 	conf.Theme = DefaultThemeConfig()
 	conf.Visibility = DefaultVisibilityConfig()
 	return conf
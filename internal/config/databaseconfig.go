@@ -9,6 +9,9 @@ type DatabaseConfig struct {
 	Host     string `yaml:"host"`
 	Port     int    `yaml:"port"`
 	SSL      string `yaml:"ssl"`
+	Path     string `yaml:"path"`
+
+	AutoMigrate bool `yaml:"auto_migrate"`
 }
 
 // DefaultDatabaseConfig creates a new DatabaseConfig with default values.
@@ -21,5 +24,8 @@ func DefaultDatabaseConfig() DatabaseConfig {
 		Host:     "localhost",
 		Port:     5432,
 		SSL:      "required",
+		Path:     "data/bingo.db",
+
+		AutoMigrate: true,
 	}
 }
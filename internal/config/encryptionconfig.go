@@ -0,0 +1,14 @@
+package config
+
+// EncryptionConfig contains configuration for pastes encrypted in the browser.
+// Encryption requires Javascript, so it's disabled by default.
+type EncryptionConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// DefaultEncryptionConfig creates a new EncryptionConfig with default values.
+func DefaultEncryptionConfig() EncryptionConfig {
+	return EncryptionConfig{
+		Enabled: false,
+	}
+}
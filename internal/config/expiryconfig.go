@@ -11,6 +11,7 @@ type ExpiryConfig struct {
 	Enabled      bool            `yaml:"enabled"`
 	Durations    []time.Duration `yaml:"-"`
 	RawDurations []int           `yaml:"durations"`
+	Views        []int64         `yaml:"views"`
 }
 
 // DefaultExpiryConfig creates a new ExpiryConfig with default values.
@@ -18,9 +19,32 @@ func DefaultExpiryConfig() ExpiryConfig {
 	return ExpiryConfig{
 		Enabled:      true,
 		RawDurations: []int{10, 60, 1440, 10080, 43200, 525600, 0},
+		Views:        []int64{0, 1, 10, 100},
 	}
 }
 
+// HasDuration returns whether the duration is one of the configured
+// durations, where zero means the paste never expires.
+func (config *ExpiryConfig) HasDuration(duration time.Duration) bool {
+	for _, minutes := range config.RawDurations {
+		if time.Duration(minutes)*time.Minute == duration {
+			return true
+		}
+	}
+	return false
+}
+
+// HasViews returns whether the number of views is one of the configured
+// limits, where zero allows unlimited views.
+func (config *ExpiryConfig) HasViews(views int64) bool {
+	for _, limit := range config.Views {
+		if limit == views {
+			return true
+		}
+	}
+	return false
+}
+
 func newDurations(values []int) []time.Duration {
 	durations := make([]time.Duration, len(values), len(values))
 	for i := 0; i < len(values); i++ {
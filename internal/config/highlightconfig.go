@@ -29,6 +29,21 @@ func DefaultHighlightConfig() HighlightConfig {
 	}
 }
 
+// FindLanguage returns the configured language with the given name, which is
+// compared case-insensitively.
+func (config *HighlightConfig) FindLanguage(name string) (string, bool) {
+	if strings.EqualFold(name, "plaintext") {
+		return "plaintext", true
+	}
+
+	for _, language := range config.Languages {
+		if strings.EqualFold(language, name) {
+			return language, true
+		}
+	}
+	return "", false
+}
+
 func isMainLanguage(lang string) bool {
 	for _, mainLang := range mainLanguages {
 		if lang == mainLang {
@@ -0,0 +1,26 @@
+package config
+
+// SMTPConfig contains configuration for sending mail, which is used for
+// password reset links and to verify the email address of new users. Links
+// in mails point to the base URL rather than the host of the request, since
+// anyone can set the host of their request.
+type SMTPConfig struct {
+	Enabled  bool   `yaml:"enabled"`
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Security string `yaml:"security"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	BaseURL  string `yaml:"base_url"`
+}
+
+// DefaultSMTPConfig creates a new SMTPConfig with default values.
+func DefaultSMTPConfig() SMTPConfig {
+	return SMTPConfig{
+		Enabled:  false,
+		Host:     "localhost",
+		Port:     587,
+		Security: "starttls",
+	}
+}
@@ -0,0 +1,31 @@
+package config
+
+// StorageConfig contains configuration for where the content of pastes and
+// their attachments is stored. The database backend keeps the content in the
+// database, the other backends only keep a reference to the content there.
+type StorageConfig struct {
+	Backend   string `yaml:"backend"`
+	Directory string `yaml:"directory"`
+
+	S3 struct {
+		Endpoint  string `yaml:"endpoint"`
+		Region    string `yaml:"region"`
+		Bucket    string `yaml:"bucket"`
+		Prefix    string `yaml:"prefix"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+		PathStyle bool   `yaml:"path_style"`
+	} `yaml:"s3"`
+}
+
+// DefaultStorageConfig creates a new StorageConfig with default values.
+func DefaultStorageConfig() StorageConfig {
+	config := StorageConfig{
+		Backend:   "database",
+		Directory: "data/storage",
+	}
+
+	config.S3.Region = "us-east-1"
+	config.S3.PathStyle = true
+	return config
+}
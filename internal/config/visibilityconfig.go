@@ -1,5 +1,7 @@
 package config
 
+import "strings"
+
 const (
 	// VisibilityUnlisted hides the paste from searches and listings.
 	VisibilityUnlisted Visibility = iota
@@ -30,15 +32,34 @@ func DefaultVisibilityConfig() VisibilityConfig {
 	}
 }
 
-func newVisibility(visibility string) Visibility {
-	switch visibility {
+// ParseVisibility returns the visibility with the given name.
+func ParseVisibility(visibility string) (Visibility, bool) {
+	switch strings.ToLower(visibility) {
 	case "unlisted":
-		return VisibilityUnlisted
+		return VisibilityUnlisted, true
 	case "listed":
-		return VisibilityListed
+		return VisibilityListed, true
 	case "public":
-		return VisibilityPublic
+		return VisibilityPublic, true
+	default:
+		return VisibilityUnlisted, false
+	}
+}
+
+func newVisibility(visibility string) Visibility {
+	result, _ := ParseVisibility(visibility)
+	return result
+}
+
+func (visibility Visibility) String() string {
+	switch visibility {
+	case VisibilityUnlisted:
+		return "Unlisted"
+	case VisibilityListed:
+		return "Listed"
+	case VisibilityPublic:
+		return "Public"
 	default:
-		return VisibilityUnlisted
+		return "<invalid_visibility>"
 	}
 }
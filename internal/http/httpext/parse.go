@@ -13,6 +13,18 @@ func ParseID(r *http.Request) (int64, error) {
 	return strconv.ParseInt(params.ByName("id"), 10, 64)
 }
 
+// ParseIntParam parses an integer route parameter from HTTP request.
+func ParseIntParam(r *http.Request, name string) (int, error) {
+	params := httprouter.ParamsFromContext(r.Context())
+	return strconv.Atoi(params.ByName(name))
+}
+
+// ParseParam returns a route parameter from HTTP request.
+func ParseParam(r *http.Request, name string) string {
+	params := httprouter.ParamsFromContext(r.Context())
+	return params.ByName(name)
+}
+
 // ParseFilter parses filter string from the HTTP request.
 func ParseFilter(r *http.Request) string {
 	query := r.URL.Query()
@@ -3,6 +3,7 @@ package httpext
 import (
 	"encoding/json"
 	"html/template"
+	"mime"
 	"net/http"
 )
 
@@ -19,6 +20,21 @@ func WriteRaw(w http.ResponseWriter, contentType string, output []byte) error {
 	return err
 }
 
+// WriteAttachmentHeaders writes headers to the HTTP response that make browsers
+// download the response as a file with the given name.
+func WriteAttachmentHeaders(w http.ResponseWriter, contentType string, filename string) {
+	WriteDefaultHeaders(w, contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// WriteAttachment writes the specified content type as a file download to the HTTP response.
+func WriteAttachment(w http.ResponseWriter, contentType string, filename string, output []byte) error {
+	WriteAttachmentHeaders(w, contentType, filename)
+
+	_, err := w.Write(output)
+	return err
+}
+
 // WriteText writes raw plain-text to the HTTP response.
 func WriteText(w http.ResponseWriter, output []byte) error {
 	return WriteRaw(w, "text/plain", output)
@@ -37,9 +53,31 @@ func WriteJSON(w http.ResponseWriter, output interface{}) error {
 	return encode.Encode(output)
 }
 
+// WriteJSONStatus writes raw JSON with the given status code to the HTTP response.
+func WriteJSONStatus(w http.ResponseWriter, code int, output interface{}) error {
+	WriteDefaultHeaders(w, "application/json")
+	w.WriteHeader(code)
+
+	encode := json.NewEncoder(w)
+	return encode.Encode(output)
+}
+
+// WriteJSONError writes a JSON error message with the given status code to the HTTP response.
+func WriteJSONError(w http.ResponseWriter, code int, message string) error {
+	return WriteJSONStatus(w, code, map[string]string{"error": message})
+}
+
 // WriteTemplate writes the given template to the HTTP response.
 func WriteTemplate(w http.ResponseWriter, tmpl *template.Template, ctx interface{}) error {
 	WriteDefaultHeaders(w, "text/html")
 
 	return tmpl.Execute(w, ctx)
 }
+
+// WriteTemplateStatus writes the given template with the given status code to the HTTP response.
+func WriteTemplateStatus(w http.ResponseWriter, code int, tmpl *template.Template, ctx interface{}) error {
+	WriteDefaultHeaders(w, "text/html")
+	w.WriteHeader(code)
+
+	return tmpl.Execute(w, ctx)
+}
@@ -3,16 +3,45 @@ package middleware
 import (
 	"net/http"
 
+	"bingo/internal/config"
+	"bingo/internal/http/httpext"
 	"bingo/internal/session"
 )
 
-// Authenticate handles user authentication.
+// Authenticate handles user authentication. Requests using an API token are
+// rejected instead of redirected to the login page when the token is invalid,
+// as are requests without a user when the trusted proxy is the only way to
+// log in.
 func Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if session.User(r) == nil {
+		_, hasToken := session.BearerToken(r)
+		if session.User(r) == nil && hasToken {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bingo"`)
+			http.Error(w, "invalid API token", http.StatusUnauthorized)
+		} else if session.User(r) == nil && proxyOnly() {
+			http.Error(w, "not authenticated by the proxy", http.StatusUnauthorized)
+		} else if session.User(r) == nil {
 			http.Redirect(w, r, "/login", http.StatusFound)
 		} else {
 			next.ServeHTTP(w, r)
 		}
 	})
 }
+
+// proxyOnly returns whether users can only be authenticated by the proxy, in
+// which case the login page is of no use.
+func proxyOnly() bool {
+	conf := config.Get().Authentication
+	return conf.Proxy.Enabled && !conf.Standard.Enabled && !conf.LDAP.Enabled
+}
+
+// AuthenticateAPI handles user authentication for the JSON API.
+func AuthenticateAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if session.User(r) == nil {
+			httpext.WriteJSONError(w, http.StatusUnauthorized, "authentication required")
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
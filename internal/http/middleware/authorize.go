@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"bingo/internal/config"
+	"bingo/internal/http/httpext"
 	"bingo/internal/session"
 )
 
@@ -17,3 +18,14 @@ func Authorize(next http.Handler, role config.Role) http.Handler {
 		}
 	})
 }
+
+// AuthorizeAPI handles user authorization for the JSON API.
+func AuthorizeAPI(next http.Handler, role config.Role) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user := session.User(r); user != nil && user.Role >= role {
+			next.ServeHTTP(w, r)
+		} else {
+			httpext.WriteJSONError(w, http.StatusForbidden, "insufficient permissions")
+		}
+	})
+}
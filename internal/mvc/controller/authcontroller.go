@@ -1,34 +1,93 @@
 package controller
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"errors"
+	"fmt"
+	"html"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"bingo/internal/config"
 	"bingo/internal/http/httpext"
 	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/store"
 	"bingo/internal/mvc/view"
 	"bingo/internal/session"
 	"bingo/internal/util/auth"
+	"bingo/internal/util/fmtutil"
 	"bingo/internal/util/log"
+	"bingo/internal/util/mail"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 // AuthController handles user authentication.
 type AuthController struct {
-	err  *ErrorController
-	user *UserController
-	view *view.AuthView
+	err       *ErrorController
+	user      *UserController
+	ldap      *auth.LDAPAuthenticator
+	oidc      *auth.OIDCAuthenticator
+	mailer    *mail.Mailer
+	mailLimit *auth.MailLimiter
+	signer    *auth.TokenSigner
+	view      *view.AuthView
+	mailView  *view.MailView
 }
 
-// NewAuthController creates a new AuthController.
+const (
+	oidcStateSessionKey    = "auth:oidc:state"
+	oidcNonceSessionKey    = "auth:oidc:nonce"
+	oidcVerifierSessionKey = "auth:oidc:verifier"
+
+	totpSecretSessionKey    = "auth:totp:secret"
+	totpAttemptsSessionKey  = "auth:totp:attempts"
+	recoveryCodesSessionKey = "auth:totp:recovery_codes"
+
+	// maxTOTPAttempts is the number of wrong codes after which users have
+	// to enter their password again.
+	maxTOTPAttempts = 5
+
+	resetPurpose     = "reset_password"
+	resetLifetime    = time.Hour
+	verifyPurpose    = "verify_email"
+	verifyLifetime   = 48 * time.Hour
+	tokenStampLength = 16
+
+	// mailInterval is how long to wait before sending a user the same mail
+	// again.
+	mailInterval = 10 * time.Minute
+)
+
+var errInvalidCode = errors.New("invalid code")
+
+// NewAuthController creates a new AuthController. Sent mails are kept in the
+// session store, so the session has to be initialized before.
 func NewAuthController(errCtrl *ErrorController, userCtrl *UserController) *AuthController {
 	ctrl := new(AuthController)
 	ctrl.err = errCtrl
 	ctrl.user = userCtrl
 	ctrl.view = view.NewAuthView()
+
+	if config.Get().Authentication.LDAP.Enabled {
+		ctrl.ldap = auth.NewLDAPAuthenticator()
+	}
+
+	if config.Get().Authentication.OIDC.Enabled {
+		ctrl.oidc = auth.NewOIDCAuthenticator()
+	}
+
+	if config.Get().SMTP.Enabled {
+		ctrl.mailer = mail.NewMailer()
+		ctrl.mailLimit = auth.NewMailLimiter(session.Get().Store, mailInterval)
+		ctrl.signer = auth.NewTokenSigner()
+		ctrl.mailView = view.NewMailView()
+	}
+
 	return ctrl
 }
 
@@ -44,13 +103,209 @@ func (ctrl *AuthController) ServeRegisterPage(w http.ResponseWriter, r *http.Req
 	ctrl.view.Register.Render(w, ctx)
 }
 
-// Login authenticates a user.
+// Login authenticates a user. Users with two-factor authentication are sent
+// on to enter their code, and admins who have to enable it to do so.
 func (ctrl *AuthController) Login(w http.ResponseWriter, r *http.Request) {
-	_, err := ctrl.login(r)
+	user, err := ctrl.login(r)
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Login failed", err.Error())
+		return
+	}
+
+	switch {
+	case user.NeedsTOTP():
+		http.Redirect(w, r, "/login/totp/enable", http.StatusSeeOther)
+	case user.HasTOTP():
+		http.Redirect(w, r, "/login/totp", http.StatusSeeOther)
+	default:
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+// ServeTOTPLoginPage serves the page for entering the second factor after
+// the password.
+func (ctrl *AuthController) ServeTOTPLoginPage(w http.ResponseWriter, r *http.Request) {
+	if user := session.PendingUser(r); user == nil || !user.HasTOTP() {
+		ctrl.expireLogin(w, r)
+		return
+	}
+
+	ctx := ctrl.view.NewTOTPContext(r)
+	ctrl.view.TOTP.Render(w, ctx)
+}
+
+// LoginTOTP checks the second factor of the user who entered their password
+// and logs them in. After too many wrong codes the login is cancelled.
+func (ctrl *AuthController) LoginTOTP(w http.ResponseWriter, r *http.Request) {
+	user := session.PendingUser(r)
+	if user == nil || !user.HasTOTP() {
+		ctrl.expireLogin(w, r)
+		return
+	}
+
+	// Wrong codes count as failed logins, so the account is locked if the
+	// password is known but the codes are guessed
+	err := ctrl.checkThrottle(r, user.UID)
+	if err != nil {
+		session.Logout(r)
+		note := model.NewErrorNotification("Login failed", err.Error())
+		httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+		return
+	}
+
+	usedRecoveryCode, err := ctrl.checkSecondFactor(r, user)
+	if err == errInvalidCode {
+		ctrl.failLogin(r, user.UID)
+		attempts := session.Get().GetInt(r.Context(), totpAttemptsSessionKey) + 1
+		session.Get().Put(r.Context(), totpAttemptsSessionKey, attempts)
+		if attempts >= maxTOTPAttempts {
+			log.Infof("Cancelling login of user '%s' after %d wrong codes", user.UID, attempts)
+			session.Logout(r)
+			note := model.NewErrorNotification("Login failed", "too many wrong codes, please log in again")
+			httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+			return
+		}
+	}
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Login failed", err.Error())
+		return
+	}
+
+	err = session.Login(r, user)
 	if err != nil {
 		httpext.ReloadWithError(w, r, "Login failed", err.Error())
 		return
 	}
+	session.Get().Remove(r.Context(), totpAttemptsSessionKey)
+	ctrl.resetThrottle(user)
+
+	if usedRecoveryCode {
+		codeHashes, _ := ctrl.user.store.FindRecoveryCodes(user.ID)
+		msg := fmt.Sprintf("You logged in with a recovery code, %d of them are left", len(codeHashes))
+		note := model.NewSuccessNotification("Recovery code used", msg)
+		httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+		return
+	}
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// ServeTOTPLoginEnablePage serves the page for enabling two-factor
+// authentication to admins who have to do so before they can log in.
+func (ctrl *AuthController) ServeTOTPLoginEnablePage(w http.ResponseWriter, r *http.Request) {
+	user := session.PendingUser(r)
+	if user == nil || !user.NeedsTOTP() {
+		ctrl.expireLogin(w, r)
+		return
+	}
+
+	ctrl.serveEnablePage(w, r, user, "/login/totp/enable")
+}
+
+// LoginEnableTOTP enables two-factor authentication for an admin who has to
+// do so before they can log in, and logs them in.
+func (ctrl *AuthController) LoginEnableTOTP(w http.ResponseWriter, r *http.Request) {
+	user := session.PendingUser(r)
+	if user == nil || !user.NeedsTOTP() {
+		ctrl.expireLogin(w, r)
+		return
+	}
+
+	codes, err := ctrl.enableTOTP(r, user)
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Failed to enable two-factor authentication", err.Error())
+		return
+	}
+
+	err = session.Login(r, user)
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Login failed", err.Error())
+		return
+	}
+
+	ctrl.showRecoveryCodes(w, r, codes)
+}
+
+// ServeTOTPEnablePage serves the page for enabling two-factor authentication
+// from the profile.
+func (ctrl *AuthController) ServeTOTPEnablePage(w http.ResponseWriter, r *http.Request) {
+	user := session.User(r)
+	if user.AuthMode != config.AuthStandard || user.HasTOTP() {
+		note := model.NewErrorNotification("Failed to enable two-factor authentication", "two-factor authentication can only be enabled once for users with a password")
+		httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+		return
+	}
+
+	ctrl.serveEnablePage(w, r, user, "/profile/totp")
+}
+
+// EnableTOTP enables two-factor authentication for the logged in user.
+func (ctrl *AuthController) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	codes, err := ctrl.enableTOTP(r, session.User(r))
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Failed to enable two-factor authentication", err.Error())
+		return
+	}
+
+	ctrl.showRecoveryCodes(w, r, codes)
+}
+
+// ServeRecoveryPage shows the recovery codes created when two-factor
+// authentication was enabled. They are only shown once.
+func (ctrl *AuthController) ServeRecoveryPage(w http.ResponseWriter, r *http.Request) {
+	codes := session.Get().PopString(r.Context(), recoveryCodesSessionKey)
+	if codes == "" {
+		http.Redirect(w, r, "/profile", http.StatusSeeOther)
+		return
+	}
+
+	ctx := ctrl.view.NewRecoveryContext(r, strings.Split(codes, " "))
+	ctrl.view.Recovery.Render(w, ctx)
+}
+
+// DisableTOTP disables two-factor authentication for the logged in user, who
+// has to enter a code to do so.
+func (ctrl *AuthController) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	err := ctrl.disableTOTP(r, session.User(r))
+	if err != nil {
+		note := model.NewErrorNotification("Failed to disable two-factor authentication", err.Error())
+		httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+		return
+	}
+
+	note := model.NewSuccessNotification("Disabled", "Two-factor authentication disabled successfully")
+	httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+}
+
+// StartOIDCLogin sends the user to the OpenID Connect provider to log in.
+func (ctrl *AuthController) StartOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	login, err := auth.NewOIDCLogin()
+	var location string
+	if err == nil {
+		location, err = ctrl.oidc.AuthCodeURL(login)
+	}
+	if err != nil {
+		note := model.NewErrorNotification("Login failed", err.Error())
+		httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+		return
+	}
+
+	session.Get().Put(r.Context(), oidcStateSessionKey, login.State)
+	session.Get().Put(r.Context(), oidcNonceSessionKey, login.Nonce)
+	session.Get().Put(r.Context(), oidcVerifierSessionKey, login.Verifier)
+	http.Redirect(w, r, location, http.StatusFound)
+}
+
+// FinishOIDCLogin logs in the user the OpenID Connect provider redirected
+// back.
+func (ctrl *AuthController) FinishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	_, err := ctrl.loginOIDC(r)
+	if err != nil {
+		log.Debugln("OpenID Connect login failed:", err)
+		note := model.NewErrorNotification("Login failed", err.Error())
+		httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+		return
+	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
@@ -84,12 +339,37 @@ func (ctrl *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 	userTmpl.Role = sql.NullInt32{Int32: int32(authRole), Valid: true}
 	userTmpl.Theme = sql.NullInt32{Int32: int32(theme), Valid: true}
 
+	verify := ctrl.verifiesEmail()
+	if verify {
+		err = ctrl.checkRegisterEmail(userTmpl)
+		if err != nil {
+			httpext.ReloadWithError(w, r, "Failed to register", err.Error())
+			return
+		}
+		userTmpl.EmailVerified = sql.NullBool{Bool: false, Valid: true}
+	}
+
 	user, err := ctrl.user.createUser(userTmpl)
 	if err != nil {
 		httpext.ReloadWithError(w, r, "Failed to register", err.Error())
 		return
 	}
 
+	// Users who have to verify their email address log in once they did
+	if verify {
+		_, err = ctrl.sendVerifyMail(user)
+		if err != nil {
+			note := model.NewErrorNotification("Failed to send mail", "Your account was created, but the mail to verify your email address couldn't be sent. Log in to send it again.")
+			httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+			return
+		}
+
+		msg := fmt.Sprintf("A link to verify your email address was sent to <b>%s</b>, open it to log in", html.EscapeString(user.Email.String))
+		note := model.NewSuccessNotification("Registered", msg)
+		httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+		return
+	}
+
 	err = session.Login(r, user)
 	if err != nil {
 		httpext.ReloadWithError(w, r, "Failed to login", err.Error())
@@ -100,6 +380,115 @@ func (ctrl *AuthController) Register(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// ServeForgotPasswordPage serves the page for requesting a password reset
+// link.
+func (ctrl *AuthController) ServeForgotPasswordPage(w http.ResponseWriter, r *http.Request) {
+	ctx := ctrl.view.NewForgotContext(r)
+	ctrl.view.Forgot.Render(w, ctx)
+}
+
+// ForgotPassword sends a password reset link to the user with the given
+// email address. The response is the same whether or not the user exists,
+// and the mail is sent in the background so the response doesn't take longer
+// either, which would tell who has an account.
+func (ctrl *AuthController) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.FormValue("email"))
+	user, err := ctrl.user.store.FindByEmail(email)
+	if err == nil && user.AuthMode == config.AuthStandard {
+		go func() {
+			sent, err := ctrl.sendResetMail(user)
+			if err != nil {
+				log.Errorf("Failed to send password reset mail to user '%s': %s", user.UID, err)
+			} else if !sent {
+				log.Debugf("Not sending another password reset mail to user '%s' yet", user.UID)
+			}
+		}()
+	} else if err != nil && err != sql.ErrNoRows {
+		log.Errorln("Failed to find user to reset password:", err)
+	}
+
+	msg := "If an account with this email address exists, a link to reset its password was sent to it"
+	note := model.NewSuccessNotification("Link sent", msg)
+	httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+}
+
+// ServeResetPasswordPage serves the page for choosing a new password, which
+// is opened from the link of a password reset mail.
+func (ctrl *AuthController) ServeResetPasswordPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := ctrl.findResetUser(token)
+	if err != nil {
+		note := model.NewErrorNotification("Failed to reset password", err.Error())
+		httpext.RedirectWithNotify(w, r, "/forgot-password", http.StatusSeeOther, note)
+		return
+	}
+
+	ctx := ctrl.view.NewResetContext(r, token)
+	ctrl.view.Reset.Render(w, ctx)
+}
+
+// ResetPassword sets the new password of the user of a password reset link.
+// The link can't be used again, since it's only valid for the old password.
+func (ctrl *AuthController) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	user, err := ctrl.findResetUser(token)
+	if err != nil {
+		note := model.NewErrorNotification("Failed to reset password", err.Error())
+		httpext.RedirectWithNotify(w, r, "/forgot-password", http.StatusSeeOther, note)
+		return
+	}
+
+	password := r.FormValue("password")
+	if password == "" || password != r.FormValue("password_confirm") {
+		note := model.NewErrorNotification("Failed to reset password", "passwords do not match")
+		httpext.RedirectWithNotify(w, r, "/reset-password?token="+url.QueryEscape(token), http.StatusSeeOther, note)
+		return
+	}
+
+	// Opening the link proves that the email address belongs to the user
+	userTmpl := model.UserTemplate{
+		ID:            sql.NullInt64{Int64: user.ID, Valid: true},
+		Password:      sql.NullString{String: password, Valid: true},
+		EmailVerified: sql.NullBool{Bool: true, Valid: true},
+	}
+
+	_, err = ctrl.user.store.Update(&userTmpl)
+	if err != nil {
+		note := model.NewErrorNotification("Failed to reset password", err.Error())
+		httpext.RedirectWithNotify(w, r, "/reset-password?token="+url.QueryEscape(token), http.StatusSeeOther, note)
+		return
+	}
+
+	// Users who can receive mail for the account don't have to wait for it
+	// to be unlocked
+	ctrl.resetThrottle(user)
+	log.Infof("User '%s' reset their password", user.UID)
+	note := model.NewSuccessNotification("Password changed", "Your password was changed, you can log in with it now")
+	httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+}
+
+// VerifyEmail marks the email address of the user of a verification link as
+// verified, after which they can log in.
+func (ctrl *AuthController) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := ctrl.findTokenUser(verifyPurpose, r.URL.Query().Get("token"), verifyStamp)
+	if err == nil && !user.EmailVerified {
+		userTmpl := model.UserTemplate{
+			ID:            sql.NullInt64{Int64: user.ID, Valid: true},
+			EmailVerified: sql.NullBool{Bool: true, Valid: true},
+		}
+		_, err = ctrl.user.store.Update(&userTmpl)
+	}
+	if err != nil {
+		note := model.NewErrorNotification("Failed to verify email address", err.Error())
+		httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+		return
+	}
+
+	log.Infof("User '%s' verified their email address", user.UID)
+	note := model.NewSuccessNotification("Email verified", "Your email address was verified, you can log in now")
+	httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+}
+
 func (ctrl *AuthController) login(r *http.Request) (*model.User, error) {
 	err := r.ParseForm()
 	if err != nil {
@@ -108,22 +497,527 @@ func (ctrl *AuthController) login(r *http.Request) (*model.User, error) {
 
 	username := r.FormValue("username")
 	password := r.FormValue("password")
+	err = ctrl.checkThrottle(r, username)
+	if err != nil {
+		return nil, err
+	}
+
 	user, err := ctrl.user.store.FindByUID(username)
+	if err == sql.ErrNoRows {
+		user = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case user == nil && ctrl.ldap != nil:
+		user, err = ctrl.authenticateLDAP(username, password)
+	case user == nil:
+		err = auth.ErrInvalidCredentials
+	case user.AuthMode == config.AuthLDAP:
+		user, err = ctrl.authenticateLDAP(username, password)
+	default:
+		err = ctrl.authenticateStandard(password, user)
+	}
+
+	if err == auth.ErrInvalidCredentials {
+		ctrl.failLogin(r, username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A new link is sent at most every mailInterval, so logging in can't be
+	// used to flood the mailbox of the user
+	if !user.CanLogin() {
+		sent, err := ctrl.sendVerifyMail(user)
+		if err != nil {
+			log.Errorf("Failed to send verification mail to user '%s': %s", user.UID, err)
+			return nil, errors.New("your email address isn't verified yet, and sending a new link failed")
+		} else if !sent {
+			interval := fmtutil.FormatDuration(mailInterval, 0)
+			return nil, fmt.Errorf("your email address isn't verified yet, open the link sent to it, a new one can be sent every %s", interval)
+		}
+		return nil, errors.New("your email address isn't verified yet, a new link was sent to it")
+	}
+
+	// Users with two-factor authentication, or who have to enable it, aren't
+	// logged in until they entered a code
+	if user.HasTOTP() || user.NeedsTOTP() {
+		session.Get().Remove(r.Context(), totpAttemptsSessionKey)
+		return user, session.BeginLogin(r, user)
+	}
+
+	err = session.Login(r, user)
 	if err != nil {
-		return nil, errors.New("invalid username or password")
+		return nil, err
 	}
 
-	err = auth.CheckPasswordHash(password, user.PasswordHash.String)
+	ctrl.resetThrottle(user)
+	return user, nil
+}
+
+// expireLogin sends users without a pending login back to the login page.
+func (ctrl *AuthController) expireLogin(w http.ResponseWriter, r *http.Request) {
+	note := model.NewErrorNotification("Login failed", "the login has expired, please try again")
+	httpext.RedirectWithNotify(w, r, "/login", http.StatusSeeOther, note)
+}
+
+// checkSecondFactor checks the code entered by the user, which is either a
+// TOTP code or one of their recovery codes. Both can only be used once.
+func (ctrl *AuthController) checkSecondFactor(r *http.Request, user *model.User) (bool, error) {
+	code := r.FormValue("code")
+	if step, ok := auth.CheckTOTP(user.TOTPSecret.String, code, time.Now()); ok {
+		used, err := ctrl.user.store.UseTOTPStep(user.ID, step)
+		if err != nil {
+			return false, err
+		} else if !used {
+			return false, errors.New("the code was already used, please wait for the next one")
+		}
+		return false, nil
+	}
+
+	used, err := ctrl.user.store.UseRecoveryCode(user.ID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	} else if !used {
+		log.Debugf("User '%s' entered a wrong code", user.UID)
+		return false, errInvalidCode
+	}
+
+	log.Infof("User '%s' used a recovery code", user.UID)
+	return true, nil
+}
+
+// serveEnablePage shows a new secret to the user. The secret is kept in the
+// session until the user confirms that their app is set up, so reloading the
+// page doesn't change it.
+func (ctrl *AuthController) serveEnablePage(w http.ResponseWriter, r *http.Request, user *model.User, action string) {
+	secret := session.Get().GetString(r.Context(), totpSecretSessionKey)
+	if secret == "" {
+		var err error
+		secret, err = auth.GenerateTOTPSecret()
+		if err != nil {
+			ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve enable two-factor authentication page:", err.Error()))
+			return
+		}
+		session.Get().Put(r.Context(), totpSecretSessionKey, secret)
+	}
+
+	qrCode, err := auth.QRCode(auth.TOTPURL(config.Get().Authentication.Standard.TOTP.Issuer, user.UID, secret))
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve enable two-factor authentication page:", err.Error()))
+		return
+	}
+
+	ctx := ctrl.view.NewEnrollContext(r, action, secret, qrCode)
+	ctrl.view.Enroll.Render(w, ctx)
+}
+
+// enableTOTP enables two-factor authentication with the secret shown to the
+// user once they entered a code of it, and returns their new recovery codes.
+// Only the hashes of the recovery codes are stored.
+func (ctrl *AuthController) enableTOTP(r *http.Request, user *model.User) ([]string, error) {
+	if user.AuthMode != config.AuthStandard {
+		return nil, errors.New("only users with a password can enable two-factor authentication")
+	} else if user.HasTOTP() {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+
+	secret := session.Get().GetString(r.Context(), totpSecretSessionKey)
+	if secret == "" {
+		return nil, errors.New("the setup has expired, please scan the new QR code")
+	}
+
+	step, ok := auth.CheckTOTP(secret, r.FormValue("code"), time.Now())
+	if !ok {
+		return nil, errInvalidCode
+	}
+
+	codes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	codeHashes := make([]string, len(codes))
+	for i, code := range codes {
+		codeHashes[i] = auth.HashRecoveryCode(code)
+	}
+
+	err = ctrl.user.store.EnableTOTP(user.ID, secret, codeHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	// The code used to confirm can't be used again to log in
+	_, err = ctrl.user.store.UseTOTPStep(user.ID, step)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("User '%s' enabled two-factor authentication", user.UID)
+	session.Get().Remove(r.Context(), totpSecretSessionKey)
+	return codes, nil
+}
+
+// showRecoveryCodes sends the user to the page showing their new recovery
+// codes, which are kept in the session until then.
+func (ctrl *AuthController) showRecoveryCodes(w http.ResponseWriter, r *http.Request, codes []string) {
+	session.Get().Put(r.Context(), recoveryCodesSessionKey, strings.Join(codes, " "))
+	http.Redirect(w, r, "/profile/totp/recovery", http.StatusSeeOther)
+}
+
+// disableTOTP disables two-factor authentication for the user once they
+// entered a code. Admins can't disable it if they are required to use it.
+func (ctrl *AuthController) disableTOTP(r *http.Request, user *model.User) error {
+	if !user.HasTOTP() {
+		return errors.New("two-factor authentication isn't enabled")
+	} else if config.Get().Authentication.Standard.TOTP.RequireForAdmins && user.Role == config.RoleAdmin {
+		return errors.New("admins are required to use two-factor authentication")
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		return err
+	}
+
+	_, err = ctrl.checkSecondFactor(r, user)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("User '%s' disabled two-factor authentication", user.UID)
+	return ctrl.user.store.DisableTOTP(user.ID)
+}
+
+// checkThrottle returns an error if logging in to the account, or from the
+// address of the request, has to wait because of failed logins.
+func (ctrl *AuthController) checkThrottle(r *http.Request, uid string) error {
+	throttle := ctrl.user.throttle
+	if throttle == nil {
+		return nil
+	}
+
+	err := throttle.Check(uid, throttle.Address(r), time.Now())
+	if _, ok := err.(*auth.ThrottleError); ok {
+		log.Debugf("Refusing login of user '%s' from %s: %s", uid, throttle.Address(r), err)
+	}
+	return err
+}
+
+// failLogin records a failed login of the account from the address of the
+// request, and adds the lockouts it caused to the audit log.
+func (ctrl *AuthController) failLogin(r *http.Request, uid string) {
+	throttle := ctrl.user.throttle
+	if throttle == nil {
+		return
+	}
+
+	address := throttle.Address(r)
+	lockout, err := throttle.Fail(uid, address, time.Now())
+	if err != nil {
+		log.Errorf("Failed to record failed login of user '%s': %s", uid, err)
+		return
+	}
+
+	duration := fmtutil.FormatDuration(throttle.Duration, 0)
+	if lockout.Account {
+		log.Warnf("Locked user '%s' for %s after %d failed logins, the last one from %s", uid, duration, throttle.MaxAttempts, address)
+		ctrl.user.audit(&model.AuditEntry{
+			Event:   model.AuditAccountLocked,
+			UID:     uid,
+			Address: address,
+			Detail:  fmt.Sprintf("locked for %s after %d failed logins", duration, throttle.MaxAttempts),
+		})
+	}
+
+	if lockout.Address {
+		log.Warnf("Locked address %s for %s after %d failed logins", address, duration, throttle.MaxAttemptsPerIP)
+		ctrl.user.audit(&model.AuditEntry{
+			Event:   model.AuditAddressLocked,
+			Address: address,
+			Detail:  fmt.Sprintf("locked for %s after %d failed logins, the last one as '%s'", duration, throttle.MaxAttemptsPerIP, uid),
+		})
+	}
+}
+
+// resetThrottle forgets the failed logins of the user after logging in.
+func (ctrl *AuthController) resetThrottle(user *model.User) {
+	if ctrl.user.throttle == nil {
+		return
+	}
+
+	err := ctrl.user.throttle.Reset(user.UID)
+	if err != nil {
+		log.Errorf("Failed to reset failed logins of user '%s': %s", user.UID, err)
+	}
+}
+
+// verifiesEmail returns whether self-registered users have to verify their
+// email address before they can log in.
+func (ctrl *AuthController) verifiesEmail() bool {
+	return ctrl.mailer != nil && config.Get().Authentication.Standard.VerifyEmail
+}
+
+// checkRegisterEmail makes sure that users who have to verify their email
+// address register with one that isn't used yet, so password reset links
+// are sent to the right user.
+func (ctrl *AuthController) checkRegisterEmail(userTmpl *model.UserTemplate) error {
+	if !userTmpl.Email.Valid {
+		return errors.New("an email address is required")
+	}
+
+	_, err := ctrl.user.store.FindByEmail(userTmpl.Email.String)
+	if err == nil {
+		return errors.New("email address already taken")
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+	return nil
+}
+
+// sendResetMail sends a link to reset their password to the user, unless
+// one was sent recently. It returns whether the mail was sent.
+func (ctrl *AuthController) sendResetMail(user *model.User) (bool, error) {
+	return ctrl.sendLimited(fmt.Sprintf("%s:%d", resetPurpose, user.ID), func() error {
+		subject := fmt.Sprintf("%d:%s", user.ID, resetStamp(user))
+		token := ctrl.signer.Sign(resetPurpose, subject, time.Now().Add(resetLifetime))
+		return ctrl.sendLink(user, "reset", "Reset your password", "/reset-password?token="+token)
+	})
+}
+
+// sendVerifyMail sends a link to verify their email address to the user,
+// unless one was sent recently. It returns whether the mail was sent.
+func (ctrl *AuthController) sendVerifyMail(user *model.User) (bool, error) {
+	return ctrl.sendLimited(fmt.Sprintf("%s:%d", verifyPurpose, user.ID), func() error {
+		subject := fmt.Sprintf("%d:%s", user.ID, verifyStamp(user))
+		token := ctrl.signer.Sign(verifyPurpose, subject, time.Now().Add(verifyLifetime))
+		return ctrl.sendLink(user, "verify", "Verify your email address", "/verify-email?token="+token)
+	})
+}
+
+// sendLimited sends the mail with the given key, unless it was sent within
+// the last mailInterval. Mails that failed to send can be sent again right
+// away.
+func (ctrl *AuthController) sendLimited(key string, send func() error) (bool, error) {
+	allowed, err := ctrl.mailLimit.Allow(key, time.Now())
+	if err != nil || !allowed {
+		return false, err
+	}
+
+	err = send()
+	if err != nil {
+		if err := ctrl.mailLimit.Forget(key); err != nil {
+			log.Errorf("Failed to forget mail '%s': %s", key, err)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// sendLink sends the mail with the given name containing a link to the path,
+// which is relative to the configured base URL.
+func (ctrl *AuthController) sendLink(user *model.User, name string, subject string, path string) error {
+	link := strings.TrimRight(config.Get().SMTP.BaseURL, "/") + path
+	body, err := ctrl.mailView.Render(name, ctrl.mailView.NewMailContext(user, link))
+	if err != nil {
+		return err
+	}
+
+	return ctrl.mailer.Send(user.Email.String, fmt.Sprintf("%s - %s", subject, config.Get().Theme.Title), body)
+}
+
+// findResetUser returns the user of a password reset link. Only users with a
+// password can reset it.
+func (ctrl *AuthController) findResetUser(token string) (*model.User, error) {
+	user, err := ctrl.findTokenUser(resetPurpose, token, resetStamp)
+	if err != nil {
+		return nil, err
+	} else if user.AuthMode != config.AuthStandard {
+		return nil, auth.ErrInvalidSignedToken
+	}
+	return user, nil
+}
+
+// findTokenUser returns the user of a signed token. Tokens contain the id of
+// the user and a stamp of the value they depend on, and are invalid once the
+// value has changed.
+func (ctrl *AuthController) findTokenUser(purpose string, token string, stamp func(*model.User) string) (*model.User, error) {
+	subject, err := ctrl.signer.Verify(purpose, token, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(subject, ":", 2)
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		return nil, auth.ErrInvalidSignedToken
+	}
+
+	user, err := ctrl.user.store.FindByID(id)
+	if err == sql.ErrNoRows {
+		return nil, auth.ErrInvalidSignedToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(stamp(user)), []byte(parts[1])) != 1 {
+		return nil, auth.ErrInvalidSignedToken
+	}
+	return user, nil
+}
+
+// resetStamp returns the stamp of password reset links, which depends on the
+// password hash so a link can only be used once.
+func resetStamp(user *model.User) string {
+	return auth.HashToken(user.PasswordHash.String)[:tokenStampLength]
+}
+
+// verifyStamp returns the stamp of verification links, which depends on the
+// email address so a link can't verify another address.
+func verifyStamp(user *model.User) string {
+	return auth.HashToken(strings.ToLower(user.Email.String))[:tokenStampLength]
+}
+
+func (ctrl *AuthController) authenticateStandard(password string, user *model.User) error {
+	err := auth.CheckPasswordHash(password, user.PasswordHash.String)
 	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return nil, errors.New("invalid username or password")
+		return auth.ErrInvalidCredentials
+	}
+	return err
+}
+
+// authenticateLDAP checks the credentials against the LDAP directory and
+// creates or updates the local user to match the directory entry.
+func (ctrl *AuthController) authenticateLDAP(username string, password string) (*model.User, error) {
+	if ctrl.ldap == nil {
+		log.Debugf("User '%s' uses LDAP but LDAP authentication is disabled", username)
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	ldapUser, err := ctrl.ldap.Authenticate(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return provisionLDAP(ctrl.user.store, ldapUser)
+}
+
+// provisionLDAP creates or updates the local user of a directory entry. The
+// user is looked up by the uid of the entry rather than the username as it
+// was typed, so every entry has a single local user. Users using another
+// authentication mode aren't taken over.
+func provisionLDAP(users store.UserRepository, ldapUser *auth.LDAPUser) (*model.User, error) {
+	user, err := users.FindByUID(ldapUser.UID)
+	if err == sql.ErrNoRows {
+		user = nil
 	} else if err != nil {
 		return nil, err
 	}
 
-	err = session.Login(r, user)
+	if user != nil && user.AuthMode != config.AuthLDAP {
+		log.Warnf("User '%s' logged in with LDAP already exists with %s authentication", user.UID, user.AuthMode)
+		return nil, auth.ErrInvalidCredentials
+	}
+
+	userTmpl := model.UserTemplate{
+		UID:      sql.NullString{String: ldapUser.UID, Valid: true},
+		Name:     sql.NullString{String: ldapUser.Name, Valid: ldapUser.Name != ""},
+		Email:    sql.NullString{String: ldapUser.Email, Valid: ldapUser.Email != ""},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthLDAP), Valid: true},
+		Role:     sql.NullInt32{Int32: int32(ldapUser.Role), Valid: true},
+	}
+
+	if user == nil {
+		log.Debugf("Creating user '%s' from LDAP entry %s", ldapUser.UID, ldapUser.DN)
+		userTmpl.Theme = sql.NullInt32{Int32: int32(config.Get().Theme.Default), Valid: true}
+		return users.Insert(&userTmpl)
+	}
+
+	userTmpl.ID = sql.NullInt64{Int64: user.ID, Valid: true}
+	return users.Update(&userTmpl)
+}
+
+// loginOIDC checks that the redirect of the provider belongs to the login the
+// user started, and logs in the user of the provider. The secrets of the login
+// are removed from the session, so they can only be used once.
+func (ctrl *AuthController) loginOIDC(r *http.Request) (*model.User, error) {
+	login := &auth.OIDCLogin{
+		State:    session.Get().PopString(r.Context(), oidcStateSessionKey),
+		Nonce:    session.Get().PopString(r.Context(), oidcNonceSessionKey),
+		Verifier: session.Get().PopString(r.Context(), oidcVerifierSessionKey),
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		return nil, fmt.Errorf("the provider returned %s %s", query.Get("error"), query.Get("error_description"))
+	} else if !login.CheckState(query.Get("state")) {
+		return nil, errors.New("the login has expired, please try again")
+	}
+
+	oidcUser, err := ctrl.oidc.Authenticate(query.Get("code"), login)
 	if err != nil {
 		return nil, err
 	}
 
+	user, err := provisionOIDC(ctrl.user.store, oidcUser)
+	if err != nil {
+		return nil, err
+	}
+
+	err = session.Login(r, user)
+	if err != nil {
+		return nil, err
+	}
 	return user, nil
 }
+
+// provisionOIDC creates or updates the local user of the provider's subject.
+// Users are looked up by the issuer and subject of the ID token, which never
+// change, and never by the uid or email, which users can often change at the
+// provider. A new subject whose uid is taken gets an error instead of the
+// account of the uid. The uid of a user is kept as it was created.
+func provisionOIDC(users store.UserRepository, oidcUser *auth.OIDCUser) (*model.User, error) {
+	user, err := users.FindByOIDC(oidcUser.Issuer, oidcUser.Subject)
+	if err == sql.ErrNoRows {
+		return createOIDCUser(users, oidcUser)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if user.AuthMode != config.AuthOIDC {
+		log.Warnf("User '%s' of OpenID Connect subject %s now uses %s authentication", user.UID, oidcUser.Subject, user.AuthMode)
+		return nil, fmt.Errorf("user '%s' doesn't use OpenID Connect", user.UID)
+	}
+
+	userTmpl := model.UserTemplate{
+		ID:    sql.NullInt64{Int64: user.ID, Valid: true},
+		Name:  sql.NullString{String: oidcUser.Name, Valid: oidcUser.Name != ""},
+		Email: sql.NullString{String: oidcUser.Email, Valid: oidcUser.Email != ""},
+		Role:  sql.NullInt32{Int32: int32(oidcUser.Role), Valid: true},
+	}
+	return users.Update(&userTmpl)
+}
+
+// createOIDCUser creates the local user on the first login of a subject.
+func createOIDCUser(users store.UserRepository, oidcUser *auth.OIDCUser) (*model.User, error) {
+	_, err := users.FindByUID(oidcUser.UID)
+	if err == nil {
+		log.Warnf("User '%s' logged in with OpenID Connect as subject %s already exists", oidcUser.UID, oidcUser.Subject)
+		return nil, fmt.Errorf("user '%s' already exists", oidcUser.UID)
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	log.Debugf("Creating user '%s' from OpenID Connect subject %s", oidcUser.UID, oidcUser.Subject)
+	return users.Insert(&model.UserTemplate{
+		UID:         sql.NullString{String: oidcUser.UID, Valid: true},
+		Name:        sql.NullString{String: oidcUser.Name, Valid: oidcUser.Name != ""},
+		Email:       sql.NullString{String: oidcUser.Email, Valid: oidcUser.Email != ""},
+		AuthMode:    sql.NullInt32{Int32: int32(config.AuthOIDC), Valid: true},
+		Role:        sql.NullInt32{Int32: int32(oidcUser.Role), Valid: true},
+		Theme:       sql.NullInt32{Int32: int32(config.Get().Theme.Default), Valid: true},
+		OIDCIssuer:  sql.NullString{String: oidcUser.Issuer, Valid: true},
+		OIDCSubject: sql.NullString{String: oidcUser.Subject, Valid: true},
+	})
+}
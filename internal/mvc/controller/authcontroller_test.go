@@ -0,0 +1,465 @@
+package controller
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/mvc/view"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/mail"
+
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+func TestProvisionLDAP(t *testing.T) {
+	config.NewDefaultConfig()
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+
+	ldapUser := &auth.LDAPUser{
+		DN:    "uid=alice,ou=people,dc=example,dc=org",
+		UID:   "alice",
+		Name:  "Alice Example",
+		Email: "alice@example.org",
+		Role:  config.RoleEditor,
+	}
+	created, err := provisionLDAP(users, ldapUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ldapUser.Name = "Alice Changed"
+	ldapUser.Role = config.RoleAdmin
+	updated, err := provisionLDAP(users, ldapUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.ID != created.ID {
+		t.Errorf("expected user %d to be updated, got user %d", created.ID, updated.ID)
+	}
+	if updated.Name != "Alice Changed" || updated.Role != config.RoleAdmin {
+		t.Errorf("expected the directory attributes to be applied, got %+v", updated)
+	}
+
+	if count := users.Count(); count != 1 {
+		t.Errorf("expected a single user, got %d", count)
+	}
+}
+
+func TestProvisionLDAPKeepsOtherUsers(t *testing.T) {
+	config.NewDefaultConfig()
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+
+	_, err := users.Insert(&model.UserTemplate{
+		UID:      sql.NullString{String: "Bob", Valid: true},
+		Password: sql.NullString{String: "hash", Valid: true},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthStandard), Valid: true},
+		Role:     sql.NullInt32{Int32: int32(config.RoleAdmin), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provisionLDAP(users, &auth.LDAPUser{UID: "bob", Role: config.RoleEditor})
+	if err != auth.ErrInvalidCredentials {
+		t.Errorf("expected %v, got %v", auth.ErrInvalidCredentials, err)
+	}
+}
+
+func newTestOIDCUser(subject string, uid string) *auth.OIDCUser {
+	return &auth.OIDCUser{
+		Issuer:  "https://id.example.org",
+		Subject: subject,
+		UID:     uid,
+		Name:    "Alice Example",
+		Email:   "alice@example.org",
+		Role:    config.RoleEditor,
+	}
+}
+
+func TestProvisionOIDC(t *testing.T) {
+	config.NewDefaultConfig()
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+
+	created, err := provisionOIDC(users, newTestOIDCUser("248289761001", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.OIDCIssuer.String != "https://id.example.org" || created.OIDCSubject.String != "248289761001" {
+		t.Errorf("expected the issuer and subject to be stored, got %+v", created)
+	}
+
+	// Users can change their username and email at many providers
+	oidcUser := newTestOIDCUser("248289761001", "alice.example")
+	oidcUser.Email = "alice@example.com"
+	oidcUser.Role = config.RoleAdmin
+	updated, err := provisionOIDC(users, oidcUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if updated.ID != created.ID {
+		t.Errorf("expected user %d to be updated, got user %d", created.ID, updated.ID)
+	}
+	if updated.UID != "alice" || updated.Email.String != "alice@example.com" || updated.Role != config.RoleAdmin {
+		t.Errorf("expected the uid to be kept and the claims to be applied, got %+v", updated)
+	}
+	if count := users.Count(); count != 1 {
+		t.Errorf("expected a single user, got %d", count)
+	}
+}
+
+func TestProvisionOIDCDoesntLinkByClaims(t *testing.T) {
+	config.NewDefaultConfig()
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+
+	_, err := provisionOIDC(users, newTestOIDCUser("248289761001", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = users.Insert(&model.UserTemplate{
+		UID:      sql.NullString{String: "bob", Valid: true},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthStandard), Valid: true},
+		Role:     sql.NullInt32{Int32: int32(config.RoleAdmin), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		oidcUser *auth.OIDCUser
+	}{
+		{"other subject", newTestOIDCUser("other", "alice")},
+		{"other issuer", &auth.OIDCUser{Issuer: "https://evil.example.org", Subject: "248289761001", UID: "alice"}},
+		{"standard user", newTestOIDCUser("bob", "bob")},
+	}
+
+	for _, test := range tests {
+		_, err := provisionOIDC(users, test.oidcUser)
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("%s: expected an error about the existing user, got %v", test.name, err)
+		}
+	}
+
+	if count := users.Count(); count != 2 {
+		t.Errorf("expected no new users, got %d", count)
+	}
+}
+
+func TestProvisionOIDCKeepsOtherAuthModes(t *testing.T) {
+	config.NewDefaultConfig()
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+
+	created, err := provisionOIDC(users, newTestOIDCUser("248289761001", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = users.Update(&model.UserTemplate{
+		ID:       sql.NullInt64{Int64: created.ID, Valid: true},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthStandard), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = provisionOIDC(users, newTestOIDCUser("248289761001", "alice"))
+	if err == nil {
+		t.Errorf("expected a user switched to standard authentication not to be logged in")
+	}
+}
+
+// smtpSink is a stand-in for a mail server keeping the mails it receives.
+// Mails are received before the server answers, so they're there as soon as
+// sending returns.
+type smtpSink struct {
+	listener net.Listener
+	mails    chan string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	sink := &smtpSink{listener: listener, mails: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (sink *smtpSink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 sink.test ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(strings.SplitN(line, " ", 2)[0]) {
+		case "DATA":
+			text.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			sink.mails <- string(data)
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+// token returns the token of the link in the next mail, which has to be sent
+// to the address.
+func (sink *smtpSink) token(t *testing.T, to string) string {
+	t.Helper()
+	select {
+	case data := <-sink.mails:
+		msg, err := netmail.ReadMessage(strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Header.Get("To") != "<"+to+">" {
+			t.Errorf("expected a mail to %s, got one to %s", to, msg.Header.Get("To"))
+		}
+
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(msg.Body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range strings.Split(string(body), "\n") {
+			if strings.HasPrefix(line, "https://bingo.test/") {
+				link, err := url.Parse(line)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return link.Query().Get("token")
+			}
+		}
+		t.Fatalf("expected a link in the mail, got %q", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a mail")
+	}
+	return ""
+}
+
+func (sink *smtpSink) expectNoMail(t *testing.T) {
+	t.Helper()
+	select {
+	case data := <-sink.mails:
+		t.Errorf("expected no mail, got %q", data)
+	default:
+	}
+}
+
+// newMailTestController returns a controller sending mail to a sink, with a
+// user who has to verify their email address.
+func newMailTestController(t *testing.T) (*AuthController, *smtpSink, *model.User) {
+	conf := config.NewDefaultConfig()
+	conf.Authentication.Secret = "secret"
+	conf.Authentication.Standard.VerifyEmail = true
+	conf.SMTP.Enabled = true
+	conf.SMTP.BaseURL = "https://bingo.test"
+
+	sink := newSMTPSink(t)
+	users := store.NewMemoryUserStore(store.NewMemoryDatabase())
+	ctrl := &AuthController{
+		user: &UserController{store: users},
+		mailer: &mail.Mailer{
+			Host:     "127.0.0.1",
+			Port:     sink.listener.Addr().(*net.TCPAddr).Port,
+			Security: mail.SecurityNone,
+			From:     &netmail.Address{Name: "bingo", Address: "bingo@bingo.test"},
+		},
+		mailLimit: auth.NewMailLimiter(memstore.NewWithCleanupInterval(0), mailInterval),
+		signer:    auth.NewTokenSigner(),
+		mailView:  &view.MailView{Template: template.Must(template.ParseGlob("../../../web/template/mail/*.go.txt"))},
+	}
+
+	user, err := users.Insert(&model.UserTemplate{
+		UID:           sql.NullString{String: "alice", Valid: true},
+		Name:          sql.NullString{String: "Alice", Valid: true},
+		Email:         sql.NullString{String: "alice@example.org", Valid: true},
+		EmailVerified: sql.NullBool{Bool: false, Valid: true},
+		Password:      sql.NullString{String: "password", Valid: true},
+		AuthMode:      sql.NullInt32{Int32: int32(config.AuthStandard), Valid: true},
+		Role:          sql.NullInt32{Int32: int32(config.RoleEditor), Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ctrl, sink, user
+}
+
+func TestResetMail(t *testing.T) {
+	ctrl, sink, user := newMailTestController(t)
+
+	sent, err := ctrl.sendResetMail(user)
+	if err != nil || !sent {
+		t.Fatalf("expected the mail to be sent, got %v, %v", sent, err)
+	}
+	token := sink.token(t, "alice@example.org")
+
+	found, err := ctrl.findResetUser(token)
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("expected the link to be valid for alice, got %+v, %v", found, err)
+	}
+
+	_, err = ctrl.signer.Verify(resetPurpose, token, time.Now().Add(resetLifetime+time.Minute))
+	if err != auth.ErrInvalidSignedToken {
+		t.Errorf("expected the link to expire, got %v", err)
+	}
+
+	subject := strings.SplitN(token, ".", 2)[0]
+	invalid := []string{
+		subject + "." + ctrl.signer.Sign(resetPurpose, "other", time.Now().Add(time.Hour))[len(subject)+1:],
+		ctrl.signer.Sign(verifyPurpose, fmt.Sprintf("%d:%s", user.ID, resetStamp(user)), time.Now().Add(time.Hour)),
+		ctrl.signer.Sign(resetPurpose, fmt.Sprintf("%d:%s", user.ID, resetStamp(user)), time.Now().Add(-time.Second)),
+		(&auth.TokenSigner{}).Sign(resetPurpose, fmt.Sprintf("%d:%s", user.ID, resetStamp(user)), time.Now().Add(time.Hour)),
+	}
+	for _, token := range invalid {
+		_, err = ctrl.findResetUser(token)
+		if err != auth.ErrInvalidSignedToken {
+			t.Errorf("expected %v for token %s, got %v", auth.ErrInvalidSignedToken, token, err)
+		}
+	}
+
+	// Resetting the password uses up the link
+	_, err = ctrl.user.store.Update(&model.UserTemplate{
+		ID:       sql.NullInt64{Int64: user.ID, Valid: true},
+		Password: sql.NullString{String: "changed", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctrl.findResetUser(token)
+	if err != auth.ErrInvalidSignedToken {
+		t.Errorf("expected the link to be invalid after resetting the password, got %v", err)
+	}
+}
+
+func TestResetMailLimit(t *testing.T) {
+	ctrl, sink, user := newMailTestController(t)
+
+	sent, err := ctrl.sendResetMail(user)
+	if err != nil || !sent {
+		t.Fatalf("expected the mail to be sent, got %v, %v", sent, err)
+	}
+	sink.token(t, "alice@example.org")
+
+	for i := 0; i < 3; i++ {
+		sent, err = ctrl.sendResetMail(user)
+		if err != nil || sent {
+			t.Errorf("expected no mail to be sent, got %v, %v", sent, err)
+		}
+	}
+	sink.expectNoMail(t)
+
+	// The limit is per mail, so users can still verify their address
+	sent, err = ctrl.sendVerifyMail(user)
+	if err != nil || !sent {
+		t.Errorf("expected the verification mail to be sent, got %v, %v", sent, err)
+	}
+	sink.token(t, "alice@example.org")
+}
+
+func TestLoginSendsVerifyMail(t *testing.T) {
+	ctrl, sink, user := newMailTestController(t)
+
+	login := func() error {
+		form := url.Values{"username": {"alice"}, "password": {"password"}}
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		_, err := ctrl.login(r)
+		return err
+	}
+
+	err := login()
+	if err == nil || !strings.Contains(err.Error(), "a new link was sent") {
+		t.Fatalf("expected the login to send a new link, got %v", err)
+	}
+	token := sink.token(t, "alice@example.org")
+
+	// Logging in again doesn't send another mail until the interval passed
+	for i := 0; i < 5; i++ {
+		err = login()
+		if err == nil || !strings.HasSuffix(err.Error(), "open the link sent to it, a new one can be sent every 10 minutes") {
+			t.Errorf("expected the login to refer to the sent link, got %v", err)
+		}
+	}
+	sink.expectNoMail(t)
+
+	found, err := ctrl.findTokenUser(verifyPurpose, token, verifyStamp)
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("expected the link to be valid for alice, got %+v, %v", found, err)
+	}
+
+	_, err = ctrl.signer.Verify(verifyPurpose, token, time.Now().Add(verifyLifetime+time.Minute))
+	if err != auth.ErrInvalidSignedToken {
+		t.Errorf("expected the link to expire, got %v", err)
+	}
+
+	_, err = ctrl.findResetUser(token)
+	if err != auth.ErrInvalidSignedToken {
+		t.Errorf("expected the link not to reset the password, got %v", err)
+	}
+
+	// The link only verifies the address it was sent to
+	_, err = ctrl.user.store.Update(&model.UserTemplate{
+		ID:    sql.NullInt64{Int64: user.ID, Valid: true},
+		Email: sql.NullString{String: "mallory@example.org", Valid: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ctrl.findTokenUser(verifyPurpose, token, verifyStamp)
+	if err != auth.ErrInvalidSignedToken {
+		t.Errorf("expected the link to be invalid for another address, got %v", err)
+	}
+}
+
+func TestSendLimitedRetriesFailedMail(t *testing.T) {
+	ctrl, sink, user := newMailTestController(t)
+	sink.listener.Close()
+
+	_, err := ctrl.sendVerifyMail(user)
+	if err == nil {
+		t.Fatal("expected sending to fail without a mail server")
+	}
+
+	allowed, err := ctrl.mailLimit.Allow(fmt.Sprintf("%s:%d", verifyPurpose, user.ID), time.Now())
+	if err != nil || !allowed {
+		t.Errorf("expected the failed mail to be sent again right away, got %v, %v", allowed, err)
+	}
+}
@@ -23,6 +23,11 @@ func (ctrl *ErrorController) ServeNotFoundError(w http.ResponseWriter, r *http.R
 	ctrl.ServeErrorPage(w, r, http.StatusNotFound, http.StatusText(http.StatusNotFound))
 }
 
+// ServeBadRequestError serves a 400 bad request error.
+func (ctrl *ErrorController) ServeBadRequestError(w http.ResponseWriter, r *http.Request, text string) {
+	ctrl.ServeErrorPage(w, r, http.StatusBadRequest, text)
+}
+
 // ServeUnauthorizedError serves a 401 unauthorized error.
 func (ctrl *ErrorController) ServeUnauthorizedError(w http.ResponseWriter, r *http.Request) {
 	ctrl.ServeErrorPage(w, r, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
@@ -36,5 +41,5 @@ func (ctrl *ErrorController) ServeInternalServerError(w http.ResponseWriter, r *
 // ServeErrorPage serves an error page with a custom message.
 func (ctrl *ErrorController) ServeErrorPage(w http.ResponseWriter, r *http.Request, code int, text string) {
 	ctx := ctrl.errorView.NewErrorContext(r, code, text)
-	ctrl.errorView.Error.Render(w, ctx)
+	ctrl.errorView.Error.RenderStatus(w, code, ctx)
 }
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/http/httpext"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/mvc/view"
+	"bingo/internal/session"
+	"bingo/internal/util/log"
+)
+
+// PasteAPIController handles creating and fetching pastes through the JSON API.
+type PasteAPIController struct {
+	store store.PasteRepository
+}
+
+// pasteRequest represents the JSON body used to create a paste.
+type pasteRequest struct {
+	Title      string `json:"title"`
+	Content    string `json:"content"`
+	Language   string `json:"language"`
+	Visibility string `json:"visibility"`
+	Expiry     *int64 `json:"expiry"`
+	Views      *int64 `json:"views"`
+	Password   string `json:"password"`
+	Encrypted  bool   `json:"encrypted"`
+}
+
+// NewPasteAPIController creates a new PasteAPIController.
+func NewPasteAPIController(store store.PasteRepository) *PasteAPIController {
+	ctrl := new(PasteAPIController)
+	ctrl.store = store
+	return ctrl
+}
+
+// ServePaste serves an individual paste as JSON.
+func (ctrl *PasteAPIController) ServePaste(w http.ResponseWriter, r *http.Request) {
+	id, err := httpext.ParseID(r)
+	if err != nil {
+		httpext.WriteJSONError(w, http.StatusBadRequest, "invalid paste id")
+		return
+	}
+
+	paste, err := ctrl.store.FindByID(id)
+	if err == sql.ErrNoRows {
+		httpext.WriteJSONError(w, http.StatusNotFound, "paste not found")
+		return
+	} else if err != nil {
+		ctrl.serveInternalError(w, err)
+		return
+	}
+
+	// Without a login only public pastes can be shared
+	if config.Get().Authentication.Enabled && session.User(r) == nil && paste.Visibility != config.VisibilityPublic {
+		httpext.WriteJSONError(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+
+	if !isUnlocked(r, paste) && !unlock(r, paste, r.Header.Get("X-Paste-Password")) {
+		httpext.WriteJSONError(w, http.StatusForbidden, "password required")
+		return
+	}
+
+	if paste.ViewsLeft.Valid {
+		paste, err = ctrl.store.View(paste.ID)
+		if err == sql.ErrNoRows {
+			httpext.WriteJSONError(w, http.StatusNotFound, "paste not found")
+			return
+		} else if err != nil {
+			ctrl.serveInternalError(w, err)
+			return
+		}
+	}
+
+	httpext.WriteJSON(w, view.NewPasteJSON(paste, true))
+}
+
+// ServePastes serves a list of listed pastes as JSON, optionally matching a search filter.
+func (ctrl *PasteAPIController) ServePastes(w http.ResponseWriter, r *http.Request) {
+	limit, offset := httpext.ParseRange(r)
+	filter := httpext.ParseFilter(r)
+
+	var pastes []model.Paste
+	var err error
+	if filter == "" {
+		pastes, err = ctrl.store.FindRange(limit, offset)
+	} else {
+		pastes, err = ctrl.store.Search(filter, limit, offset)
+	}
+
+	if err != nil {
+		ctrl.serveInternalError(w, err)
+		return
+	}
+
+	httpext.WriteJSON(w, view.NewPasteListJSON(pastes, limit, offset))
+}
+
+// CreatePaste creates a new paste from a JSON body.
+func (ctrl *PasteAPIController) CreatePaste(w http.ResponseWriter, r *http.Request) {
+	pasteTmpl, err := ctrl.parseTemplate(r)
+	if err != nil {
+		httpext.WriteJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = setOwner(r, pasteTmpl)
+	if err != nil {
+		ctrl.serveInternalError(w, err)
+		return
+	}
+
+	paste, err := ctrl.store.Insert(pasteTmpl)
+	if err != nil {
+		ctrl.serveInternalError(w, err)
+		return
+	}
+
+	result := view.NewPasteJSON(paste, true)
+	result.EditKey = pasteTmpl.EditKey
+	w.Header().Set("Location", absoluteURL(result.URL))
+	httpext.WriteJSONStatus(w, http.StatusCreated, result)
+}
+
+// absoluteURL returns the path as an absolute URL using the configured base
+// URL. The host of the request isn't used, since anyone can set it. Without a
+// base URL the path is returned as it is.
+func absoluteURL(path string) string {
+	baseURL := config.Get().BaseURL
+	if baseURL == "" {
+		return path
+	}
+	return strings.TrimRight(baseURL, "/") + path
+}
+
+func (ctrl *PasteAPIController) serveInternalError(w http.ResponseWriter, err error) {
+	log.Errorf("Failed to handle API request: %s", err)
+	httpext.WriteJSONError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func (ctrl *PasteAPIController) parseTemplate(r *http.Request) (*model.PasteTemplate, error) {
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil, errors.New("content type must be application/json")
+	}
+
+	request := pasteRequest{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&request)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON body: %s", err)
+	}
+
+	if strings.TrimSpace(request.Content) == "" {
+		return nil, errors.New("paste content required")
+	}
+
+	duration, views, err := parseAPIExpiry(request)
+	if err != nil {
+		return nil, err
+	}
+
+	visibility := config.Get().Visibility.Default
+	if request.Visibility != "" && config.Get().Visibility.Enabled {
+		var ok bool
+		visibility, ok = config.ParseVisibility(request.Visibility)
+		if !ok {
+			return nil, fmt.Errorf("invalid visibility '%s'", request.Visibility)
+		}
+	}
+
+	language := "plaintext"
+	if request.Language != "" && config.Get().Highlight.Enabled {
+		var ok bool
+		language, ok = config.Get().Highlight.FindLanguage(request.Language)
+		if !ok {
+			return nil, fmt.Errorf("invalid language '%s'", request.Language)
+		}
+	}
+
+	kind := model.PasteKindText
+	if request.Encrypted {
+		if !config.Get().Encryption.Enabled {
+			return nil, errors.New("encrypted pastes are disabled")
+		} else if !isCiphertext(request.Content) {
+			return nil, errors.New("content isn't a valid ciphertext")
+		}
+		kind = model.PasteKindEncrypted
+		language = "plaintext"
+	}
+
+	pasteTmpl := model.PasteTemplate{
+		Title:      request.Title,
+		RawContent: request.Content,
+		Visibility: visibility,
+		Duration:   duration,
+		Language:   language,
+		Views:      views,
+		Password:   request.Password,
+		Kind:       kind,
+	}
+	return &pasteTmpl, nil
+}
+
+// parseAPIExpiry returns the expiry and view limit of the request, which have
+// to be among the options of the write page. Without an expiry or view limit
+// the first option is used, same as on the write page.
+func parseAPIExpiry(request pasteRequest) (time.Duration, int64, error) {
+	conf := config.Get().Expiry
+	if !conf.Enabled {
+		return 0, 0, nil
+	}
+
+	var duration time.Duration
+	if request.Expiry != nil {
+		duration = time.Duration(*request.Expiry) * time.Second
+		if !conf.HasDuration(duration) {
+			return 0, 0, fmt.Errorf("invalid expiry %d, expected one of %s", *request.Expiry, formatSeconds(conf.RawDurations))
+		}
+	} else if len(conf.RawDurations) > 0 {
+		duration = time.Duration(conf.RawDurations[0]) * time.Minute
+	}
+
+	var views int64
+	if request.Views != nil {
+		views = *request.Views
+		if !conf.HasViews(views) {
+			return 0, 0, fmt.Errorf("invalid views %d, expected one of %v", views, conf.Views)
+		}
+	} else if len(conf.Views) > 0 {
+		views = conf.Views[0]
+	}
+
+	return duration, views, nil
+}
+
+// formatSeconds formats the durations given in minutes as seconds, which is
+// how the API takes them.
+func formatSeconds(minutes []int) string {
+	seconds := make([]string, len(minutes))
+	for i, value := range minutes {
+		seconds[i] = strconv.Itoa(value * 60)
+	}
+	return "[" + strings.Join(seconds, " ") + "]"
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model/store"
+)
+
+func newTestPasteAPIController() *PasteAPIController {
+	conf := config.NewDefaultConfig()
+	conf.Authentication.Enabled = false
+	conf.BaseURL = "https://bingo.example.org/"
+	return NewPasteAPIController(store.NewMemoryPasteStore(store.NewMemoryDatabase()))
+}
+
+func postPaste(ctrl *PasteAPIController, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/pastes", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ctrl.CreatePaste(w, r)
+	return w
+}
+
+func TestCreatePaste(t *testing.T) {
+	ctrl := newTestPasteAPIController()
+
+	w := postPaste(ctrl, `{"content": "print(1)", "language": "python", "expiry": 3600, "views": 10}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "https://bingo.example.org/pastes/") {
+		t.Errorf("expected an absolute location, got '%s'", location)
+	}
+	if !strings.Contains(w.Body.String(), `"language":"Python"`) {
+		t.Errorf("expected the configured name of the language, got %s", w.Body)
+	}
+}
+
+func TestCreatePasteDefaults(t *testing.T) {
+	ctrl := newTestPasteAPIController()
+
+	w := postPaste(ctrl, `{"content": "hello"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+}
+
+func TestCreatePasteRejectsOptions(t *testing.T) {
+	ctrl := newTestPasteAPIController()
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"unknown language", `{"content": "hello", "language": "Klingon"}`},
+		{"unconfigured expiry", `{"content": "hello", "expiry": 1}`},
+		{"negative expiry", `{"content": "hello", "expiry": -600}`},
+		{"unconfigured views", `{"content": "hello", "views": 3}`},
+		{"negative views", `{"content": "hello", "views": -1}`},
+	}
+
+	for _, test := range tests {
+		w := postPaste(ctrl, test.body)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, w.Code)
+		}
+	}
+}
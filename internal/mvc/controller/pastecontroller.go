@@ -1,10 +1,21 @@
 package controller
 
 import (
+	"bytes"
+	"database/sql"
+	"errors"
 	"fmt"
+	"html"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	neturl "net/url"
+	"path"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"bingo/internal/config"
 	"bingo/internal/http/httpext"
@@ -12,17 +23,33 @@ import (
 	"bingo/internal/mvc/model/store"
 	"bingo/internal/mvc/view"
 	"bingo/internal/session"
+	"bingo/internal/util/archive"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/fmtutil"
+	"bingo/internal/util/log"
+)
+
+var (
+	maxRawPasteSize     int64 = 10 << 20
+	maxPasteFiles             = 20
+	maxPasteAttachments       = 10
+	maxArchivePastes    int64 = 100
+	maxHexdumpSize            = 1024
+
+	// ciphertextPattern matches the version, IV and ciphertext of an encrypted
+	// paste, the latter two encoded in base64.
+	ciphertextPattern = regexp.MustCompile(`^v1:[A-Za-z0-9+/]+=*:[A-Za-z0-9+/]+=*$`)
 )
 
 // PasteController handles creating and displaying pastes.
 type PasteController struct {
 	err   *ErrorController
-	store *store.PasteStore
+	store store.PasteRepository
 	view  *view.PasteView
 }
 
 // NewPasteController creates a new PasteController.
-func NewPasteController(errCtrl *ErrorController, store *store.PasteStore) *PasteController {
+func NewPasteController(errCtrl *ErrorController, store store.PasteRepository) *PasteController {
 	ctrl := new(PasteController)
 	ctrl.err = errCtrl
 	ctrl.store = store
@@ -46,26 +73,448 @@ func (ctrl *PasteController) ServeWritePage(w http.ResponseWriter, r *http.Reque
 // ServeViewPage serves the page for viewing individual pastes.
 func (ctrl *PasteController) ServeViewPage(w http.ResponseWriter, r *http.Request) {
 	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to server view paste page: ", err), err)
+		return
+	}
+
+	if !ctrl.confirmView(w, r, paste, "") {
+		return
+	}
+
+	if paste.ViewsLeft.Valid {
+		paste, err = ctrl.store.View(paste.ID)
+		if err != nil {
+			ctrl.servePasteError(w, r, fmt.Sprintln("Failed to server view paste page: ", err), err)
+			return
+		}
+	}
+
+	canEdit := ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID))
+	if paste.IsEncrypted() {
+		ctx := ctrl.view.NewDecryptPasteContext(r, paste, canEdit)
+		ctrl.view.Decrypt.Render(w, ctx)
+		return
+	}
+
+	err = ctrl.loadHexdumps(paste)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to server view paste page: ", err))
+		return
+	}
+
+	ctx := ctrl.view.NewViewPasteContext(r, paste, canEdit)
+	ctx.Parent = ctrl.getParent(paste)
+	ctx.Forks, err = ctrl.store.FindForks(paste.ID, forkVisibility(r))
 	if err != nil {
 		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to server view paste page: ", err))
 		return
 	}
 
-	ctx := ctrl.view.NewViewPasteContext(r, paste)
 	ctrl.view.View.Render(w, ctx)
 }
 
+// ServeForkPage serves the page for creating a new paste from an existing paste.
+func (ctrl *PasteController) ServeForkPage(w http.ResponseWriter, r *http.Request) {
+	parent, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve fork paste page: ", err))
+		return
+	}
+
+	// Forking would allow reading the paste without counting the view
+	if parent.ViewsLeft.Valid {
+		ctrl.err.ServeUnauthorizedError(w, r)
+		return
+	}
+
+	// The server can't read encrypted pastes to fill the editor
+	if parent.IsEncrypted() {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.unlocked(r, parent) {
+		http.Redirect(w, r, ctrl.pasteURL(r, ""), http.StatusFound)
+		return
+	}
+
+	ctx := ctrl.view.NewForkPasteContext(r, parent)
+	ctrl.view.Write.Render(w, ctx)
+}
+
+// ServeEditPage serves the page for editing an existing paste.
+func (ctrl *PasteController) ServeEditPage(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve edit paste page: ", err))
+		return
+	}
+
+	// The editor would show the content without counting a view
+	if paste.IsEncrypted() || paste.ViewsLeft.Valid {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID)) {
+		ctrl.err.ServeUnauthorizedError(w, r)
+		return
+	}
+
+	ctx := ctrl.view.NewEditPasteContext(r, paste)
+	ctrl.view.Write.Render(w, ctx)
+}
+
+// ServeClaimPage serves the page asking for the edit key of an anonymous paste.
+func (ctrl *PasteController) ServeClaimPage(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve claim paste page: ", err))
+		return
+	}
+
+	if config.Get().Authentication.Enabled || !paste.EditKeyHash.Valid {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	ctx := ctrl.view.NewClaimPasteContext(r, paste)
+	ctrl.view.Claim.Render(w, ctx)
+}
+
+// ClaimPaste checks the posted edit key of an anonymous paste and remembers
+// it in the session, so the paste can be edited without passing the key
+// around in links.
+func (ctrl *PasteController) ClaimPaste(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to claim paste: ", err))
+		return
+	}
+
+	if config.Get().Authentication.Enabled || !paste.EditKeyHash.Valid {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	key := r.PostFormValue("key")
+	if !ctrl.canModify(r, paste, key) {
+		note := model.NewErrorNotification("Error", "Wrong edit key")
+		httpext.RedirectWithNotify(w, r, ctrl.pasteURL(r, "/claim"), http.StatusSeeOther, note)
+		return
+	}
+
+	session.Get().Put(r.Context(), editKeySessionKey(paste.ID), key)
+	http.Redirect(w, r, ctrl.pasteURL(r, ""), http.StatusSeeOther)
+}
+
 // ServeRawPaste serves the raw text content of individual pastes.
 func (ctrl *PasteController) ServeRawPaste(w http.ResponseWriter, r *http.Request) {
 	paste, err := ctrl.getPaste(r)
 	if err != nil {
-		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve raw paste: ", err))
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve raw paste: ", err), err)
+		return
+	}
+
+	if !ctrl.confirmView(w, r, paste, "/raw") {
 		return
 	}
 
+	if paste.ViewsLeft.Valid {
+		paste, err = ctrl.store.View(paste.ID)
+		if err != nil {
+			ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve raw paste: ", err), err)
+			return
+		}
+	}
+
 	httpext.WriteText(w, []byte(paste.RawContent))
 }
 
+// ServeRawFile serves the raw text content of a single file of a paste.
+func (ctrl *PasteController) ServeRawFile(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve raw paste file: ", err), err)
+		return
+	}
+
+	filename := httpext.ParseParam(r, "filename")
+	if paste.IsEncrypted() || paste.FindFile(filename) == nil {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.confirmView(w, r, paste, "/raw/"+neturl.PathEscape(filename)) {
+		return
+	}
+
+	if paste.ViewsLeft.Valid {
+		paste, err = ctrl.store.View(paste.ID)
+		if err != nil {
+			ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve raw paste file: ", err), err)
+			return
+		}
+	}
+
+	httpext.WriteText(w, []byte(paste.FindFile(filename).RawContent))
+}
+
+// ServeAttachment serves a file attached to a paste. Images are displayed by
+// the browser, other files are downloaded.
+func (ctrl *PasteController) ServeAttachment(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste attachment: ", err))
+		return
+	}
+
+	position, err := httpext.ParseIntParam(r, "position")
+	attachment := paste.FindAttachment(position)
+	if err != nil || attachment == nil {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.confirmView(w, r, paste, fmt.Sprintf("/attachments/%d", position)) {
+		return
+	}
+
+	err = ctrl.store.LoadAttachment(attachment)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste attachment: ", err))
+		return
+	}
+
+	// Attachments are uploaded by anyone, so browsers must never guess their type
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'")
+	if attachment.IsImage() && r.URL.Query().Get("download") == "" {
+		httpext.WriteRaw(w, attachment.ContentType, attachment.Content)
+		return
+	}
+
+	httpext.WriteAttachment(w, "application/octet-stream", attachment.Filename, attachment.Content)
+}
+
+// ServeDownload serves the content of individual pastes as a file download.
+// Pastes with multiple files are downloaded as a zip archive.
+func (ctrl *PasteController) ServeDownload(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve paste download: ", err), err)
+		return
+	}
+
+	if paste.IsEncrypted() {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.confirmView(w, r, paste, "/download") {
+		return
+	}
+
+	if paste.ViewsLeft.Valid {
+		paste, err = ctrl.store.View(paste.ID)
+		if err != nil {
+			ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve paste download: ", err), err)
+			return
+		}
+	}
+
+	if len(paste.Files) == 0 && len(paste.Attachments) == 0 {
+		httpext.WriteAttachment(w, "text/plain; charset=utf-8", downloadName(paste), []byte(paste.RawContent))
+		return
+	}
+
+	err = ctrl.loadAttachments(paste)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste download: ", err))
+		return
+	}
+
+	name := fmtutil.Slug(paste.Title, fmt.Sprintf("paste-%d", paste.ID)) + ".zip"
+	httpext.WriteAttachmentHeaders(w, archive.FormatZip.ContentType(), name)
+	err = archive.WriteZip(w, archiveFiles(paste, ""))
+	if err != nil {
+		log.Errorln("Failed to write paste download:", err)
+	}
+}
+
+// ServeArchive serves a zip or tar.gz archive of a list of pastes. The pastes
+// are the ones of the list page with the same query, or the pastes of the
+// current user if mine is set.
+func (ctrl *PasteController) ServeArchive(w http.ResponseWriter, r *http.Request) {
+	format, err := archive.ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		ctrl.err.ServeErrorPage(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	limit, offset := httpext.ParseRange(r)
+	if r.URL.Query().Get("limit") == "" || limit <= 0 || limit > maxArchivePastes {
+		limit = maxArchivePastes
+	}
+
+	var pastes []model.Paste
+	filter := httpext.ParseFilter(r)
+	if r.URL.Query().Get("mine") != "" {
+		user := session.User(r)
+		if user == nil {
+			ctrl.err.ServeUnauthorizedError(w, r)
+			return
+		}
+		pastes, err = ctrl.store.FindByUser(user.ID, limit, offset)
+	} else if filter == "" {
+		pastes, err = ctrl.store.FindRange(limit, offset)
+	} else {
+		pastes, err = ctrl.store.Search(filter, limit, offset)
+	}
+
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste archive: ", err))
+		return
+	}
+
+	files := []archive.File{}
+	for i := range pastes {
+		paste := &pastes[i]
+		if paste.IsEncrypted() || !ctrl.unlocked(r, paste) {
+			continue
+		}
+
+		paste.Files, err = ctrl.store.FindFiles(paste.ID)
+		if err == nil {
+			err = ctrl.store.LoadContent(paste)
+		}
+		if err == nil {
+			paste.Attachments, err = ctrl.store.FindAttachments(paste.ID)
+		}
+		if err == nil {
+			err = ctrl.loadAttachments(paste)
+		}
+		if err != nil {
+			ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste archive: ", err))
+			return
+		}
+
+		files = append(files, archiveFiles(paste, fmt.Sprintf("%d-", paste.ID))...)
+	}
+
+	httpext.WriteAttachmentHeaders(w, format.ContentType(), "pastes."+string(format))
+	err = archive.Write(w, format, files)
+	if err != nil {
+		log.Errorln("Failed to write paste archive:", err)
+	}
+}
+
+// ServeRevisionsPage serves the page listing the revisions of a paste.
+func (ctrl *PasteController) ServeRevisionsPage(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve paste revisions page: ", err), err)
+		return
+	}
+
+	if paste.IsEncrypted() {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.unlocked(r, paste) {
+		http.Redirect(w, r, ctrl.pasteURL(r, ""), http.StatusFound)
+		return
+	}
+
+	revisions, err := ctrl.store.FindRevisions(paste.ID)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste revisions page: ", err))
+		return
+	}
+
+	ctx := ctrl.view.NewRevisionsContext(r, paste, revisions)
+	ctrl.view.Revisions.Render(w, ctx)
+}
+
+// ServeRawRevision serves the raw text content of a paste revision.
+func (ctrl *PasteController) ServeRawRevision(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve raw paste revision: ", err), err)
+		return
+	}
+
+	if !ctrl.unlocked(r, paste) {
+		http.Redirect(w, r, ctrl.pasteURL(r, ""), http.StatusFound)
+		return
+	}
+
+	revision, err := httpext.ParseIntParam(r, "revision")
+	if err != nil {
+		ctrl.err.ServeBadRequestError(w, r, fmt.Sprintf("invalid revision '%s'", httpext.ParseParam(r, "revision")))
+		return
+	}
+
+	pasteRevision, err := ctrl.store.FindRevision(paste.ID, revision)
+	if err == sql.ErrNoRows {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	} else if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve raw paste revision: ", err))
+		return
+	}
+
+	httpext.WriteText(w, []byte(pasteRevision.RawContent))
+}
+
+// ServeDiffPage serves the page showing the differences between two revisions of a paste.
+func (ctrl *PasteController) ServeDiffPage(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		ctrl.servePasteError(w, r, fmt.Sprintln("Failed to serve paste diff page: ", err), err)
+		return
+	}
+
+	if paste.IsEncrypted() {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	}
+
+	if !ctrl.unlocked(r, paste) {
+		http.Redirect(w, r, ctrl.pasteURL(r, ""), http.StatusFound)
+		return
+	}
+
+	revisions, err := ctrl.store.FindRevisions(paste.ID)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve paste diff page: ", err))
+		return
+	}
+
+	from, to, err := parseDiffRange(r, paste, revisions)
+	if err == sql.ErrNoRows {
+		ctrl.err.ServeNotFoundError(w, r)
+		return
+	} else if err != nil {
+		ctrl.err.ServeBadRequestError(w, r, err.Error())
+		return
+	}
+
+	diff := fmtutil.UnifiedDiff(
+		fmt.Sprintf("revision %d", from.Revision), from.RawContent,
+		fmt.Sprintf("revision %d", to.Revision), to.RawContent)
+
+	formatted := ""
+	if diff != "" {
+		formatted = fmtutil.FormatCode("diff", diff)
+	}
+
+	ctx := ctrl.view.NewDiffContext(r, paste, revisions, from, to, formatted)
+	ctrl.view.Diff.Render(w, ctx)
+}
+
 // ServeListPage serves the page for viewing a list of pastes.
 func (ctrl *PasteController) ServeListPage(w http.ResponseWriter, r *http.Request) {
 	limit, offset := httpext.ParseRange(r)
@@ -90,58 +539,973 @@ func (ctrl *PasteController) ServeListPage(w http.ResponseWriter, r *http.Reques
 
 // CreatePaste creates a new paste.
 func (ctrl *PasteController) CreatePaste(w http.ResponseWriter, r *http.Request) {
+	limitBody(w, r)
+	if ctrl.serveDraft(w, r, nil) {
+		return
+	}
+
 	paste, err := ctrl.createPaste(r)
 	if err != nil {
 		httpext.ReloadWithError(w, r, "Failed to create paste", err.Error())
 		return
 	}
 
-	msg := fmt.Sprintf("Created paste %s (%s)", paste.Title, paste.Language)
+	msg := fmt.Sprintf("Created paste %s (%s)", html.EscapeString(paste.Title), html.EscapeString(paste.Language))
+	if editKey := ctrl.editKey(r, paste.ID); editKey != "" {
+		msg += fmt.Sprintf("<br>Keep this key to edit or delete the paste from another browser:<br><code>%s</code>", html.EscapeString(editKey))
+	}
+
 	note := model.NewSuccessNotification("Success", msg)
 	url := fmt.Sprintf("/pastes/%d", paste.ID)
 	httpext.RedirectWithNotify(w, r, url, http.StatusSeeOther, note)
 }
 
-func (ctrl *PasteController) getPaste(r *http.Request) (*model.Paste, error) {
-	id, err := httpext.ParseID(r)
+// UpdatePaste updates an existing paste.
+func (ctrl *PasteController) UpdatePaste(w http.ResponseWriter, r *http.Request) {
+	limitBody(w, r)
+	paste, err := ctrl.getPaste(r)
 	if err != nil {
-		return nil, err
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to save paste: ", err))
+		return
 	}
 
-	return ctrl.store.FindByID(id)
-}
+	if !ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID)) {
+		ctrl.err.ServeUnauthorizedError(w, r)
+		return
+	}
 
-func (ctrl *PasteController) createPaste(r *http.Request) (*model.Paste, error) {
-	template, err := parseTemplate(r)
+	if ctrl.serveDraft(w, r, paste) {
+		return
+	}
+
+	paste, err = ctrl.updatePaste(r)
 	if err != nil {
-		return nil, err
+		note := model.NewErrorNotification("Failed to save paste", err.Error())
+		httpext.RedirectWithNotify(w, r, ctrl.pasteURL(r, "/edit"), http.StatusSeeOther, note)
+		return
 	}
 
-	return ctrl.store.Insert(template)
+	msg := fmt.Sprintf("Paste <b>%s</b> saved successfully", html.EscapeString(paste.Title))
+	note := model.NewSuccessNotification("Saved", msg)
+	url := fmt.Sprintf("/pastes/%d", paste.ID)
+	httpext.RedirectWithNotify(w, r, url, http.StatusSeeOther, note)
 }
 
-func parseTemplate(r *http.Request) (*model.PasteTemplate, error) {
-	err := r.ParseForm()
+// DeletePaste deletes an existing paste.
+func (ctrl *PasteController) DeletePaste(w http.ResponseWriter, r *http.Request) {
+	paste, err := ctrl.deletePaste(r)
 	if err != nil {
-		return nil, err
+		note := model.NewErrorNotification("Failed to delete paste", err.Error())
+		httpext.RedirectWithNotify(w, r, ctrl.pasteURL(r, ""), http.StatusSeeOther, note)
+		return
 	}
 
-	duration, err := strconv.ParseInt(r.FormValue("expiry"), 10, 64)
+	msg := fmt.Sprintf("Paste <b>%s</b> deleted successfully", html.EscapeString(paste.Title))
+	note := model.NewSuccessNotification("Deleted", msg)
+	httpext.RedirectWithNotify(w, r, "/pastes", http.StatusSeeOther, note)
+}
+
+// CreateRawPaste creates a new paste from a raw request body or multipart file
+// upload and responds with the URL of the paste as plain-text.
+func (ctrl *PasteController) CreateRawPaste(w http.ResponseWriter, r *http.Request) {
+	template, err := parseRawTemplate(w, r)
 	if err != nil {
-		duration = 0
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	visibility, err := strconv.Atoi(r.FormValue("visibility"))
+	err = setOwner(r, template)
 	if err != nil {
-		visibility = int(config.VisibilityUnlisted)
+		log.Errorf("Failed to create raw paste: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
 	}
 
+	paste, err := ctrl.store.Insert(template)
+	if err != nil {
+		log.Errorf("Failed to create raw paste: %s", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	url := absoluteURL(fmt.Sprintf("/pastes/%d", paste.ID))
+	w.Header().Set("Location", url)
+	if template.EditKey != "" {
+		w.Header().Set("X-Paste-Edit-Key", template.EditKey)
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, url)
+}
+
+// serveDraft shows the write page again if a file was added or removed, keeping
+// everything entered so far. Paste is nil unless an existing paste is edited.
+// Returns false if the paste should be saved instead.
+func (ctrl *PasteController) serveDraft(w http.ResponseWriter, r *http.Request, paste *model.Paste) bool {
+	template, err := parseTemplate(r)
+	if err != nil || !editDraft(r, template) {
+		return false
+	}
+
+	var parent *model.Paste
+	if template.ParentID.Valid && paste == nil {
+		parent, _ = ctrl.store.FindByID(template.ParentID.Int64)
+	}
+
+	ctx := ctrl.view.NewDraftPasteContext(r, template, paste, parent)
+	ctrl.view.Write.Render(w, ctx)
+	return true
+}
+
+// servePasteError serves the error of getPaste, which is a 400 for an invalid
+// id and a 404 if the paste doesn't exist.
+func (ctrl *PasteController) servePasteError(w http.ResponseWriter, r *http.Request, text string, err error) {
+	var numErr *strconv.NumError
+	if errors.As(err, &numErr) {
+		ctrl.err.ServeBadRequestError(w, r, fmt.Sprintf("invalid paste id '%s'", httpext.ParseParam(r, "id")))
+	} else if err == sql.ErrNoRows {
+		ctrl.err.ServeNotFoundError(w, r)
+	} else {
+		ctrl.err.ServeInternalServerError(w, r, text)
+	}
+}
+
+func (ctrl *PasteController) getPaste(r *http.Request) (*model.Paste, error) {
+	id, err := httpext.ParseID(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctrl.store.FindByID(id)
+}
+
+func (ctrl *PasteController) createPaste(r *http.Request) (*model.Paste, error) {
+	template, err := parseTemplate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateFiles(template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateAttachments(template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setOwner(r, template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctrl.setParent(template)
+	if err != nil {
+		return nil, err
+	}
+
+	paste, err := ctrl.store.Insert(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.EditKey != "" {
+		session.Get().Put(r.Context(), editKeySessionKey(paste.ID), template.EditKey)
+	}
+
+	return paste, nil
+}
+
+func (ctrl *PasteController) updatePaste(r *http.Request) (*model.Paste, error) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := parseTemplate(r)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateFiles(template)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID)) {
+		return nil, errors.New("not allowed to edit paste")
+	} else if paste.IsEncrypted() {
+		return nil, errors.New("encrypted pastes can't be edited")
+	} else if paste.ViewsLeft.Valid {
+		return nil, errors.New("pastes with a view limit can't be edited")
+	}
+
+	template.Attachments = append(keptAttachments(r, paste), template.Attachments...)
+	err = validateAttachments(template)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctrl.limitParentVisibility(template, paste)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctrl.store.Update(paste.ID, template)
+}
+
+func (ctrl *PasteController) deletePaste(r *http.Request) (*model.Paste, error) {
+	paste, err := ctrl.getPaste(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID)) {
+		return nil, errors.New("not allowed to delete paste")
+	}
+
+	err = ctrl.store.Delete(paste.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Get().Remove(r.Context(), editKeySessionKey(paste.ID))
+	return paste, nil
+}
+
+// setParent validates the paste being forked. A fork can't be more visible
+// than its parent, otherwise it would reveal the parent.
+func (ctrl *PasteController) setParent(pasteTmpl *model.PasteTemplate) error {
+	if !pasteTmpl.ParentID.Valid {
+		return nil
+	}
+
+	parent, err := ctrl.store.FindByID(pasteTmpl.ParentID.Int64)
+	if err == sql.ErrNoRows {
+		return errors.New("forked paste doesn't exist")
+	} else if err != nil {
+		return err
+	}
+
+	limitVisibility(pasteTmpl, parent)
+	return nil
+}
+
+// limitParentVisibility applies the visibility of the parent to a fork being
+// updated, same as when it was created. Forks of deleted pastes can be made
+// more visible, since there's no parent left to reveal.
+func (ctrl *PasteController) limitParentVisibility(pasteTmpl *model.PasteTemplate, paste *model.Paste) error {
+	if !paste.ParentID.Valid {
+		return nil
+	}
+
+	parent, err := ctrl.store.FindByID(paste.ParentID.Int64)
+	if err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	limitVisibility(pasteTmpl, parent)
+	return nil
+}
+
+// limitVisibility makes sure a fork isn't more visible than its parent.
+func limitVisibility(pasteTmpl *model.PasteTemplate, parent *model.Paste) {
+	if pasteTmpl.Visibility > parent.Visibility {
+		pasteTmpl.Visibility = parent.Visibility
+	}
+}
+
+// getParent returns the paste the given paste was forked from, or nil if the
+// parent doesn't exist anymore or has been made less visible than the fork.
+func (ctrl *PasteController) getParent(paste *model.Paste) *model.Paste {
+	if !paste.ParentID.Valid {
+		return nil
+	}
+
+	parent, err := ctrl.store.FindByID(paste.ParentID.Int64)
+	if err != nil || parent.Visibility < paste.Visibility {
+		return nil
+	}
+
+	return parent
+}
+
+// forkVisibility returns the minimum visibility of forks shown to the user.
+// Unlisted forks are only known to their creators.
+func forkVisibility(r *http.Request) config.Visibility {
+	if config.Get().Authentication.Enabled && session.User(r) == nil {
+		return config.VisibilityPublic
+	}
+	return config.VisibilityListed
+}
+
+// confirmView renders the page asking for the password of a protected paste,
+// or for a confirmation before counting a view of a paste with a view limit.
+// Link previews and crawlers only send GET requests, so views are only counted
+// when the form is posted. Returns true if the paste can be served.
+func (ctrl *PasteController) confirmView(w http.ResponseWriter, r *http.Request, paste *model.Paste, action string) bool {
+	unlocked := ctrl.unlocked(r, paste)
+	if unlocked && (!paste.ViewsLeft.Valid || r.Method == http.MethodPost) {
+		return true
+	}
+
+	ctx := ctrl.view.NewConfirmViewContext(r, paste, action, !unlocked)
+	ctx.WrongPassword = !unlocked && r.Method == http.MethodPost
+	ctrl.view.Confirm.Render(w, ctx)
+	return false
+}
+
+// unlocked returns true if the paste isn't password protected, or if the
+// reader can modify the paste or has entered the password. A correct password
+// posted with the request is remembered for the rest of the session.
+func (ctrl *PasteController) unlocked(r *http.Request, paste *model.Paste) bool {
+	if isUnlocked(r, paste) || ctrl.canModify(r, paste, ctrl.editKey(r, paste.ID)) {
+		return true
+	}
+	return r.Method == http.MethodPost && unlock(r, paste, r.PostFormValue("password"))
+}
+
+// isUnlocked returns true if the paste isn't password protected or the
+// password was already entered during the session.
+func isUnlocked(r *http.Request, paste *model.Paste) bool {
+	if !paste.PasswordHash.Valid {
+		return true
+	}
+	return session.Get().GetBool(r.Context(), unlockSessionKey(paste.ID))
+}
+
+// unlock checks the password of a protected paste and remembers the unlock in
+// the session if it's correct.
+func unlock(r *http.Request, paste *model.Paste, password string) bool {
+	if password == "" || auth.CheckPasswordHash(password, paste.PasswordHash.String) != nil {
+		return false
+	}
+
+	session.Get().Put(r.Context(), unlockSessionKey(paste.ID), true)
+	return true
+}
+
+// editKey returns the key used to edit an anonymous paste. The key is read
+// from the X-Paste-Edit-Key header or the posted form, or from the session of
+// the user who created or claimed the paste. Keys are never read from the URL,
+// where they would end up in logs and the browser history.
+func (ctrl *PasteController) editKey(r *http.Request, id int64) string {
+	if config.Get().Authentication.Enabled {
+		return ""
+	}
+
+	if key := r.Header.Get("X-Paste-Edit-Key"); key != "" {
+		return key
+	}
+	if key := r.PostFormValue("key"); key != "" {
+		return key
+	}
+	return session.Get().GetString(r.Context(), editKeySessionKey(id))
+}
+
+// canModify returns true if the paste can be edited or deleted. Pastes can be
+// modified by their owner and admins, or with the edit key if authentication
+// is disabled.
+func (ctrl *PasteController) canModify(r *http.Request, paste *model.Paste, editKey string) bool {
+	if !config.Get().Authentication.Enabled {
+		return editKey != "" && paste.EditKeyHash.Valid && auth.CheckTokenHash(editKey, paste.EditKeyHash.String)
+	}
+
+	user := session.User(r)
+	if user == nil {
+		return false
+	}
+
+	isOwner := paste.UserID.Valid && paste.UserID.Int64 == user.ID
+	return isOwner || user.Role == config.RoleAdmin
+}
+
+// setOwner records the user creating the paste. Anonymous deployments have no
+// users, so a random edit key is generated for the creator instead.
+func setOwner(r *http.Request, pasteTmpl *model.PasteTemplate) error {
+	if config.Get().Authentication.Enabled {
+		if user := session.User(r); user != nil {
+			pasteTmpl.UserID = sql.NullInt64{Int64: user.ID, Valid: true}
+		}
+		return nil
+	}
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	pasteTmpl.EditKey = key
+	return nil
+}
+
+// pasteURL returns the URL of the requested paste.
+func (ctrl *PasteController) pasteURL(r *http.Request, suffix string) string {
+	id, _ := httpext.ParseID(r)
+	return fmt.Sprintf("/pastes/%d%s", id, suffix)
+}
+
+// loadHexdumps reads the attachments that browsers can't display to show
+// the beginning of their content as a hex dump.
+func (ctrl *PasteController) loadHexdumps(paste *model.Paste) error {
+	for i := range paste.Attachments {
+		attachment := &paste.Attachments[i]
+		if attachment.IsImage() {
+			continue
+		}
+
+		err := ctrl.store.LoadAttachment(attachment)
+		if err != nil {
+			return err
+		}
+		attachment.Hexdump = fmtutil.Hexdump(attachment.Content, maxHexdumpSize)
+		attachment.Content = nil
+	}
+	return nil
+}
+
+// loadAttachments reads the content of all attachments of the paste.
+func (ctrl *PasteController) loadAttachments(paste *model.Paste) error {
+	for i := range paste.Attachments {
+		err := ctrl.store.LoadAttachment(&paste.Attachments[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadName returns the filename of a downloaded single file paste.
+func downloadName(paste *model.Paste) string {
+	if paste.Filename != "" {
+		return paste.Filename
+	}
+	return fmtutil.Filename(paste.Title, paste.Language, fmt.Sprintf("paste-%d", paste.ID))
+}
+
+// archiveFiles returns the files of a paste as they're stored in an archive.
+// Single file pastes are stored as one file, the files of other pastes are
+// stored in a directory named after the paste.
+func archiveFiles(paste *model.Paste, prefix string) []archive.File {
+	modTime := paste.TimeCreated
+	if paste.TimeUpdated.Valid {
+		modTime = paste.TimeUpdated.Time
+	}
+
+	if len(paste.Files) == 0 && len(paste.Attachments) == 0 {
+		return []archive.File{{Name: prefix + downloadName(paste), Content: []byte(paste.RawContent), ModTime: modTime}}
+	}
+
+	dir := prefix + fmtutil.Slug(paste.Title, fmt.Sprintf("paste-%d", paste.ID)) + "/"
+	files := []archive.File{}
+	if len(paste.Files) == 0 {
+		files = append(files, archive.File{Name: dir + downloadName(paste), Content: []byte(paste.RawContent), ModTime: modTime})
+	} else {
+		for _, file := range paste.AllFiles() {
+			files = append(files, archive.File{Name: dir + file.Filename, Content: []byte(file.RawContent), ModTime: modTime})
+		}
+	}
+
+	// Attachments may share their names, so they're prefixed with their position
+	for _, attachment := range paste.Attachments {
+		name := fmt.Sprintf("%sattachments/%d-%s", dir, attachment.Position, attachment.Filename)
+		files = append(files, archive.File{Name: name, Content: attachment.Content, ModTime: modTime})
+	}
+	return files
+}
+
+func editKeySessionKey(id int64) string {
+	return fmt.Sprintf("paste:%d:edit_key", id)
+}
+
+func unlockSessionKey(id int64) string {
+	return fmt.Sprintf("paste:%d:unlocked", id)
+}
+
+func parseTemplate(r *http.Request) (*model.PasteTemplate, error) {
+	err := parseForm(r)
+	if err != nil {
+		return nil, err
+	}
+
+	duration, err := strconv.ParseInt(r.FormValue("expiry"), 10, 64)
+	if err != nil {
+		duration = 0
+	}
+
+	visibility, err := strconv.Atoi(r.FormValue("visibility"))
+	if err != nil {
+		visibility = int(config.VisibilityUnlisted)
+	}
+
+	views, err := strconv.ParseInt(r.FormValue("views"), 10, 64)
+	if err != nil || views < 0 || !config.Get().Expiry.Enabled {
+		views = 0
+	}
+
+	parentID, err := strconv.ParseInt(r.FormValue("parent"), 10, 64)
+	parent := sql.NullInt64{Int64: parentID, Valid: err == nil}
+
 	pasteTmpl := model.PasteTemplate{
 		Title:      r.FormValue("title"),
-		RawContent: r.FormValue("content"),
 		Visibility: config.Visibility(visibility),
 		Duration:   time.Duration(duration),
-		Language:   r.FormValue("language"),
+		ParentID:   parent,
+		Views:      views,
+		Password:   r.FormValue("password"),
+	}
+	pasteTmpl.SetFiles(parseFiles(r))
+
+	pasteTmpl.Attachments, err = parseAttachments(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The browser encrypts all files into a single ciphertext
+	if r.FormValue("encrypted") == "1" && config.Get().Encryption.Enabled {
+		if len(pasteTmpl.Files) > 0 || !isCiphertext(pasteTmpl.RawContent) {
+			return nil, errors.New("encrypted pastes require Javascript")
+		} else if len(pasteTmpl.Attachments) > 0 {
+			return nil, errors.New("encrypted pastes can't have attachments")
+		}
+		pasteTmpl.Kind = model.PasteKindEncrypted
+		pasteTmpl.Filename = ""
+		pasteTmpl.Language = "plaintext"
+	}
+
+	return &pasteTmpl, nil
+}
+
+// parseForm parses the form posted from the write page, which is sent as
+// multipart if files can be attached.
+func parseForm(r *http.Request) error {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		return r.ParseMultipartForm(maxRawPasteSize)
+	}
+	return r.ParseForm()
+}
+
+// parseAttachments reads the files uploaded as attachments on the write page.
+func parseAttachments(r *http.Request) ([]model.PasteAttachment, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+
+	attachments := []model.PasteAttachment{}
+	for _, header := range r.MultipartForm.File["attachment"] {
+		content, err := readMultipartFile(header)
+		if err != nil {
+			return nil, err
+		} else if len(content) > 0 {
+			attachments = append(attachments, newAttachment(header.Filename, content))
+		}
+	}
+	return attachments, nil
+}
+
+// keptAttachments returns the attachments of the paste that weren't removed on
+// the edit page.
+func keptAttachments(r *http.Request, paste *model.Paste) []model.PasteAttachment {
+	removed := make(map[string]bool)
+	for _, position := range r.Form["remove_attachment"] {
+		removed[position] = true
+	}
+
+	kept := []model.PasteAttachment{}
+	for _, attachment := range paste.Attachments {
+		if !removed[strconv.Itoa(attachment.Position)] {
+			kept = append(kept, attachment)
+		}
+	}
+	return kept
+}
+
+// newAttachment creates an attachment from an uploaded file. The type of the
+// content is detected instead of trusting the uploader.
+func newAttachment(filename string, content []byte) model.PasteAttachment {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		name = "attachment"
+	}
+
+	return model.PasteAttachment{
+		Filename:    name,
+		ContentType: http.DetectContentType(content),
+		Size:        int64(len(content)),
+		Content:     content,
+	}
+}
+
+// validateAttachments checks the number and the total size of the attachments.
+// Attachments are served from their own URL, which stops working once a paste
+// with a view limit is deleted, so those pastes can't have attachments.
+func validateAttachments(pasteTmpl *model.PasteTemplate) error {
+	if len(pasteTmpl.Attachments) == 0 {
+		return nil
+	} else if !config.Get().Attachments.Enabled {
+		return errors.New("attachments are disabled")
+	} else if pasteTmpl.Views > 0 {
+		return errors.New("pastes with a view limit can't have attachments")
+	} else if len(pasteTmpl.Attachments) > maxPasteAttachments {
+		return fmt.Errorf("a paste can have at most %d attachments", maxPasteAttachments)
+	}
+
+	var size int64
+	for _, attachment := range pasteTmpl.Attachments {
+		size += attachment.Size
+	}
+
+	if size > config.Get().Attachments.MaxSize {
+		return fmt.Errorf("attachments can't be larger than %s in total", fmtutil.FormatByteSize(config.Get().Attachments.MaxSize))
+	}
+	return nil
+}
+
+// isBinary returns true if the content can't be displayed as text.
+func isBinary(content []byte) bool {
+	return !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0
+}
+
+// limitBody limits the size of a posted paste including its attachments.
+func limitBody(w http.ResponseWriter, r *http.Request) {
+	limit := maxRawPasteSize
+	if config.Get().Attachments.Enabled {
+		limit += config.Get().Attachments.MaxSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+}
+
+// parseFiles returns the files posted from the write page. The name, language
+// and content of each file are posted as repeated fields in the same order.
+func parseFiles(r *http.Request) []model.PasteFile {
+	filenames := r.Form["filename"]
+	languages := r.Form["language"]
+	contents := r.Form["content"]
+
+	files := make([]model.PasteFile, 0, len(contents))
+	for i, content := range contents {
+		file := model.PasteFile{RawContent: content}
+		if i < len(filenames) {
+			file.Filename = strings.TrimSpace(filenames[i])
+		}
+		if i < len(languages) {
+			file.Language = languages[i]
+		}
+		files = append(files, file)
+	}
+
+	if len(files) == 0 {
+		files = append(files, model.PasteFile{})
+	}
+	return files
+}
+
+// editDraft adds or removes a file if the write page was posted using the add
+// or remove file buttons. Returns false if the paste should be saved instead.
+func editDraft(r *http.Request, pasteTmpl *model.PasteTemplate) bool {
+	files := pasteTmpl.AllFiles()
+	if r.FormValue("add_file") != "" {
+		files = append(files, model.PasteFile{Language: "plaintext"})
+	} else if value := r.FormValue("remove_file"); value != "" {
+		i, err := strconv.Atoi(value)
+		if err == nil && i >= 0 && i < len(files) && len(files) > 1 {
+			files = append(files[:i], files[i+1:]...)
+		}
+	} else {
+		return false
+	}
+
+	pasteTmpl.SetFiles(files)
+	return true
+}
+
+// validateFiles drops additional files left empty and makes sure that every
+// file of a paste with multiple files can be downloaded using its name.
+func validateFiles(pasteTmpl *model.PasteTemplate) error {
+	files := make([]model.PasteFile, 0, len(pasteTmpl.Files)+1)
+	for i, file := range pasteTmpl.AllFiles() {
+		if i == 0 || strings.TrimSpace(file.RawContent) != "" {
+			files = append(files, file)
+		}
+	}
+
+	if len(files) > maxPasteFiles {
+		return fmt.Errorf("a paste can contain at most %d files", maxPasteFiles)
+	}
+
+	names := make(map[string]bool, len(files))
+	for _, file := range files {
+		if file.Filename == "" && len(files) > 1 {
+			return errors.New("every file of a paste with multiple files needs a name")
+		} else if strings.ContainsAny(file.Filename, "/\\") || file.Filename == "." || file.Filename == ".." {
+			return fmt.Errorf("invalid filename '%s'", file.Filename)
+		} else if names[file.Filename] {
+			return fmt.Errorf("duplicate filename '%s'", file.Filename)
+		}
+		names[file.Filename] = true
 	}
+
+	pasteTmpl.SetFiles(files)
+	return nil
+}
+
+// isCiphertext returns true if the content looks like a paste encrypted in the
+// browser. This catches plain text posted as encrypted when Javascript is
+// disabled, the ciphertext itself can't be verified.
+func isCiphertext(content string) bool {
+	return ciphertextPattern.MatchString(content)
+}
+
+// parseDiffRange returns the revisions given by the from and to query
+// parameters. By default the latest revision is compared to the previous one.
+// sql.ErrNoRows is returned if either revision doesn't exist.
+func parseDiffRange(r *http.Request, paste *model.Paste, revisions []model.PasteRevision) (*model.PasteRevision, *model.PasteRevision, error) {
+	query := r.URL.Query()
+	to := paste.Revision
+	if value := query.Get("to"); value != "" {
+		var err error
+		to, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid revision '%s'", value)
+		}
+	}
+
+	from := to - 1
+	if value := query.Get("from"); value != "" {
+		var err error
+		from, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid revision '%s'", value)
+		}
+	}
+
+	if from < 1 {
+		from = 1
+	}
+
+	var fromRevision, toRevision *model.PasteRevision
+	for i := range revisions {
+		if revisions[i].Revision == from {
+			fromRevision = &revisions[i]
+		}
+		if revisions[i].Revision == to {
+			toRevision = &revisions[i]
+		}
+	}
+
+	if fromRevision == nil || toRevision == nil {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	return fromRevision, toRevision, nil
+}
+
+// parseRawTemplate parses a paste from the request body. Options are read from
+// the query parameters and fall back to the equivalent X-Paste-* headers. The
+// password is only read from the X-Paste-Password header, since the URL ends
+// up in logs and the shell history.
+func parseRawTemplate(w http.ResponseWriter, r *http.Request) (*model.PasteTemplate, error) {
+	limitBody(w, r)
+
+	filename := rawOption(r, "filename")
+	var files []model.PasteFile
+	var attachments []model.PasteAttachment
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		files, attachments, err = readMultipartFiles(r, filename)
+	} else {
+		var content []byte
+		content, err = ioutil.ReadAll(r.Body)
+		if config.Get().Attachments.Enabled && isBinary(content) {
+			files = []model.PasteFile{{}}
+			attachments = []model.PasteAttachment{newAttachment(filename, content)}
+		} else {
+			files = []model.PasteFile{{Filename: filename, RawContent: string(content)}}
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paste content: %s", err)
+	} else if strings.TrimSpace(files[0].RawContent) == "" && len(attachments) == 0 {
+		return nil, errors.New("paste content required")
+	}
+
+	language := ""
+	if value := rawOption(r, "language"); value != "" && config.Get().Highlight.Enabled {
+		var ok bool
+		language, ok = config.Get().Highlight.FindLanguage(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid language '%s'", value)
+		}
+	}
+
+	for i := range files {
+		if files[i].Filename != "" {
+			files[i].Filename = path.Base(files[i].Filename)
+		}
+
+		if !config.Get().Highlight.Enabled {
+			files[i].Language = "plaintext"
+		} else if i == 0 && language != "" {
+			files[i].Language = language
+		} else if files[i].Filename != "" {
+			files[i].Language = fmtutil.DetectLanguage(files[i].Filename)
+		} else {
+			files[i].Language = "plaintext"
+		}
+	}
+
+	pasteTmpl := model.PasteTemplate{
+		Title:       rawOption(r, "title"),
+		Visibility:  config.Get().Visibility.Default,
+		Password:    r.Header.Get("X-Paste-Password"),
+		Attachments: attachments,
+	}
+	pasteTmpl.SetFiles(files)
+
+	if pasteTmpl.Title == "" {
+		pasteTmpl.Title = pasteTmpl.Filename
+	}
+	if pasteTmpl.Title == "" && len(attachments) > 0 {
+		pasteTmpl.Title = attachments[0].Filename
+	}
+
+	if value := rawOption(r, "visibility"); value != "" && config.Get().Visibility.Enabled {
+		visibility, ok := config.ParseVisibility(value)
+		if !ok {
+			return nil, fmt.Errorf("invalid visibility '%s'", value)
+		}
+		pasteTmpl.Visibility = visibility
+	}
+
+	if config.Get().Expiry.Enabled {
+		pasteTmpl.Duration, err = parseRawExpiry(rawOption(r, "expiry"))
+		if err != nil {
+			return nil, err
+		}
+
+		pasteTmpl.Views, err = parseRawViews(rawOption(r, "views"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = validateFiles(&pasteTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateAttachments(&pasteTmpl)
+	if err != nil {
+		return nil, err
+	}
+
 	return &pasteTmpl, nil
 }
+
+// readMultipartFiles returns the uploaded files, or the content field if no
+// file was uploaded. The filename option overrides the name of the first file.
+// Binary files are returned as attachments if attachments are enabled.
+func readMultipartFiles(r *http.Request, filename string) ([]model.PasteFile, []model.PasteAttachment, error) {
+	err := r.ParseMultipartForm(maxRawPasteSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	headers := r.MultipartForm.File["file"]
+	if len(headers) == 0 {
+		return []model.PasteFile{{Filename: filename, RawContent: r.FormValue("content")}}, nil, nil
+	}
+
+	files := make([]model.PasteFile, 0, len(headers))
+	attachments := []model.PasteAttachment{}
+	for i, header := range headers {
+		content, err := readMultipartFile(header)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		name := header.Filename
+		if i == 0 && filename != "" {
+			name = filename
+		}
+
+		if config.Get().Attachments.Enabled && isBinary(content) {
+			attachments = append(attachments, newAttachment(name, content))
+		} else {
+			files = append(files, model.PasteFile{Filename: name, RawContent: string(content)})
+		}
+	}
+
+	if len(files) == 0 {
+		files = append(files, model.PasteFile{})
+	}
+	return files, attachments, nil
+}
+
+func readMultipartFile(header *multipart.FileHeader) ([]byte, error) {
+	file, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ioutil.ReadAll(file)
+}
+
+// parseRawExpiry parses the expiry given either in seconds or as a duration
+// such as 1h30m, which has to be one of the options of the write page.
+// Without an expiry the first option is used, same as on the write page.
+func parseRawExpiry(value string) (time.Duration, error) {
+	conf := config.Get().Expiry
+	if value == "" {
+		if len(conf.RawDurations) > 0 {
+			return time.Duration(conf.RawDurations[0]) * time.Minute, nil
+		}
+		return 0, nil
+	}
+
+	var duration time.Duration
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		duration = time.Duration(seconds) * time.Second
+	} else {
+		duration, err = time.ParseDuration(value)
+	}
+
+	if err != nil || !conf.HasDuration(duration) {
+		return 0, fmt.Errorf("invalid expiry '%s', expected one of %s seconds", value, formatSeconds(conf.RawDurations))
+	}
+
+	return duration, nil
+}
+
+// parseRawViews parses the number of views after which the paste is deleted,
+// which has to be one of the options of the write page. Zero allows unlimited
+// views. Without a number the first option is used.
+func parseRawViews(value string) (int64, error) {
+	conf := config.Get().Expiry
+	if value == "" {
+		if len(conf.Views) > 0 {
+			return conf.Views[0], nil
+		}
+		return 0, nil
+	}
+
+	views, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || !conf.HasViews(views) {
+		return 0, fmt.Errorf("invalid views '%s', expected one of %v", value, conf.Views)
+	}
+
+	return views, nil
+}
+
+func rawOption(r *http.Request, name string) string {
+	if value := r.URL.Query().Get(name); value != "" {
+		return value
+	}
+	return r.Header.Get("X-Paste-" + name)
+}
@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model/store"
+)
+
+func newTestPasteController() *PasteController {
+	conf := config.NewDefaultConfig()
+	conf.Authentication.Enabled = false
+	conf.BaseURL = "https://bingo.example.org/"
+	return &PasteController{store: store.NewMemoryPasteStore(store.NewMemoryDatabase())}
+}
+
+func postRawPaste(ctrl *PasteController, target string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	ctrl.CreateRawPaste(w, r)
+	return w
+}
+
+func TestCreateRawPaste(t *testing.T) {
+	ctrl := newTestPasteController()
+
+	r := httptest.NewRequest(http.MethodPost, "/?language=python&expiry=1h&views=10", strings.NewReader("print(1)"))
+	r.Host = "evil.example.com"
+	r.Header.Set("X-Forwarded-Proto", "http")
+	w := httptest.NewRecorder()
+	ctrl.CreateRawPaste(w, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+
+	location := w.Header().Get("Location")
+	if !strings.HasPrefix(location, "https://bingo.example.org/pastes/") {
+		t.Errorf("expected a location using the base URL, got '%s'", location)
+	}
+	if strings.TrimSpace(w.Body.String()) != location {
+		t.Errorf("expected the body to be the location %s, got %s", location, w.Body)
+	}
+}
+
+func TestCreateRawPasteDefaults(t *testing.T) {
+	ctrl := newTestPasteController()
+
+	w := postRawPaste(ctrl, "/", "hello")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, w.Code, w.Body)
+	}
+}
+
+func TestCreateRawPasteRejectsOptions(t *testing.T) {
+	ctrl := newTestPasteController()
+	config.Get().Expiry.RawDurations = []int{10, 60}
+
+	tests := []struct {
+		name   string
+		target string
+	}{
+		{"unknown language", "/?language=Klingon"},
+		{"unconfigured expiry", "/?expiry=1"},
+		{"unconfigured duration", "/?expiry=2h"},
+		{"never expiring", "/?expiry=0"},
+		{"negative expiry", "/?expiry=-600"},
+		{"invalid expiry", "/?expiry=soon"},
+		{"unconfigured views", "/?views=3"},
+		{"negative views", "/?views=-1"},
+		{"invalid views", "/?views=many"},
+	}
+
+	for _, test := range tests {
+		w := postRawPaste(ctrl, test.target, "hello")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", test.name, http.StatusBadRequest, w.Code)
+		}
+	}
+
+	w := postRawPaste(ctrl, "/?expiry=1h", "hello")
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected a configured expiry to be accepted, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestCreateRawPastePassword(t *testing.T) {
+	ctrl := newTestPasteController()
+
+	tests := []struct {
+		name      string
+		target    string
+		header    string
+		protected bool
+	}{
+		{"header", "/", "secret", true},
+		{"query", "/?password=secret", "", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader("hello"))
+		if test.header != "" {
+			r.Header.Set("X-Paste-Password", test.header)
+		}
+		w := httptest.NewRecorder()
+		ctrl.CreateRawPaste(w, r)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("%s: expected status %d, got %d: %s", test.name, http.StatusCreated, w.Code, w.Body)
+		}
+
+		id, err := strconv.ParseInt(path.Base(w.Header().Get("Location")), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paste, err := ctrl.store.FindByID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if paste.PasswordHash.Valid != test.protected {
+			t.Errorf("%s: expected protected to be %v, got %v", test.name, test.protected, paste.PasswordHash.Valid)
+		}
+	}
+}
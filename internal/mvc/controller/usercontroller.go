@@ -4,8 +4,11 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"html"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"bingo/internal/config"
 	"bingo/internal/http/httpext"
@@ -13,23 +16,40 @@ import (
 	"bingo/internal/mvc/model/store"
 	"bingo/internal/mvc/view"
 	"bingo/internal/session"
+	"bingo/internal/util/auth"
 	"bingo/internal/util/log"
 )
 
 // UserController serves the view for creating and controllering users.
 type UserController struct {
-	err   *ErrorController
-	store *store.UserStore
-	view  *view.UserView
+	err        *ErrorController
+	store      store.UserRepository
+	tokenStore store.TokenRepository
+	auditStore store.AuditRepository
+	throttle   *auth.LoginThrottle
+	view       *view.UserView
 }
 
-// NewUserController creates a new UserController.
-func NewUserController(errCtrl *ErrorController, store *store.UserStore) *UserController {
+const (
+	// auditListLimit is the number of audit log entries shown on the users
+	// page.
+	auditListLimit = 20
+)
+
+// NewUserController creates a new UserController. Failed logins are kept in
+// the session store, so the session has to be initialized before.
+func NewUserController(errCtrl *ErrorController, store store.UserRepository, tokenStore store.TokenRepository, auditStore store.AuditRepository) *UserController {
 	ctrl := new(UserController)
 	ctrl.err = errCtrl
 	ctrl.store = store
+	ctrl.tokenStore = tokenStore
+	ctrl.auditStore = auditStore
 	ctrl.view = view.NewUserView()
 
+	if config.Get().Authentication.Enabled && config.Get().Authentication.Lockout.Enabled {
+		ctrl.throttle = auth.NewLoginThrottle(session.Get().Store)
+	}
+
 	if ctrl.store.Count() == 0 {
 		ctrl.createInitialUser()
 	}
@@ -51,7 +71,19 @@ func (ctrl *UserController) ServeProfilePage(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	ctx := ctrl.view.NewEditProfileContext(r, user)
+	tokens, err := ctrl.tokenStore.FindByUser(user.ID)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve profile page:", err.Error()))
+		return
+	}
+
+	codeHashes, err := ctrl.store.FindRecoveryCodes(user.ID)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve profile page:", err.Error()))
+		return
+	}
+
+	ctx := ctrl.view.NewEditProfileContext(r, user, tokens, len(codeHashes))
 	ctrl.view.Profile.Render(w, ctx)
 }
 
@@ -75,7 +107,13 @@ func (ctrl *UserController) ServeListPage(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	ctx := ctrl.view.NewListUsersContext(r, users)
+	entries, err := ctrl.auditStore.FindRange(auditListLimit, 0)
+	if err != nil {
+		ctrl.err.ServeInternalServerError(w, r, fmt.Sprintln("Failed to serve list users page:", err.Error()))
+		return
+	}
+
+	ctx := ctrl.view.NewListUsersContext(r, users, ctrl.findLocked(users), entries)
 	ctrl.view.List.Render(w, ctx)
 }
 
@@ -111,6 +149,31 @@ func (ctrl *UserController) UpdateProfile(w http.ResponseWriter, r *http.Request
 	httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
 }
 
+// CreateToken creates a new API token for the logged in user.
+func (ctrl *UserController) CreateToken(w http.ResponseWriter, r *http.Request) {
+	apiToken, token, err := ctrl.createToken(r)
+	if err != nil {
+		httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, model.NewErrorNotification("Failed to create token", err.Error()))
+		return
+	}
+
+	msg := fmt.Sprintf("Token <b>%s</b> created. Copy it now, it won't be shown again:<br><code>%s</code>", html.EscapeString(apiToken.Name), token)
+	note := model.NewSuccessNotification("Created", msg)
+	httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+}
+
+// DeleteToken revokes an API token of the logged in user.
+func (ctrl *UserController) DeleteToken(w http.ResponseWriter, r *http.Request) {
+	err := ctrl.deleteToken(r)
+	if err != nil {
+		httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, model.NewErrorNotification("Failed to revoke token", err.Error()))
+		return
+	}
+
+	note := model.NewSuccessNotification("Revoked", "Token revoked successfully")
+	httpext.RedirectWithNotify(w, r, "/profile", http.StatusSeeOther, note)
+}
+
 // UpdateUser updates an existing user.
 func (ctrl *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	user, err := ctrl.updateUser(r)
@@ -124,6 +187,49 @@ func (ctrl *UserController) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	httpext.RedirectWithNotify(w, r, "/users", http.StatusSeeOther, note)
 }
 
+// DisableTOTP disables two-factor authentication of a user who lost access to
+// their authenticator app and recovery codes.
+func (ctrl *UserController) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := ctrl.getUser(r)
+	if err == nil {
+		err = ctrl.store.DisableTOTP(user.ID)
+	}
+	if err != nil {
+		httpext.ReloadWithError(w, r, "Failed to disable two-factor authentication", err.Error())
+		return
+	}
+
+	log.Infof("Two-factor authentication of user '%s' disabled by an admin", user.UID)
+	msg := fmt.Sprintf("Two-factor authentication of <b>%s</b> disabled successfully", html.EscapeString(user.Name))
+	note := model.NewSuccessNotification("Disabled", msg)
+	httpext.RedirectWithNotify(w, r, "/users", http.StatusSeeOther, note)
+}
+
+// UnlockUser unlocks an account locked after too many failed logins.
+func (ctrl *UserController) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	user, err := ctrl.getUser(r)
+	if err == nil {
+		err = ctrl.throttle.Reset(user.UID)
+	}
+	if err != nil {
+		httpext.RedirectWithNotify(w, r, "/users", http.StatusSeeOther, model.NewErrorNotification("Failed to unlock user", err.Error()))
+		return
+	}
+
+	admin := session.User(r)
+	ctrl.audit(&model.AuditEntry{
+		Event:   model.AuditAccountUnlocked,
+		UID:     user.UID,
+		Address: ctrl.throttle.Address(r),
+		Detail:  fmt.Sprintf("unlocked by '%s'", admin.UID),
+	})
+
+	log.Infof("User '%s' unlocked by '%s'", user.UID, admin.UID)
+	msg := fmt.Sprintf("User <b>%s</b> unlocked successfully", html.EscapeString(user.Name))
+	note := model.NewSuccessNotification("Unlocked", msg)
+	httpext.RedirectWithNotify(w, r, "/users", http.StatusSeeOther, note)
+}
+
 // DeleteUser deletes an existing user.
 func (ctrl *UserController) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	user, err := ctrl.deleteUser(r)
@@ -146,6 +252,31 @@ func (ctrl *UserController) getUser(r *http.Request) (*model.User, error) {
 	return ctrl.store.FindByID(id)
 }
 
+// findLocked returns the users that are locked after too many failed logins,
+// along with until when they are locked.
+func (ctrl *UserController) findLocked(users []model.User) []view.LockedUser {
+	locked := []view.LockedUser{}
+	if ctrl.throttle == nil {
+		return locked
+	}
+
+	for _, user := range users {
+		if until, ok := ctrl.throttle.LockedUntil(user.UID, time.Now()); ok {
+			locked = append(locked, view.LockedUser{User: user, Until: until})
+		}
+	}
+	return locked
+}
+
+// audit adds the entry to the audit log. Failing to do so is only logged,
+// since it shouldn't keep the action from completing.
+func (ctrl *UserController) audit(entry *model.AuditEntry) {
+	err := ctrl.auditStore.Insert(entry)
+	if err != nil {
+		log.Errorf("Failed to add %s to audit log: %s", entry.Event, err)
+	}
+}
+
 func (ctrl *UserController) createInitialUser() error {
 	log.Debug("Creating initial admin user")
 
@@ -177,6 +308,46 @@ func (ctrl *UserController) createUser(userTmpl *model.UserTemplate) (*model.Use
 	return user, nil
 }
 
+func (ctrl *UserController) createToken(r *http.Request) (*model.APIToken, string, error) {
+	err := r.ParseForm()
+	if err != nil {
+		return nil, "", err
+	}
+
+	user := session.User(r)
+	if user == nil {
+		return nil, "", errors.New("no user logged in")
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		return nil, "", errors.New("token name required")
+	}
+
+	return ctrl.tokenStore.Insert(user.ID, name)
+}
+
+func (ctrl *UserController) deleteToken(r *http.Request) error {
+	id, err := httpext.ParseID(r)
+	if err != nil {
+		return err
+	}
+
+	user := session.User(r)
+	if user == nil {
+		return errors.New("no user logged in")
+	}
+
+	count, err := ctrl.tokenStore.Delete(id, user.ID)
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return errors.New("token not found")
+	}
+
+	return nil
+}
+
 // DeleteUser deletes an existing user.
 func (ctrl *UserController) deleteUser(r *http.Request) (*model.User, error) {
 	id, err := httpext.ParseID(r)
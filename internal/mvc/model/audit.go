@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+const (
+	// AuditAccountLocked is recorded when an account is locked after too many
+	// failed logins.
+	AuditAccountLocked = "account_locked"
+
+	// AuditAddressLocked is recorded when an address is locked after too many
+	// failed logins.
+	AuditAddressLocked = "address_locked"
+
+	// AuditAccountUnlocked is recorded when an admin unlocks an account.
+	AuditAccountUnlocked = "account_unlocked"
+)
+
+// AuditEntry records a security relevant event. Entries refer to users by
+// their uid, so they are kept when the user is deleted.
+type AuditEntry struct {
+	ID          int64     `db:"id"`
+	TimeCreated time.Time `db:"time_created"`
+	Event       string    `db:"event"`
+	UID         string    `db:"uid"`
+	Address     string    `db:"address"`
+	Detail      string    `db:"detail"`
+}
+
+// Title returns a readable name of the event.
+func (entry *AuditEntry) Title() string {
+	switch entry.Event {
+	case AuditAccountLocked:
+		return "Account locked"
+	case AuditAddressLocked:
+		return "Address locked"
+	case AuditAccountUnlocked:
+		return "Account unlocked"
+	default:
+		return entry.Event
+	}
+}
@@ -0,0 +1,323 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+)
+
+// Version is the version of the backup format written by Export. Import reads
+// backups up to this version. It's increased when existing records change in
+// a way older versions can't read, entities added later are written as
+// records of a new type instead, which older versions skip.
+const Version = 1
+
+const (
+	recordHeader = "header"
+	recordUser   = "user"
+	recordToken  = "token"
+	recordPaste  = "paste"
+)
+
+// Filter selects what is exported. Users and their tokens are always
+// exported unless a user is selected.
+type Filter struct {
+	// UserID limits the backup to the given user, their tokens and pastes.
+	UserID sql.NullInt64
+
+	// Since and Until limit the pastes to those created in between, zero
+	// times don't limit them.
+	Since time.Time
+	Until time.Time
+}
+
+// Summary counts the records exported or imported.
+type Summary struct {
+	Users   int64
+	Tokens  int64
+	Pastes  int64
+	Skipped int64
+}
+
+// record is a single line of a backup. Every backup starts with a header.
+type record struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+type header struct {
+	Version     int       `json:"version"`
+	TimeCreated time.Time `json:"time_created"`
+}
+
+// user is a user along with the hashes of their unused recovery codes.
+type user struct {
+	ID                 int64           `json:"id"`
+	TimeCreated        time.Time       `json:"time_created"`
+	UID                string          `json:"uid"`
+	Name               string          `json:"name"`
+	Email              *string         `json:"email,omitempty"`
+	EmailVerified      *bool           `json:"email_verified,omitempty"`
+	PasswordHash       *string         `json:"password_hash,omitempty"`
+	AuthMode           config.AuthMode `json:"auth_mode"`
+	Role               config.Role     `json:"role"`
+	Theme              config.Theme    `json:"theme"`
+	TOTPSecret         *string         `json:"totp_secret,omitempty"`
+	RecoveryCodeHashes []string        `json:"recovery_code_hashes,omitempty"`
+	OIDCIssuer         *string         `json:"oidc_issuer,omitempty"`
+	OIDCSubject        *string         `json:"oidc_subject,omitempty"`
+}
+
+type token struct {
+	ID           int64      `json:"id"`
+	UserID       int64      `json:"user_id"`
+	TimeCreated  time.Time  `json:"time_created"`
+	TimeLastUsed *time.Time `json:"time_last_used,omitempty"`
+	Name         string     `json:"name"`
+	Prefix       string     `json:"prefix"`
+	TokenHash    string     `json:"token_hash"`
+}
+
+// paste is a paste along with its files, attachments and previous revisions.
+// Only raw content is kept, it's highlighted again when importing.
+type paste struct {
+	ID           int64             `json:"id"`
+	TimeCreated  time.Time         `json:"time_created"`
+	TimeUpdated  *time.Time        `json:"time_updated,omitempty"`
+	TimeExpires  *time.Time        `json:"time_expires,omitempty"`
+	UserID       *int64            `json:"user_id,omitempty"`
+	ParentID     *int64            `json:"parent_id,omitempty"`
+	Title        string            `json:"title"`
+	Kind         model.PasteKind   `json:"kind"`
+	Visibility   config.Visibility `json:"visibility"`
+	Revision     int               `json:"revision"`
+	ViewsLeft    *int64            `json:"views_left,omitempty"`
+	EditKeyHash  *string           `json:"edit_key_hash,omitempty"`
+	PasswordHash *string           `json:"password_hash,omitempty"`
+	Files        []file            `json:"files"`
+	Attachments  []attachment      `json:"attachments,omitempty"`
+	Revisions    []revision        `json:"revisions,omitempty"`
+}
+
+type file struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	Content  string `json:"content"`
+}
+
+type attachment struct {
+	Position    int    `json:"position"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Content     []byte `json:"content"`
+}
+
+type revision struct {
+	Revision    int       `json:"revision"`
+	TimeCreated time.Time `json:"time_created"`
+	Title       string    `json:"title"`
+	Language    string    `json:"language"`
+	Content     string    `json:"content"`
+}
+
+func newUser(u *model.User, codeHashes []string) *user {
+	return &user{
+		ID:                 u.ID,
+		TimeCreated:        u.TimeCreated,
+		UID:                u.UID,
+		Name:               u.Name,
+		Email:              fromNullString(u.Email),
+		EmailVerified:      &u.EmailVerified,
+		PasswordHash:       fromNullString(u.PasswordHash),
+		AuthMode:           u.AuthMode,
+		Role:               u.Role,
+		Theme:              u.Theme,
+		TOTPSecret:         fromNullString(u.TOTPSecret),
+		RecoveryCodeHashes: codeHashes,
+		OIDCIssuer:         fromNullString(u.OIDCIssuer),
+		OIDCSubject:        fromNullString(u.OIDCSubject),
+	}
+}
+
+func (u *user) model() *model.User {
+	return &model.User{
+		ID:            u.ID,
+		TimeCreated:   u.TimeCreated,
+		UID:           u.UID,
+		Name:          u.Name,
+		Email:         toNullString(u.Email),
+		EmailVerified: u.EmailVerified == nil || *u.EmailVerified,
+		PasswordHash:  toNullString(u.PasswordHash),
+		AuthMode:      u.AuthMode,
+		Role:          u.Role,
+		Theme:         u.Theme,
+		TOTPSecret:    toNullString(u.TOTPSecret),
+		OIDCIssuer:    toNullString(u.OIDCIssuer),
+		OIDCSubject:   toNullString(u.OIDCSubject),
+	}
+}
+
+func newToken(t *model.APIToken) *token {
+	return &token{
+		ID:           t.ID,
+		UserID:       t.UserID,
+		TimeCreated:  t.TimeCreated,
+		TimeLastUsed: fromNullTime(t.TimeLastUsed),
+		Name:         t.Name,
+		Prefix:       t.Prefix,
+		TokenHash:    t.TokenHash,
+	}
+}
+
+func (t *token) model() *model.APIToken {
+	return &model.APIToken{
+		ID:           t.ID,
+		UserID:       t.UserID,
+		TimeCreated:  t.TimeCreated,
+		TimeLastUsed: toNullTime(t.TimeLastUsed),
+		Name:         t.Name,
+		Prefix:       t.Prefix,
+		TokenHash:    t.TokenHash,
+	}
+}
+
+// newPaste converts a paste with its content and the content of its
+// attachments loaded. The revisions are the previous ones without the
+// current content of the paste.
+func newPaste(p *model.Paste, revisions []model.PasteRevision) *paste {
+	result := &paste{
+		ID:           p.ID,
+		TimeCreated:  p.TimeCreated,
+		TimeUpdated:  fromNullTime(p.TimeUpdated),
+		TimeExpires:  fromNullTime(p.TimeExpires),
+		UserID:       fromNullInt64(p.UserID),
+		ParentID:     fromNullInt64(p.ParentID),
+		Title:        p.Title,
+		Kind:         p.Kind,
+		Visibility:   p.Visibility,
+		Revision:     p.Revision,
+		ViewsLeft:    fromNullInt64(p.ViewsLeft),
+		EditKeyHash:  fromNullString(p.EditKeyHash),
+		PasswordHash: fromNullString(p.PasswordHash),
+	}
+
+	for _, f := range p.AllFiles() {
+		result.Files = append(result.Files, file{Filename: f.Filename, Language: f.Language, Content: f.RawContent})
+	}
+
+	for _, a := range p.Attachments {
+		result.Attachments = append(result.Attachments, attachment{
+			Position:    a.Position,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Content:     a.Content,
+		})
+	}
+
+	for _, r := range revisions {
+		result.Revisions = append(result.Revisions, revision{
+			Revision:    r.Revision,
+			TimeCreated: r.TimeCreated,
+			Title:       r.Title,
+			Language:    r.Language,
+			Content:     r.RawContent,
+		})
+	}
+	return result
+}
+
+func (p *paste) model() (*model.Paste, []model.PasteRevision) {
+	result := &model.Paste{
+		ID:           p.ID,
+		TimeCreated:  p.TimeCreated,
+		TimeUpdated:  toNullTime(p.TimeUpdated),
+		TimeExpires:  toNullTime(p.TimeExpires),
+		UserID:       toNullInt64(p.UserID),
+		ParentID:     toNullInt64(p.ParentID),
+		Title:        p.Title,
+		Kind:         p.Kind,
+		Visibility:   p.Visibility,
+		Revision:     p.Revision,
+		ViewsLeft:    toNullInt64(p.ViewsLeft),
+		EditKeyHash:  toNullString(p.EditKeyHash),
+		PasswordHash: toNullString(p.PasswordHash),
+	}
+
+	for i, f := range p.Files {
+		if i == 0 {
+			result.Filename = f.Filename
+			result.Language = f.Language
+			result.RawContent = f.Content
+			continue
+		}
+		result.Files = append(result.Files, model.PasteFile{Filename: f.Filename, Language: f.Language, RawContent: f.Content})
+	}
+
+	for _, a := range p.Attachments {
+		result.Attachments = append(result.Attachments, model.PasteAttachment{
+			Position:    a.Position,
+			Filename:    a.Filename,
+			ContentType: a.ContentType,
+			Size:        int64(len(a.Content)),
+			Content:     a.Content,
+		})
+	}
+
+	revisions := make([]model.PasteRevision, 0, len(p.Revisions))
+	for _, r := range p.Revisions {
+		revisions = append(revisions, model.PasteRevision{
+			PasteID:     p.ID,
+			Revision:    r.Revision,
+			TimeCreated: r.TimeCreated,
+			Title:       r.Title,
+			Language:    r.Language,
+			RawContent:  r.Content,
+		})
+	}
+	return result, revisions
+}
+
+func fromNullString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+func toNullString(value *string) sql.NullString {
+	if value == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: *value, Valid: true}
+}
+
+func fromNullInt64(value sql.NullInt64) *int64 {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Int64
+}
+
+func toNullInt64(value *int64) sql.NullInt64 {
+	if value == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: *value, Valid: true}
+}
+
+func fromNullTime(value sql.NullTime) *time.Time {
+	if !value.Valid {
+		return nil
+	}
+	return &value.Time
+}
+
+func toNullTime(value *time.Time) sql.NullTime {
+	if value == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *value, Valid: true}
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// usersPageSize is the number of users read at once while exporting.
+const usersPageSize = 100
+
+// Export writes a backup of the database to w. The backup is written as JSON
+// lines, a header followed by a record for every user, token and paste.
+// Expired pastes and sessions aren't exported.
+func Export(db *sqlx.DB, w io.Writer, filter Filter) (*Summary, error) {
+	userStore := store.NewUserStore(db)
+	tokenStore := store.NewTokenStore(db)
+	pasteStore := store.NewPasteStore(db, store.NewBlobStore(db))
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	summary := new(Summary)
+
+	err := writeRecord(encoder, recordHeader, header{Version: Version, TimeCreated: time.Now().UTC()})
+	if err != nil {
+		return summary, err
+	}
+
+	users, err := findUsers(userStore, filter)
+	if err != nil {
+		return summary, fmt.Errorf("failed to read users: %s", err)
+	}
+
+	for i := range users {
+		codeHashes, err := userStore.FindRecoveryCodes(users[i].ID)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read recovery codes of user %d: %s", users[i].ID, err)
+		}
+
+		err = writeRecord(encoder, recordUser, newUser(&users[i], codeHashes))
+		if err != nil {
+			return summary, err
+		}
+		summary.Users++
+
+		tokens, err := tokenStore.FindByUser(users[i].ID)
+		if err != nil {
+			return summary, fmt.Errorf("failed to read tokens of user %d: %s", users[i].ID, err)
+		}
+
+		for j := range tokens {
+			err = writeRecord(encoder, recordToken, newToken(&tokens[j]))
+			if err != nil {
+				return summary, err
+			}
+			summary.Tokens++
+		}
+	}
+
+	ids, err := pasteStore.FindIDs(filter.UserID, filter.Since, filter.Until)
+	if err != nil {
+		return summary, fmt.Errorf("failed to read pastes: %s", err)
+	}
+
+	for _, id := range ids {
+		paste, revisions, err := findPaste(pasteStore, id)
+		if err == sql.ErrNoRows {
+			log.Debugf("Paste %d expired while exporting", id)
+			continue
+		} else if err != nil {
+			return summary, fmt.Errorf("failed to read paste %d: %s", id, err)
+		}
+
+		err = writeRecord(encoder, recordPaste, newPaste(paste, revisions))
+		if err != nil {
+			return summary, err
+		}
+		summary.Pastes++
+	}
+
+	return summary, nil
+}
+
+// findUsers returns the user selected by the filter or all users.
+func findUsers(userStore *store.UserStore, filter Filter) ([]model.User, error) {
+	if filter.UserID.Valid {
+		user, err := userStore.FindByID(filter.UserID.Int64)
+		if err != nil {
+			return nil, err
+		}
+		return []model.User{*user}, nil
+	}
+
+	users := []model.User{}
+	for {
+		page, err := userStore.FindRange(usersPageSize, int64(len(users)))
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, page...)
+		if len(page) < usersPageSize {
+			return users, nil
+		}
+	}
+}
+
+// findPaste returns the paste with the given id with the content of its
+// attachments, along with its previous revisions.
+func findPaste(pasteStore *store.PasteStore, id int64) (*model.Paste, []model.PasteRevision, error) {
+	paste, err := pasteStore.FindForExport(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i := range paste.Attachments {
+		err = pasteStore.LoadAttachment(&paste.Attachments[i])
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The current content is included as the latest revision
+	revisions, err := pasteStore.FindRevisions(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := []model.PasteRevision{}
+	for _, revision := range revisions {
+		if revision.Revision != paste.Revision {
+			previous = append(previous, revision)
+		}
+	}
+	return paste, previous, nil
+}
+
+// writeRecord writes a record with the given data. It's encoded in one go, so
+// the content of pastes is written without escaping HTML.
+func writeRecord(encoder *json.Encoder, recordType string, data interface{}) error {
+	return encoder.Encode(struct {
+		Type string      `json:"type"`
+		Data interface{} `json:"data"`
+	}{recordType, data})
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Import restores a backup written by Export to the database, keeping the ids
+// of all records so links to pastes stay valid. Records that already exist
+// and pastes that have expired are skipped, so an interrupted import can be
+// run again. Pastes whose user or parent paste is missing are restored
+// without them.
+func Import(db *sqlx.DB, r io.Reader) (*Summary, error) {
+	userStore := store.NewUserStore(db)
+	tokenStore := store.NewTokenStore(db)
+	pasteStore := store.NewPasteStore(db, store.NewBlobStore(db))
+
+	decoder := json.NewDecoder(r)
+	summary := new(Summary)
+
+	err := readHeader(decoder)
+	if err != nil {
+		return summary, err
+	}
+
+	for {
+		var rec record
+		err = decoder.Decode(&rec)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return summary, fmt.Errorf("failed to read backup: %s", err)
+		}
+
+		var restored bool
+		switch rec.Type {
+		case recordUser:
+			restored, err = importUser(userStore, rec.Data)
+			if restored {
+				summary.Users++
+			}
+		case recordToken:
+			restored, err = importToken(tokenStore, rec.Data)
+			if restored {
+				summary.Tokens++
+			}
+		case recordPaste:
+			restored, err = importPaste(pasteStore, rec.Data)
+			if restored {
+				summary.Pastes++
+			}
+		default:
+			log.Warnf("Skipping record of unknown type '%s'", rec.Type)
+		}
+		if err != nil {
+			return summary, err
+		}
+
+		if !restored {
+			summary.Skipped++
+		}
+	}
+
+	err = store.SyncSequences(db)
+	if err != nil {
+		return summary, fmt.Errorf("failed to update id sequences: %s", err)
+	}
+	return summary, nil
+}
+
+func readHeader(decoder *json.Decoder) error {
+	var rec record
+	err := decoder.Decode(&rec)
+	if err == io.EOF {
+		return errors.New("the backup is empty")
+	} else if err != nil {
+		return fmt.Errorf("failed to read backup: %s", err)
+	} else if rec.Type != recordHeader {
+		return errors.New("not a backup, the header is missing")
+	}
+
+	var h header
+	err = json.Unmarshal(rec.Data, &h)
+	if err != nil {
+		return fmt.Errorf("failed to read backup header: %s", err)
+	} else if h.Version < 1 || h.Version > Version {
+		return fmt.Errorf("unsupported backup version %d, at most %d is supported", h.Version, Version)
+	}
+
+	log.Infof("Importing backup created at %s", h.TimeCreated.Format(time.RFC3339))
+	return nil
+}
+
+func importUser(userStore *store.UserStore, data json.RawMessage) (bool, error) {
+	var u user
+	err := json.Unmarshal(data, &u)
+	if err != nil {
+		return false, fmt.Errorf("failed to read user: %s", err)
+	}
+
+	restored, err := userStore.Restore(u.model(), u.RecoveryCodeHashes)
+	if err != nil {
+		return false, fmt.Errorf("failed to import user '%s': %s", u.UID, err)
+	} else if !restored {
+		log.Debugf("Skipping existing user '%s'", u.UID)
+	}
+	return restored, nil
+}
+
+func importToken(tokenStore *store.TokenStore, data json.RawMessage) (bool, error) {
+	var t token
+	err := json.Unmarshal(data, &t)
+	if err != nil {
+		return false, fmt.Errorf("failed to read token: %s", err)
+	}
+
+	restored, err := tokenStore.Restore(t.model())
+	if err != nil {
+		return false, fmt.Errorf("failed to import token %d: %s", t.ID, err)
+	} else if !restored {
+		log.Debugf("Skipping existing token %d", t.ID)
+	}
+	return restored, nil
+}
+
+func importPaste(pasteStore *store.PasteStore, data json.RawMessage) (bool, error) {
+	var p paste
+	err := json.Unmarshal(data, &p)
+	if err != nil {
+		return false, fmt.Errorf("failed to read paste: %s", err)
+	}
+
+	if p.TimeExpires != nil && p.TimeExpires.Before(time.Now()) {
+		log.Debugf("Skipping expired paste %d", p.ID)
+		return false, nil
+	}
+
+	restored, err := pasteStore.Restore(p.model())
+	if err != nil {
+		return false, fmt.Errorf("failed to import paste %d: %s", p.ID, err)
+	} else if !restored {
+		log.Debugf("Skipping existing paste %d", p.ID)
+	}
+	return restored, nil
+}
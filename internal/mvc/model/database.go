@@ -2,16 +2,35 @@ package model
 
 import (
 	"errors"
-
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"bingo/internal/config"
 	"bingo/internal/util/log"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	// Postgresql driver
 	_ "github.com/lib/pq"
+	// SQLite driver, written in pure Go so no C compiler is needed
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// DriverPostgres is the name of the Postgres database driver.
+	DriverPostgres = "postgres"
+
+	// DriverSQLite is the name of the SQLite database driver.
+	DriverSQLite = "sqlite"
+
+	// DriverMySQL is the name of the MySQL and MariaDB database driver.
+	DriverMySQL = "mysql"
+
+	// DriverMemory is the name of the driver keeping everything in memory
+	// instead of a database, see store.MemoryDatabase.
+	DriverMemory = "memory"
 )
 
 var (
@@ -31,7 +50,12 @@ func NewDatabase() *sqlx.DB {
 
 	log.Infof("Opening %s database", driver)
 	log.Debugf("Database connection string: %s", connStr)
-	db, err := sqlx.Open(driver, connStr)
+	var db *sqlx.DB
+	if driver == DriverMySQL {
+		db, err = openMySQL(connStr)
+	} else {
+		db, err = sqlx.Open(driver, connStr)
+	}
 	if err != nil {
 		log.Fatalf("Failed to open database: %s", err)
 	}
@@ -42,10 +66,21 @@ func NewDatabase() *sqlx.DB {
 	}
 
 	configureDatabase(db)
-	createPseudoEncrypt(db)
 	return db
 }
 
+// IsSQLite returns whether the database is a SQLite database. Stores use it to
+// replace the parts of their queries that only work with Postgres.
+func IsSQLite(db *sqlx.DB) bool {
+	return db.DriverName() == DriverSQLite
+}
+
+// IsMySQL returns whether the database is a MySQL or MariaDB database. Stores
+// use it to replace the parts of their queries that only work with Postgres.
+func IsMySQL(db *sqlx.DB) bool {
+	return db.DriverName() == DriverMySQL
+}
+
 func pollDatabase(db *sqlx.DB) error {
 	log.Infof("Trying to connect to database for %d seconds", dbConnectionRetries)
 
@@ -78,8 +113,17 @@ func getDataSource() (string, string, error) {
 	connStr := ""
 
 	switch driver {
-	case "postgres":
+	case DriverPostgres:
 		connStr = getPostgresConnectionString()
+	case DriverSQLite:
+		path := config.Get().Database.Path
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err != nil {
+			return "", "", err
+		}
+		connStr = getSQLiteConnectionString(path)
+	case DriverMySQL:
+		connStr = getMySQLConnectionString()
 	default:
 		return "", "", fmt.Errorf("invalid database driver '%s'", driver)
 	}
@@ -99,36 +143,45 @@ func getPostgresConnectionString() string {
 		dbConnectionTimeout)
 }
 
-// https://stackoverflow.com/questions/12761346/pseudo-encrypt-function-in-plpgsql-that-takes-bigint/12761795#12761795
-// Creates a function that maps big integers to another seemingly random big integer.
-// Used to make sure that object ids for are seemingly random.
-func createPseudoEncrypt(db *sqlx.DB) {
-	log.Debug("Creating pseudo encrypt function")
-
-	q := `
-	CREATE OR REPLACE FUNCTION pseudo_encrypt(VALUE bigint) returns bigint AS $$
-	DECLARE
-	l1 bigint;
-	l2 bigint;
-	r1 bigint;
-	r2 bigint;
-	i int:=0;
-	BEGIN
-		l1:= (VALUE >> 32) & 4294967295::bigint;
-		r1:= VALUE & 4294967295;
-		WHILE i < 3 LOOP
-			l2 := r1;
-			r2 := l1 # ((((1366.0 * r1 + 150889) % 714025) / 714025.0) * 32767*32767)::int;
-			l1 := l2;
-			r1 := r2;
-			i := i + 1;
-		END LOOP;
-	RETURN ((l1::bigint << 32) + r1);
-	END;
-	$$ LANGUAGE plpgsql strict immutable;
-	`
-	_, err := db.Exec(q)
-	if err != nil {
-		log.Fatalf("Failed to create function: %s", err)
+// Foreign keys are off by default in SQLite. Times are written in the format
+// understood by the date functions of SQLite. Transactions take the write lock
+// right away, so concurrent transactions wait for each other instead of
+// failing when they start writing.
+func getSQLiteConnectionString(path string) string {
+	return fmt.Sprintf(
+		"file:%s?_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(%d)&_time_format=sqlite&_txlock=immediate",
+		path,
+		dbConnectionTimeout*1000)
+}
+
+// Queries can contain multiple statements like with the other databases, and
+// identifiers can be quoted with double quotes. Times are stored in UTC, and
+// updates report the matched rows as affected like with the other databases.
+func getMySQLConnectionString() string {
+	conf := mysql.NewConfig()
+	conf.User = config.Get().Database.Username
+	conf.Passwd = config.Get().Database.Password
+	conf.Net = "tcp"
+	conf.Addr = fmt.Sprintf("%s:%d", config.Get().Database.Host, config.Get().Database.Port)
+	conf.DBName = config.Get().Database.Database
+	conf.Timeout = time.Duration(dbConnectionTimeout) * time.Second
+	conf.ParseTime = true
+	conf.MultiStatements = true
+	conf.ClientFoundRows = true
+	conf.Params = map[string]string{
+		"sql_mode": "CONCAT(@@sql_mode, ',ANSI_QUOTES')",
 	}
+
+	switch config.Get().Database.SSL {
+	case "disable":
+		conf.TLSConfig = "false"
+	case "allow", "prefer":
+		conf.TLSConfig = "preferred"
+	case "verify-ca", "verify-full":
+		conf.TLSConfig = "true"
+	default:
+		conf.TLSConfig = "skip-verify"
+	}
+
+	return conf.FormatDSN()
 }
@@ -0,0 +1,227 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+)
+
+// gist is a gist as returned by the GitHub API.
+type gist struct {
+	ID          string              `json:"id"`
+	Description string              `json:"description"`
+	Public      bool                `json:"public"`
+	CreatedAt   time.Time           `json:"created_at"`
+	Files       map[string]gistFile `json:"files"`
+}
+
+// gistFile is a file of a gist. The API leaves out the content of large
+// files, or truncates it.
+type gistFile struct {
+	Filename  string  `json:"filename"`
+	Language  string  `json:"language"`
+	Content   *string `json:"content"`
+	Truncated bool    `json:"truncated"`
+}
+
+// readGists reads gists from a JSON file containing a gist or a list of
+// gists, or from a directory. In a directory every JSON file is read, and
+// every subdirectory that isn't named after a gist in them is read as a
+// cloned gist. Content the API left out is read from the clone named after
+// the gist next to its JSON file.
+func readGists(imp *importer, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	imported := make(map[string]bool)
+	if !info.IsDir() {
+		return readGistFile(imp, path, imported)
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			err = readGistFile(imp, filepath.Join(path, entry.Name()), imported)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && !imported[entry.Name()] && !strings.HasPrefix(entry.Name(), ".") {
+			err = readGistClone(imp, filepath.Join(path, entry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readGistFile(imp *importer, path string, imported map[string]bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	gists := []gist{}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("[")) {
+		err = json.Unmarshal(data, &gists)
+	} else {
+		gists = append(gists, gist{})
+		err = json.Unmarshal(data, &gists[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read gists from %s: %s", path, err)
+	}
+
+	for i := range gists {
+		if gists[i].ID == "" {
+			return fmt.Errorf("failed to read gists from %s: a gist has no id", path)
+		}
+
+		imported[gists[i].ID] = true
+		paste, err := newGistPaste(&gists[i], filepath.Join(filepath.Dir(path), gists[i].ID))
+		if err != nil {
+			imp.skip(gists[i].ID, err.Error())
+			continue
+		}
+
+		err = imp.add(gists[i].ID, paste)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newGistPaste creates a paste with the files of the gist, which are sorted by
+// their name like on GitHub.
+func newGistPaste(g *gist, cloneDir string) (*model.Paste, error) {
+	names := make([]string, 0, len(g.Files))
+	for name := range g.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	paste := &model.Paste{
+		TimeCreated: g.CreatedAt.UTC(),
+		Title:       g.Description,
+		Visibility:  config.VisibilityUnlisted,
+	}
+
+	if g.Public {
+		paste.Visibility = listedVisibility()
+	}
+
+	files := []model.PasteFile{}
+	for _, name := range names {
+		file := g.Files[name]
+		if file.Filename == "" {
+			file.Filename = name
+		}
+
+		var content []byte
+		if file.Content != nil && !file.Truncated {
+			content = []byte(*file.Content)
+		} else {
+			var err error
+			content, err = ioutil.ReadFile(filepath.Join(cloneDir, filepath.Base(file.Filename)))
+			if err != nil {
+				return nil, fmt.Errorf("the content of %s is missing and there's no clone of the gist", file.Filename)
+			}
+		}
+
+		if isBinary(content) {
+			paste.Attachments = append(paste.Attachments, newAttachment(file.Filename, "", content))
+			continue
+		}
+
+		files = append(files, model.PasteFile{
+			Filename:   file.Filename,
+			RawContent: string(content),
+			Language:   language(file.Language, file.Filename),
+		})
+	}
+
+	setFiles(paste, files)
+	if paste.Title == "" {
+		paste.Title = paste.Filename
+	}
+	return paste, nil
+}
+
+// readGistClone reads a cloned gist. Clones only have the files of a gist, so
+// the paste is titled after the first file and created when the oldest file
+// was changed. It's unlisted, since it's unknown whether the gist was public.
+func readGistClone(imp *importer, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	id := filepath.Base(dir)
+	paste := &model.Paste{
+		TimeCreated: time.Now().UTC(),
+		Visibility:  config.VisibilityUnlisted,
+	}
+
+	files := []model.PasteFile{}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		if entry.ModTime().Before(paste.TimeCreated) {
+			paste.TimeCreated = entry.ModTime().UTC()
+		}
+
+		if isBinary(content) {
+			paste.Attachments = append(paste.Attachments, newAttachment(entry.Name(), "", content))
+			continue
+		}
+
+		files = append(files, model.PasteFile{
+			Filename:   entry.Name(),
+			RawContent: string(content),
+			Language:   language("", entry.Name()),
+		})
+	}
+
+	if len(files) == 0 && len(paste.Attachments) == 0 {
+		imp.skip(id, "the directory has no files")
+		return nil
+	}
+
+	setFiles(paste, files)
+	paste.Title = paste.Filename
+	return imp.add(id, paste)
+}
+
+// isBinary returns true if the content can't be displayed as text.
+func isBinary(content []byte) bool {
+	return !utf8.Valid(content) || bytes.IndexByte(content, 0) >= 0
+}
@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/store"
+	"bingo/internal/util/fmtutil"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// FormatPrivateBin reads the data directory of PrivateBin. Its pastes are
+	// encrypted, so the keys from their links are needed, see ReadKeys.
+	FormatPrivateBin = "privatebin"
+
+	// FormatStikked reads a JSON export of the pastes table of Stikked.
+	FormatStikked = "stikked"
+
+	// FormatGist reads GitHub gists as returned by the GitHub API, or cloned
+	// gist repositories.
+	FormatGist = "gist"
+)
+
+// Summary counts the pastes imported or skipped.
+type Summary struct {
+	Pastes  int64
+	Skipped int64
+}
+
+// importer inserts the pastes read from another pastebin.
+type importer struct {
+	format     string
+	pasteStore *store.PasteStore
+	summary    Summary
+}
+
+// IsFormat returns whether pastes can be imported from the given format.
+func IsFormat(format string) bool {
+	return format == FormatPrivateBin || format == FormatStikked || format == FormatGist
+}
+
+// Import reads the pastes of another pastebin in the given format from the
+// file or directory at path and inserts them to the database. Their ids are
+// derived from their ids in the source, so pastes imported before are
+// skipped. Keys are only needed for PrivateBin.
+func Import(db *sqlx.DB, format string, path string, keys map[string]Key) (*Summary, error) {
+	imp := &importer{
+		format:     format,
+		pasteStore: store.NewPasteStore(db, store.NewBlobStore(db)),
+	}
+
+	var err error
+	switch format {
+	case FormatPrivateBin:
+		err = readPrivateBin(imp, path, keys)
+	case FormatStikked:
+		err = readStikked(imp, path)
+	case FormatGist:
+		err = readGists(imp, path)
+	default:
+		err = fmt.Errorf("unknown import format '%s'", format)
+	}
+	return &imp.summary, err
+}
+
+// add inserts a paste read from the source with the given id. Its creation
+// time, title, files, languages, expiry and visibility must be set. Pastes
+// that are empty or have expired are skipped.
+func (imp *importer) add(sourceID string, paste *model.Paste) error {
+	paste.ID = pasteID(imp.format, sourceID)
+	paste.Revision = 1
+	paste.Kind = model.PasteKindText
+
+	if paste.TimeExpires.Valid && paste.TimeExpires.Time.Before(time.Now()) {
+		imp.skip(sourceID, "it has expired")
+		return nil
+	}
+
+	// Like new pastes, pastes with a view limit can't have attachments
+	if len(paste.Attachments) > 0 && (!config.Get().Attachments.Enabled || paste.ViewsLeft.Valid) {
+		log.Warnf("Leaving out the attachments of %s paste %s, they're disabled or the paste has a view limit", imp.format, sourceID)
+		paste.Attachments = nil
+	}
+
+	empty := len(paste.Attachments) == 0
+	for _, file := range paste.AllFiles() {
+		if strings.TrimSpace(file.RawContent) != "" {
+			empty = false
+		}
+	}
+	if empty {
+		imp.skip(sourceID, "it's empty")
+		return nil
+	}
+
+	restored, err := imp.pasteStore.Restore(paste, nil)
+	if err != nil {
+		return fmt.Errorf("failed to import %s paste %s: %s", imp.format, sourceID, err)
+	} else if !restored {
+		log.Infof("Skipping %s paste %s, it was imported before as %d", imp.format, sourceID, paste.ID)
+		imp.summary.Skipped++
+		return nil
+	}
+
+	log.Infof("Imported %s paste %s as %d", imp.format, sourceID, paste.ID)
+	imp.summary.Pastes++
+	return nil
+}
+
+// skip skips the paste with the given id in the source for the given reason.
+func (imp *importer) skip(sourceID string, reason string) {
+	log.Warnf("Skipping %s paste %s, %s", imp.format, sourceID, reason)
+	imp.summary.Skipped++
+}
+
+// pasteID derives the id of an imported paste from its format and its id in
+// the source.
+func pasteID(format string, sourceID string) int64 {
+	sum := sha256.Sum256([]byte(format + ":" + sourceID))
+	id := int64(binary.BigEndian.Uint64(sum[:8]) >> 1)
+	if id == 0 {
+		return 1
+	}
+	return id
+}
+
+// language returns the name of the chroma language matching the name used by
+// the source, or the filename.
+func language(name string, filename string) string {
+	if !config.Get().Highlight.Enabled {
+		return "plaintext"
+	}
+	return fmtutil.ParseLanguage(name, filename)
+}
+
+// listedVisibility returns the visibility of pastes that were listed in the
+// source, other pastes are unlisted.
+func listedVisibility() config.Visibility {
+	return config.Get().Visibility.Default
+}
+
+// newAttachment creates an attachment with the given content. The type of the
+// content is detected unless it's known.
+func newAttachment(filename string, contentType string, content []byte) model.PasteAttachment {
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+
+	return model.PasteAttachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(content)),
+		Content:     content,
+	}
+}
+
+// setFiles sets the files of the paste, the first one is stored in the paste
+// itself.
+func setFiles(paste *model.Paste, files []model.PasteFile) {
+	if len(files) == 0 {
+		return
+	}
+
+	paste.Filename = files[0].Filename
+	paste.RawContent = files[0].RawContent
+	paste.Language = files[0].Language
+	paste.Files = files[1:]
+}
@@ -0,0 +1,401 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/fmtutil"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// base58Alphabet is the alphabet PrivateBin encodes the keys in links with.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// privateBinKeySize is the size of the keys of PrivateBin pastes in bytes.
+const privateBinKeySize = 32
+
+// privateBinFile matches the names of paste files in the data directory of
+// PrivateBin. Versions before 1.3 stored them without the .php extension.
+var privateBinFile = regexp.MustCompile(`^[0-9a-f]{16}(\.php)?$`)
+
+// Key is the key of a PrivateBin paste from its link, along with its password
+// if it has one.
+type Key struct {
+	Key      string
+	Password string
+}
+
+// privateBinPaste is a paste as stored by PrivateBin. Only pastes of version 2
+// of its format are supported, which is used since PrivateBin 1.3.
+type privateBinPaste struct {
+	Version    int             `json:"v"`
+	AData      json.RawMessage `json:"adata"`
+	CipherText string          `json:"ct"`
+	Meta       struct {
+		Created    int64 `json:"created"`
+		PostDate   int64 `json:"postdate"`
+		ExpireDate int64 `json:"expire_date"`
+	} `json:"meta"`
+}
+
+// privateBinContent is the decrypted content of a PrivateBin paste. Newer
+// versions allow several attachments, older ones a single one.
+type privateBinContent struct {
+	Paste          string          `json:"paste"`
+	Attachment     json.RawMessage `json:"attachment"`
+	AttachmentName json.RawMessage `json:"attachment_name"`
+}
+
+// ReadKeys reads the keys of PrivateBin pastes from a file with one paste
+// link per line, optionally followed by a space and the password of the
+// paste. Empty lines and lines starting with # are ignored. The keys are
+// returned by the ids of their pastes.
+func ReadKeys(path string) (map[string]Key, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make(map[string]Key)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		link, password := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			link, password = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		i := strings.Index(link, "#")
+		if i < 0 {
+			return nil, fmt.Errorf("link '%s' has no key", link)
+		}
+
+		// Links are like https://example.com/?<id>#<key>, a - before the key
+		// asks for confirmation before burning the paste
+		id := link[strings.LastIndex(link[:i], "?")+1 : i]
+		keys[id] = Key{Key: strings.TrimPrefix(link[i+1:], "-"), Password: password}
+	}
+	return keys, scanner.Err()
+}
+
+func readPrivateBin(imp *importer, dir string, keys map[string]Key) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Comments are kept in a directory next to their paste
+		if info.IsDir() {
+			if strings.HasSuffix(info.Name(), ".discussion") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !privateBinFile.MatchString(info.Name()) {
+			return nil
+		}
+
+		id := strings.TrimSuffix(info.Name(), ".php")
+		key, ok := keys[id]
+		if !ok {
+			imp.skip(id, "its key is missing")
+			return nil
+		}
+
+		paste, err := readPrivateBinPaste(path, info.ModTime(), key)
+		if err != nil {
+			imp.skip(id, err.Error())
+			return nil
+		}
+		return imp.add(id, paste)
+	})
+}
+
+func readPrivateBinPaste(path string, modTime time.Time, key Key) (*model.Paste, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Since PrivateBin 1.3 the JSON is wrapped in a PHP comment, so the files
+	// can't be read if they're served
+	if bytes.HasPrefix(data, []byte("<?php")) {
+		start := bytes.Index(data, []byte("/*"))
+		end := bytes.LastIndex(data, []byte("*/"))
+		if start < 0 || end < start {
+			return nil, errors.New("its file can't be read")
+		}
+		data = data[start+2 : end]
+	}
+
+	var stored privateBinPaste
+	err = json.Unmarshal(data, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("its file can't be read: %s", err)
+	} else if stored.Version != 2 {
+		return nil, errors.New("only pastes of PrivateBin 1.3 and newer are supported")
+	}
+
+	// Numbers are kept as they are, they're part of the authenticated data
+	var adata []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(stored.AData))
+	decoder.UseNumber()
+	err = decoder.Decode(&adata)
+	if err != nil || len(adata) < 4 {
+		return nil, errors.New("its encryption parameters can't be read")
+	}
+
+	content, err := decryptPrivateBin(&stored, adata, key)
+	if err != nil {
+		return nil, err
+	}
+
+	paste := &model.Paste{
+		TimeCreated: modTime.UTC(),
+		Visibility:  config.VisibilityUnlisted,
+		RawContent:  content.Paste,
+	}
+
+	if stored.Meta.Created > 0 {
+		paste.TimeCreated = time.Unix(stored.Meta.Created, 0).UTC()
+	} else if stored.Meta.PostDate > 0 {
+		paste.TimeCreated = time.Unix(stored.Meta.PostDate, 0).UTC()
+	}
+
+	if stored.Meta.ExpireDate > 0 {
+		paste.TimeExpires = sql.NullTime{Time: time.Unix(stored.Meta.ExpireDate, 0).UTC(), Valid: true}
+	}
+
+	// PrivateBin detects the language itself when highlighting
+	switch adata[1] {
+	case "markdown":
+		paste.Language = language("markdown", "")
+	case "syntaxhighlighting":
+		paste.Language = language("", "")
+		if config.Get().Highlight.Enabled {
+			paste.Language = fmtutil.AnalyseLanguage(content.Paste)
+		}
+	default:
+		paste.Language = "plaintext"
+	}
+
+	if burn, ok := adata[3].(json.Number); ok && burn.String() == "1" {
+		paste.ViewsLeft = sql.NullInt64{Int64: 1, Valid: true}
+	}
+
+	paste.Attachments, err = privateBinAttachments(content)
+	if err != nil {
+		return nil, err
+	}
+	return paste, nil
+}
+
+// decryptPrivateBin decrypts a paste like PrivateBin does in the browser. The
+// key of the link and the password are stretched with PBKDF2 into a key for
+// AES-GCM, which authenticates the encryption parameters along with the
+// content.
+func decryptPrivateBin(stored *privateBinPaste, adata []interface{}, key Key) (*privateBinContent, error) {
+	spec, ok := adata[0].([]interface{})
+	if !ok || len(spec) < 8 || spec[5] != "aes" || spec[6] != "gcm" {
+		return nil, errors.New("its encryption algorithm isn't supported")
+	}
+
+	iv, ivErr := base64.StdEncoding.DecodeString(fmt.Sprint(spec[0]))
+	salt, saltErr := base64.StdEncoding.DecodeString(fmt.Sprint(spec[1]))
+	iterations, iterationsErr := jsonInt(spec[2])
+	keySize, keySizeErr := jsonInt(spec[3])
+	cipherText, cipherTextErr := base64.StdEncoding.DecodeString(stored.CipherText)
+	if ivErr != nil || saltErr != nil || iterationsErr != nil || keySizeErr != nil || cipherTextErr != nil || spec[4] != json.Number("128") {
+		return nil, errors.New("its encryption parameters can't be read")
+	}
+
+	keyBytes, err := base58Decode(key.Key)
+	if err != nil || len(keyBytes) > privateBinKeySize {
+		return nil, errors.New("its key is invalid")
+	}
+	keyBytes = append(make([]byte, privateBinKeySize-len(keyBytes)), keyBytes...)
+
+	// Pastes created by older versions used the hash of the password
+	compression := spec[7]
+	if key.Password != "" {
+		password := key.Password
+		if compression == "rawdeflate" {
+			sum := sha256.Sum256([]byte(password))
+			password = hex.EncodeToString(sum[:])
+		}
+		keyBytes = append(keyBytes, password...)
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(keyBytes, salt, int(iterations), int(keySize/8), sha256.New))
+	if err != nil {
+		return nil, errors.New("its key size isn't supported")
+	}
+
+	aead, err := cipher.NewGCMWithNonceSize(block, len(iv))
+	if err != nil {
+		return nil, err
+	}
+
+	additionalData, err := json.Marshal(adata)
+	if err != nil {
+		return nil, err
+	}
+
+	plainText, err := aead.Open(nil, iv, cipherText, additionalData)
+	if err != nil {
+		return nil, errors.New("it can't be decrypted, its key or password is wrong")
+	}
+
+	if compression == "zlib" || compression == "rawdeflate" {
+		plainText, err = inflate(plainText)
+		if err != nil {
+			return nil, fmt.Errorf("it can't be decompressed: %s", err)
+		}
+	}
+
+	content := new(privateBinContent)
+	err = json.Unmarshal(plainText, content)
+	if err != nil {
+		return nil, fmt.Errorf("its content can't be read: %s", err)
+	}
+	return content, nil
+}
+
+// inflate decompresses deflate data, which is usually raw but may have a zlib
+// header.
+func inflate(data []byte) ([]byte, error) {
+	inflated, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err == nil {
+		return inflated, nil
+	}
+
+	reader, zlibErr := zlib.NewReader(bytes.NewReader(data))
+	if zlibErr != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// privateBinAttachments returns the attachments of a PrivateBin paste, which
+// are data URLs.
+func privateBinAttachments(content *privateBinContent) ([]model.PasteAttachment, error) {
+	dataURLs, err := stringOrSlice(content.Attachment)
+	if err != nil {
+		return nil, errors.New("its attachments can't be read")
+	}
+
+	names, err := stringOrSlice(content.AttachmentName)
+	if err != nil {
+		return nil, errors.New("its attachments can't be read")
+	}
+
+	attachments := []model.PasteAttachment{}
+	for i, dataURL := range dataURLs {
+		contentType, data, err := parseDataURL(dataURL)
+		if err != nil {
+			return nil, fmt.Errorf("its attachments can't be read: %s", err)
+		}
+
+		name := fmt.Sprintf("attachment-%d", i+1)
+		if i < len(names) && names[i] != "" {
+			name = filepath.Base(names[i])
+		}
+		attachments = append(attachments, newAttachment(name, contentType, data))
+	}
+	return attachments, nil
+}
+
+// parseDataURL returns the content type and data of a data URL.
+func parseDataURL(dataURL string) (string, []byte, error) {
+	if !strings.HasPrefix(dataURL, "data:") {
+		return "", nil, errors.New("not a data URL")
+	}
+
+	i := strings.Index(dataURL, ",")
+	if i < 0 {
+		return "", nil, errors.New("data URL has no data")
+	}
+
+	params := strings.Split(dataURL[len("data:"):i], ";")
+	data := dataURL[i+1:]
+	if params[len(params)-1] == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		return params[0], decoded, err
+	}
+
+	decoded, err := url.PathUnescape(data)
+	return params[0], []byte(decoded), err
+}
+
+// jsonInt returns the integer decoded as a json.Number.
+func jsonInt(value interface{}) (int64, error) {
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, errors.New("not a number")
+	}
+	return number.Int64()
+}
+
+// stringOrSlice decodes a JSON string or array of strings. Missing values are
+// an empty slice.
+func stringOrSlice(data json.RawMessage) ([]string, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		return []string{value}, nil
+	}
+
+	var values []string
+	err := json.Unmarshal(data, &values)
+	return values, err
+}
+
+// base58Decode decodes a string encoded with the base58 alphabet of Bitcoin,
+// in which leading zero bytes are encoded as 1.
+func base58Decode(encoded string) ([]byte, error) {
+	value := new(big.Int)
+	base := big.NewInt(int64(len(base58Alphabet)))
+	for _, r := range encoded {
+		digit := strings.IndexRune(base58Alphabet, r)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character '%c'", r)
+		}
+		value.Mul(value, base)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	zeros := len(encoded) - len(strings.TrimLeft(encoded, "1"))
+	return append(make([]byte, zeros), value.Bytes()...), nil
+}
@@ -0,0 +1,142 @@
+package importer
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+)
+
+// stikkedLanguages maps the names of GeSHi languages used by Stikked that
+// chroma doesn't know to chroma languages.
+var stikkedLanguages = map[string]string{
+	"html4strict": "html",
+	"html5":       "html",
+	"text":        "plaintext",
+	"vbnet":       "vb.net",
+	"winbatch":    "batchfile",
+	"dos":         "batchfile",
+	"mysql":       "sql",
+	"plsql":       "sql",
+	"tsql":        "transact-sql",
+	"apache":      "apacheconf",
+	"email":       "plaintext",
+}
+
+// stikkedPaste is a row of the pastes table of Stikked. Exports of the table
+// usually have all values as strings, its API returns some as numbers.
+type stikkedPaste struct {
+	PID     string      `json:"pid"`
+	Title   string      `json:"title"`
+	Lang    string      `json:"lang"`
+	Raw     string      `json:"raw"`
+	Private flexibleInt `json:"private"`
+	Created flexibleInt `json:"created"`
+	Expire  flexibleInt `json:"expire"`
+}
+
+// stikkedTable is a table exported by phpMyAdmin, which exports a database as
+// a list of objects of which the tables have their rows as data.
+type stikkedTable struct {
+	Type string            `json:"type"`
+	Name string            `json:"name"`
+	Data []json.RawMessage `json:"data"`
+}
+
+// flexibleInt is an integer that is decoded from a number or a string.
+type flexibleInt int64
+
+// UnmarshalJSON decodes a number, a string containing a number or null.
+func (value *flexibleInt) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*value = 0
+		return nil
+	}
+
+	parsed, err := strconv.ParseInt(text, 10, 64)
+	*value = flexibleInt(parsed)
+	return err
+}
+
+// readStikked reads a JSON file with a list of rows of the pastes table of
+// Stikked, as returned by its API or exported by phpMyAdmin.
+func readStikked(imp *importer, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var items []json.RawMessage
+	err = json.Unmarshal(bytes.TrimSpace(data), &items)
+	if err != nil {
+		return fmt.Errorf("failed to read Stikked pastes, expected a JSON list: %s", err)
+	}
+
+	rows := []json.RawMessage{}
+	for _, item := range items {
+		var table stikkedTable
+		if json.Unmarshal(item, &table) == nil && table.Type == "table" {
+			if table.Name == "pastes" {
+				rows = append(rows, table.Data...)
+			}
+			continue
+		} else if table.Type != "" {
+			continue
+		}
+		rows = append(rows, item)
+	}
+
+	for i, row := range rows {
+		var stored stikkedPaste
+		err = json.Unmarshal(row, &stored)
+		if err != nil {
+			imp.skip(fmt.Sprintf("number %d", i+1), fmt.Sprintf("it can't be read: %s", err))
+			continue
+		} else if stored.PID == "" {
+			imp.skip(fmt.Sprintf("number %d", i+1), "its pid is missing")
+			continue
+		}
+
+		err = imp.add(stored.PID, newStikkedPaste(&stored))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newStikkedPaste(stored *stikkedPaste) *model.Paste {
+	lang := strings.ToLower(stored.Lang)
+	if mapped, ok := stikkedLanguages[lang]; ok {
+		lang = mapped
+	}
+
+	paste := &model.Paste{
+		TimeCreated: time.Now().UTC(),
+		Title:       stored.Title,
+		RawContent:  stored.Raw,
+		Language:    language(lang, ""),
+		Visibility:  listedVisibility(),
+	}
+
+	if stored.Created > 0 {
+		paste.TimeCreated = time.Unix(int64(stored.Created), 0).UTC()
+	}
+
+	if stored.Private != 0 {
+		paste.Visibility = config.VisibilityUnlisted
+	}
+
+	if stored.Expire > 0 {
+		paste.TimeExpires = sql.NullTime{Time: time.Unix(int64(stored.Expire), 0).UTC(), Valid: true}
+	}
+	return paste
+}
@@ -0,0 +1,255 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// lockID identifies the advisory lock taken while migrating Postgres.
+	lockID int64 = 4206914253
+
+	// lockName identifies the named lock taken while migrating MySQL.
+	lockName = "bingo_schema_migrations"
+
+	lockTimeout = 5 * time.Minute
+
+	errLockTimeout = errors.New("timed out waiting for the migration lock")
+)
+
+// Migration is a versioned change of the database schema.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      Script
+	Down    Script
+}
+
+// Script contains the SQL of a migration for each database driver. An empty
+// script leaves the database unchanged.
+type Script struct {
+	Postgres string
+	SQLite   string
+	MySQL    string
+}
+
+// State is a migration along with the time it was applied at, if it was.
+type State struct {
+	Migration
+	TimeApplied sql.NullTime
+}
+
+// forDriver returns the SQL of the script for the given database driver.
+func (script Script) forDriver(driver string) string {
+	switch driver {
+	case model.DriverSQLite:
+		return script.SQLite
+	case model.DriverMySQL:
+		return script.MySQL
+	default:
+		return script.Postgres
+	}
+}
+
+// Up applies all pending migrations in order of their version. Several
+// instances can run it at the same time, each migration is applied once.
+func Up(db *sqlx.DB) error {
+	return withLock(db, func() error {
+		err := createTable(db)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations {
+			err = apply(db, migration, true)
+			if err != nil {
+				return fmt.Errorf("migration %d %s failed: %s", migration.Version, migration.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Down reverts the latest applied migration.
+func Down(db *sqlx.DB) error {
+	return withLock(db, func() error {
+		states, err := Status(db)
+		if err != nil {
+			return err
+		}
+
+		for i := len(states) - 1; i >= 0; i-- {
+			if !states[i].TimeApplied.Valid {
+				continue
+			}
+
+			err = apply(db, states[i].Migration, false)
+			if err != nil {
+				return fmt.Errorf("reverting migration %d %s failed: %s", states[i].Version, states[i].Name, err)
+			}
+			return nil
+		}
+
+		log.Info("No migrations to revert")
+		return nil
+	})
+}
+
+// Status returns the state of all migrations in order of their version.
+func Status(db *sqlx.DB) ([]State, error) {
+	err := createTable(db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := []struct {
+		Version     int64        `db:"version"`
+		TimeApplied sql.NullTime `db:"time_applied"`
+	}{}
+	err = db.Select(&applied, "SELECT version, time_applied FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	timesApplied := make(map[int64]sql.NullTime)
+	for _, row := range applied {
+		timesApplied[row.Version] = row.TimeApplied
+	}
+
+	states := make([]State, len(migrations))
+	for i, migration := range migrations {
+		states[i] = State{Migration: migration, TimeApplied: timesApplied[migration.Version]}
+	}
+	return states, nil
+}
+
+// apply applies or reverts a migration in a transaction. Whether the migration
+// is applied is checked in the same transaction, so it's skipped if another
+// instance got to it first. MySQL commits schema changes implicitly, which is
+// why the migrations are run while holding a lock as well.
+func apply(db *sqlx.DB, migration Migration, up bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int
+	err = tx.Get(&count, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", migration.Version)
+	if err != nil || (count > 0) == up {
+		return err
+	}
+
+	script := migration.Down
+	if up {
+		log.Infof("Applying migration %d %s", migration.Version, migration.Name)
+		script = migration.Up
+	} else {
+		log.Infof("Reverting migration %d %s", migration.Version, migration.Name)
+	}
+
+	if query := script.forDriver(db.DriverName()); query != "" {
+		_, err = tx.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.Exec(
+			"INSERT INTO schema_migrations (version, name, time_applied) VALUES ($1, $2, $3)",
+			migration.Version,
+			migration.Name,
+			time.Now().UTC())
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = $1", migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// withLock runs fn while holding a lock that keeps other instances from
+// migrating the database at the same time. Postgres and MySQL hold the lock
+// on a dedicated connection. SQLite transactions take the write lock of the
+// database file right away, which already keeps migrations from overlapping.
+func withLock(db *sqlx.DB, fn func() error) error {
+	if model.IsSQLite(db) {
+		return fn()
+	}
+
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Debug("Waiting for the migration lock")
+	if model.IsMySQL(db) {
+		var locked sql.NullInt64
+		err = conn.GetContext(ctx, &locked, "SELECT GET_LOCK($1, $2)", lockName, int(lockTimeout.Seconds()))
+		if err == nil && locked.Int64 != 1 {
+			err = errLockTimeout
+		}
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT RELEASE_LOCK($1)", lockName)
+	} else {
+		lockCtx, cancel := context.WithTimeout(ctx, lockTimeout)
+		defer cancel()
+
+		_, err = conn.ExecContext(lockCtx, "SELECT pg_advisory_lock($1)", lockID)
+		if err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", lockID)
+	}
+
+	return fn()
+}
+
+// createTable creates the table keeping track of the applied migrations.
+func createTable(db *sqlx.DB) error {
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version			bigint PRIMARY KEY,
+			name			text NOT NULL,
+			time_applied	timestamptz NOT NULL
+		);
+	`
+
+	if model.IsSQLite(db) {
+		query = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version			bigint PRIMARY KEY,
+			name			text NOT NULL,
+			time_applied	timestamp NOT NULL
+		);
+	`
+	}
+
+	if model.IsMySQL(db) {
+		query = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version			bigint PRIMARY KEY,
+			name			varchar(255) NOT NULL,
+			time_applied	datetime(6) NOT NULL
+		) DEFAULT CHARSET = utf8mb4;
+	`
+	}
+
+	_, err := db.Exec(query)
+	return err
+}
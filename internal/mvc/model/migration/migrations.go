@@ -0,0 +1,609 @@
+package migration
+
+// migrations contains all migrations in order of their version. Migrations
+// are never changed once released, changes of the schema are added as new
+// migrations instead.
+//
+// The tables used to be created when starting the server, and the first
+// migrations create them the same way. They only create what's missing, so
+// databases created by older versions are upgraded without losing any data.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_pseudo_encrypt",
+		// https://stackoverflow.com/questions/12761346/pseudo-encrypt-function-in-plpgsql-that-takes-bigint/12761795#12761795
+		// Creates a function that maps big integers to another seemingly random big integer.
+		// Used to make sure that object ids for are seemingly random.
+		Up: Script{
+			Postgres: `
+			CREATE OR REPLACE FUNCTION pseudo_encrypt(VALUE bigint) returns bigint AS $$
+			DECLARE
+			l1 bigint;
+			l2 bigint;
+			r1 bigint;
+			r2 bigint;
+			i int:=0;
+			BEGIN
+				l1:= (VALUE >> 32) & 4294967295::bigint;
+				r1:= VALUE & 4294967295;
+				WHILE i < 3 LOOP
+					l2 := r1;
+					r2 := l1 # ((((1366.0 * r1 + 150889) % 714025) / 714025.0) * 32767*32767)::int;
+					l1 := l2;
+					r1 := r2;
+					i := i + 1;
+				END LOOP;
+			RETURN ((l1::bigint << 32) + r1);
+			END;
+			$$ LANGUAGE plpgsql strict immutable;
+			`,
+		},
+		Down: Script{
+			Postgres: "DROP FUNCTION IF EXISTS pseudo_encrypt(bigint);",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create_users",
+		Up: Script{
+			Postgres: `
+			CREATE SEQUENCE IF NOT EXISTS users_id_seq AS bigint;
+
+			CREATE TABLE IF NOT EXISTS users (
+				id				bigint PRIMARY KEY DEFAULT pseudo_encrypt(nextval('users_id_seq')),
+				time_created	timestamptz NOT NULL,
+				uid 			text NOT NULL,
+				name 			text NOT NULL,
+				email 			varchar(254),
+				password_hash	char(60),
+				auth_mode		int NOT NULL,
+				role 			int NOT NULL,
+				theme 			int NOT NULL
+			);
+
+			ALTER SEQUENCE users_id_seq OWNED BY users.id;
+			CREATE UNIQUE INDEX IF NOT EXISTS users_uid_lower_idx ON users(lower(uid));
+			`,
+			// SQLite has neither sequences nor pseudo_encrypt, random ids are used instead
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS users (
+				id				bigint PRIMARY KEY DEFAULT (random() & 9223372036854775807),
+				time_created	timestamp NOT NULL,
+				uid 			text NOT NULL,
+				name 			text NOT NULL,
+				email 			varchar(254),
+				password_hash	char(60),
+				auth_mode		int NOT NULL,
+				role 			int NOT NULL,
+				theme 			int NOT NULL
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS users_uid_lower_idx ON users(lower(uid));
+			`,
+			// MySQL compares uids case-insensitively by default, ids are generated by the store
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS users (
+				id				bigint PRIMARY KEY,
+				time_created	datetime(6) NOT NULL,
+				uid 			varchar(255) NOT NULL,
+				name 			text NOT NULL,
+				email 			varchar(254),
+				password_hash	char(60),
+				auth_mode		int NOT NULL,
+				role 			int NOT NULL,
+				theme 			int NOT NULL,
+				UNIQUE INDEX users_uid_lower_idx (uid)
+			) DEFAULT CHARSET = utf8mb4;
+			`,
+		},
+		Down: dropTable("users"),
+	},
+	{
+		Version: 3,
+		Name:    "create_api_tokens",
+		Up: Script{
+			Postgres: `
+			CREATE SEQUENCE IF NOT EXISTS api_tokens_id_seq AS bigint;
+
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id				bigint PRIMARY KEY DEFAULT pseudo_encrypt(nextval('api_tokens_id_seq')),
+				user_id			bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				time_created	timestamptz NOT NULL,
+				time_last_used	timestamptz,
+				name 			text NOT NULL,
+				prefix 			text NOT NULL,
+				token_hash		char(64) NOT NULL
+			);
+
+			ALTER SEQUENCE api_tokens_id_seq OWNED BY api_tokens.id;
+			CREATE UNIQUE INDEX IF NOT EXISTS api_tokens_token_hash_idx ON api_tokens(token_hash);
+			CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens(user_id);
+			`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id				bigint PRIMARY KEY DEFAULT (random() & 9223372036854775807),
+				user_id			bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				time_created	timestamp NOT NULL,
+				time_last_used	timestamp,
+				name 			text NOT NULL,
+				prefix 			text NOT NULL,
+				token_hash		char(64) NOT NULL
+			);
+
+			CREATE UNIQUE INDEX IF NOT EXISTS api_tokens_token_hash_idx ON api_tokens(token_hash);
+			CREATE INDEX IF NOT EXISTS api_tokens_user_id_idx ON api_tokens(user_id);
+			`,
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS api_tokens (
+				id				bigint PRIMARY KEY,
+				user_id			bigint NOT NULL,
+				time_created	datetime(6) NOT NULL,
+				time_last_used	datetime(6),
+				name 			text NOT NULL,
+				prefix 			text NOT NULL,
+				token_hash		char(64) NOT NULL,
+				UNIQUE INDEX api_tokens_token_hash_idx (token_hash),
+				INDEX api_tokens_user_id_idx (user_id),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			) DEFAULT CHARSET = utf8mb4;
+			`,
+		},
+		Down: dropTable("api_tokens"),
+	},
+	{
+		Version: 4,
+		Name:    "create_pastes",
+		Up: Script{
+			// Columns added after the table was first released are added to
+			// existing tables one by one
+			Postgres: `
+			CREATE SEQUENCE IF NOT EXISTS pastes_id_seq AS bigint;
+
+			CREATE TABLE IF NOT EXISTS pastes (
+				id bigint PRIMARY KEY DEFAULT pseudo_encrypt(nextval('pastes_id_seq')),
+				time_created timestamptz NOT NULL,
+				title text NOT NULL,
+				raw_content text NOT NULL,
+				formatted_content text NOT NULL,
+				language text NOT NULL,
+				time_expires timestamptz,
+				visibility int NOT NULL,
+				tsv TSVECTOR
+			);
+
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS user_id bigint REFERENCES users(id) ON DELETE SET NULL;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS edit_key_hash char(64);
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS revision int NOT NULL DEFAULT 1;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS time_updated timestamptz;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS views_left bigint;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS password_hash text;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS kind smallint NOT NULL DEFAULT 0;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS filename text NOT NULL DEFAULT '';
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS content_key text;
+			ALTER TABLE pastes ADD COLUMN IF NOT EXISTS parent_id bigint REFERENCES pastes(id) ON DELETE SET NULL;
+			CREATE INDEX IF NOT EXISTS pastes_parent_id_idx ON pastes (parent_id);
+
+			CREATE TABLE IF NOT EXISTS paste_revisions (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				revision int NOT NULL,
+				time_created timestamptz NOT NULL,
+				title text NOT NULL,
+				raw_content text NOT NULL,
+				language text NOT NULL,
+				PRIMARY KEY (paste_id, revision)
+			);
+
+			ALTER TABLE paste_revisions ADD COLUMN IF NOT EXISTS content_key text;
+
+			CREATE TABLE IF NOT EXISTS paste_files (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				position int NOT NULL,
+				filename text NOT NULL,
+				raw_content text NOT NULL,
+				formatted_content text NOT NULL,
+				language text NOT NULL,
+				PRIMARY KEY (paste_id, position)
+			);
+
+			ALTER TABLE paste_files ADD COLUMN IF NOT EXISTS content_key text;
+
+			CREATE TABLE IF NOT EXISTS paste_attachments (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				position int NOT NULL,
+				filename text NOT NULL,
+				content_type text NOT NULL,
+				size bigint NOT NULL,
+				blob_key text NOT NULL,
+				PRIMARY KEY (paste_id, position)
+			);
+
+			ALTER SEQUENCE pastes_id_seq OWNED BY pastes.id;
+			CREATE INDEX IF NOT EXISTS pastes_time_expires_id_idx ON pastes (time_expires, id);
+			CREATE INDEX IF NOT EXISTS pastes_tsv_idx ON pastes USING GIN(tsv);
+			`,
+			// Searching uses a full-text index table instead of the tsv column
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS pastes (
+				id bigint PRIMARY KEY DEFAULT (random() & 9223372036854775807),
+				time_created timestamp NOT NULL,
+				title text NOT NULL,
+				raw_content text NOT NULL,
+				formatted_content text NOT NULL,
+				language text NOT NULL,
+				time_expires timestamp,
+				visibility int NOT NULL,
+				user_id bigint REFERENCES users(id) ON DELETE SET NULL,
+				edit_key_hash char(64),
+				revision int NOT NULL DEFAULT 1,
+				time_updated timestamp,
+				views_left bigint,
+				password_hash text,
+				kind smallint NOT NULL DEFAULT 0,
+				filename text NOT NULL DEFAULT '',
+				content_key text,
+				parent_id bigint REFERENCES pastes(id) ON DELETE SET NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS pastes_parent_id_idx ON pastes (parent_id);
+			CREATE INDEX IF NOT EXISTS pastes_time_expires_id_idx ON pastes (time_expires, id);
+
+			CREATE TABLE IF NOT EXISTS paste_revisions (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				revision int NOT NULL,
+				time_created timestamp NOT NULL,
+				title text NOT NULL,
+				raw_content text NOT NULL,
+				language text NOT NULL,
+				content_key text,
+				PRIMARY KEY (paste_id, revision)
+			);
+
+			CREATE TABLE IF NOT EXISTS paste_files (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				position int NOT NULL,
+				filename text NOT NULL,
+				raw_content text NOT NULL,
+				formatted_content text NOT NULL,
+				language text NOT NULL,
+				content_key text,
+				PRIMARY KEY (paste_id, position)
+			);
+
+			CREATE TABLE IF NOT EXISTS paste_attachments (
+				paste_id bigint NOT NULL REFERENCES pastes(id) ON DELETE CASCADE,
+				position int NOT NULL,
+				filename text NOT NULL,
+				content_type text NOT NULL,
+				size bigint NOT NULL,
+				blob_key text NOT NULL,
+				PRIMARY KEY (paste_id, position)
+			);
+
+			CREATE VIRTUAL TABLE IF NOT EXISTS paste_search USING fts5(title, content, language, tokenize = 'porter unicode61');
+
+			CREATE TRIGGER IF NOT EXISTS pastes_search_delete AFTER DELETE ON pastes BEGIN
+				DELETE FROM paste_search WHERE rowid = OLD.id;
+			END;
+			`,
+			// Searching uses a full-text index on the search_text column
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS pastes (
+				id bigint PRIMARY KEY,
+				time_created datetime(6) NOT NULL,
+				title text NOT NULL,
+				raw_content longtext NOT NULL,
+				formatted_content longtext NOT NULL,
+				language varchar(255) NOT NULL,
+				time_expires datetime(6),
+				visibility int NOT NULL,
+				user_id bigint,
+				edit_key_hash char(64),
+				revision int NOT NULL DEFAULT 1,
+				time_updated datetime(6),
+				views_left bigint,
+				password_hash text,
+				kind smallint NOT NULL DEFAULT 0,
+				filename varchar(255) NOT NULL DEFAULT '',
+				content_key varchar(64),
+				parent_id bigint,
+				search_text longtext,
+				INDEX pastes_parent_id_idx (parent_id),
+				INDEX pastes_time_expires_id_idx (time_expires, id),
+				FULLTEXT INDEX pastes_search_text_idx (search_text),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+				FOREIGN KEY (parent_id) REFERENCES pastes(id) ON DELETE SET NULL
+			) DEFAULT CHARSET = utf8mb4;
+
+			CREATE TABLE IF NOT EXISTS paste_revisions (
+				paste_id bigint NOT NULL,
+				revision int NOT NULL,
+				time_created datetime(6) NOT NULL,
+				title text NOT NULL,
+				raw_content longtext NOT NULL,
+				language varchar(255) NOT NULL,
+				content_key varchar(64),
+				PRIMARY KEY (paste_id, revision),
+				FOREIGN KEY (paste_id) REFERENCES pastes(id) ON DELETE CASCADE
+			) DEFAULT CHARSET = utf8mb4;
+
+			CREATE TABLE IF NOT EXISTS paste_files (
+				paste_id bigint NOT NULL,
+				position int NOT NULL,
+				filename varchar(255) NOT NULL,
+				raw_content longtext NOT NULL,
+				formatted_content longtext NOT NULL,
+				language varchar(255) NOT NULL,
+				content_key varchar(64),
+				PRIMARY KEY (paste_id, position),
+				FOREIGN KEY (paste_id) REFERENCES pastes(id) ON DELETE CASCADE
+			) DEFAULT CHARSET = utf8mb4;
+
+			CREATE TABLE IF NOT EXISTS paste_attachments (
+				paste_id bigint NOT NULL,
+				position int NOT NULL,
+				filename varchar(255) NOT NULL,
+				content_type varchar(255) NOT NULL,
+				size bigint NOT NULL,
+				blob_key varchar(64) NOT NULL,
+				PRIMARY KEY (paste_id, position),
+				FOREIGN KEY (paste_id) REFERENCES pastes(id) ON DELETE CASCADE
+			) DEFAULT CHARSET = utf8mb4;
+			`,
+		},
+		Down: Script{
+			Postgres: "DROP TABLE IF EXISTS paste_attachments, paste_files, paste_revisions, pastes;",
+			SQLite: `
+			DROP TABLE IF EXISTS paste_search;
+			DROP TABLE IF EXISTS paste_attachments;
+			DROP TABLE IF EXISTS paste_files;
+			DROP TABLE IF EXISTS paste_revisions;
+			DROP TABLE IF EXISTS pastes;
+			`,
+			MySQL: "DROP TABLE IF EXISTS paste_attachments, paste_files, paste_revisions, pastes;",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "create_blobs",
+		Up: Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS blobs (
+				key		text PRIMARY KEY,
+				data	bytea NOT NULL
+			);
+			`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS blobs (
+				key		text PRIMARY KEY,
+				data	blob NOT NULL
+			);
+			`,
+			// Key is a reserved word in MySQL, which is why it's quoted in all queries
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS blobs (
+				"key"	varchar(64) PRIMARY KEY,
+				data	longblob NOT NULL
+			);
+			`,
+		},
+		Down: dropTable("blobs"),
+	},
+	{
+		Version: 6,
+		Name:    "create_sessions",
+		// The sessions tables are used by the session stores of scs
+		Up: Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				token TEXT PRIMARY KEY,
+				data BYTEA NOT NULL,
+				expiry TIMESTAMPTZ NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
+			`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				token TEXT PRIMARY KEY,
+				data BLOB NOT NULL,
+				expiry REAL NOT NULL
+			);
+			CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
+			`,
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS sessions (
+				token CHAR(43) PRIMARY KEY,
+				data BLOB NOT NULL,
+				expiry TIMESTAMP(6) NOT NULL,
+				INDEX sessions_expiry_idx (expiry)
+			);
+			`,
+		},
+		Down: dropTable("sessions"),
+	},
+	{
+		Version: 7,
+		Name:    "create_pseudo_decrypt",
+		// Creates the inverse of pseudo_encrypt, which maps an id back to the
+		// value of the sequence it was derived from. Used to continue the
+		// sequences after importing rows with their ids from a backup.
+		Up: Script{
+			Postgres: `
+			CREATE OR REPLACE FUNCTION pseudo_decrypt(VALUE bigint) returns bigint AS $$
+			DECLARE
+			l1 bigint;
+			l2 bigint;
+			r1 bigint;
+			r2 bigint;
+			i int:=0;
+			BEGIN
+				l2:= (VALUE >> 32) & 4294967295::bigint;
+				r2:= VALUE & 4294967295;
+				WHILE i < 3 LOOP
+					r1 := l2;
+					l1 := r2 # ((((1366.0 * l2 + 150889) % 714025) / 714025.0) * 32767*32767)::int;
+					l2 := l1;
+					r2 := r1;
+					i := i + 1;
+				END LOOP;
+			RETURN ((l2::bigint << 32) + r2);
+			END;
+			$$ LANGUAGE plpgsql strict immutable;
+			`,
+		},
+		Down: Script{
+			Postgres: "DROP FUNCTION IF EXISTS pseudo_decrypt(bigint);",
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_totp",
+		// totp_step is the time step of the last code used, which can't be
+		// used again
+		Up: Script{
+			Postgres: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_step bigint;
+
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				user_id		bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				code_hash	char(64) NOT NULL,
+				PRIMARY KEY (user_id, code_hash)
+			);
+			`,
+			SQLite: `
+			ALTER TABLE users ADD COLUMN totp_secret text;
+			ALTER TABLE users ADD COLUMN totp_step bigint;
+
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				user_id		bigint NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				code_hash	char(64) NOT NULL,
+				PRIMARY KEY (user_id, code_hash)
+			);
+			`,
+			MySQL: `
+			ALTER TABLE users ADD COLUMN totp_secret varchar(64), ADD COLUMN totp_step bigint;
+
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				user_id		bigint NOT NULL,
+				code_hash	char(64) NOT NULL,
+				PRIMARY KEY (user_id, code_hash),
+				FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+			) DEFAULT CHARSET = utf8mb4;
+			`,
+		},
+		Down: Script{
+			Postgres: `
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
+			ALTER TABLE users DROP COLUMN IF EXISTS totp_step;
+			`,
+			SQLite: `
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE users DROP COLUMN totp_secret;
+			ALTER TABLE users DROP COLUMN totp_step;
+			`,
+			MySQL: `
+			DROP TABLE IF EXISTS recovery_codes;
+			ALTER TABLE users DROP COLUMN totp_secret, DROP COLUMN totp_step;
+			`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_email_verified",
+		// Existing users are treated as verified
+		Up: Script{
+			Postgres: "ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified boolean NOT NULL DEFAULT true;",
+			SQLite:   "ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT true;",
+			MySQL:    "ALTER TABLE users ADD COLUMN email_verified boolean NOT NULL DEFAULT true;",
+		},
+		Down: Script{
+			Postgres: "ALTER TABLE users DROP COLUMN IF EXISTS email_verified;",
+			SQLite:   "ALTER TABLE users DROP COLUMN email_verified;",
+			MySQL:    "ALTER TABLE users DROP COLUMN email_verified;",
+		},
+	},
+	{
+		Version: 10,
+		Name:    "create_audit_log",
+		// Entries are never shown outside of the users page, so their ids
+		// don't have to hide how many there are
+		Up: Script{
+			Postgres: `
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id				bigserial PRIMARY KEY,
+				time_created	timestamptz NOT NULL,
+				event			text NOT NULL,
+				uid				text NOT NULL,
+				address			text NOT NULL,
+				detail			text NOT NULL
+			);
+			`,
+			SQLite: `
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id				integer PRIMARY KEY AUTOINCREMENT,
+				time_created	timestamp NOT NULL,
+				event			text NOT NULL,
+				uid				text NOT NULL,
+				address			text NOT NULL,
+				detail			text NOT NULL
+			);
+			`,
+			MySQL: `
+			CREATE TABLE IF NOT EXISTS audit_log (
+				id				bigint PRIMARY KEY AUTO_INCREMENT,
+				time_created	datetime(6) NOT NULL,
+				event			varchar(64) NOT NULL,
+				uid				text NOT NULL,
+				address			text NOT NULL,
+				detail			text NOT NULL
+			) DEFAULT CHARSET = utf8mb4;
+			`,
+		},
+		Down: dropTable("audit_log"),
+	},
+	{
+		Version: 11,
+		Name:    "add_oidc_subject",
+		// Users of OpenID Connect are identified by the issuer and subject of
+		// their ID tokens, which never change unlike the other claims. MySQL
+		// compares them case-sensitively like the other databases.
+		Up: Script{
+			Postgres: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_issuer text;
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS oidc_subject text;
+			CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject_idx ON users(oidc_issuer, oidc_subject);
+			`,
+			SQLite: `
+			ALTER TABLE users ADD COLUMN oidc_issuer text;
+			ALTER TABLE users ADD COLUMN oidc_subject text;
+			CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject_idx ON users(oidc_issuer, oidc_subject);
+			`,
+			MySQL: `
+			ALTER TABLE users
+				ADD COLUMN oidc_issuer varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin,
+				ADD COLUMN oidc_subject varchar(255) CHARACTER SET utf8mb4 COLLATE utf8mb4_bin,
+				ADD UNIQUE INDEX users_oidc_subject_idx (oidc_issuer, oidc_subject);
+			`,
+		},
+		Down: Script{
+			Postgres: `
+			DROP INDEX IF EXISTS users_oidc_subject_idx;
+			ALTER TABLE users DROP COLUMN IF EXISTS oidc_issuer;
+			ALTER TABLE users DROP COLUMN IF EXISTS oidc_subject;
+			`,
+			SQLite: `
+			DROP INDEX IF EXISTS users_oidc_subject_idx;
+			ALTER TABLE users DROP COLUMN oidc_issuer;
+			ALTER TABLE users DROP COLUMN oidc_subject;
+			`,
+			MySQL: "ALTER TABLE users DROP INDEX users_oidc_subject_idx, DROP COLUMN oidc_issuer, DROP COLUMN oidc_subject;",
+		},
+	},
+}
+
+// dropTable returns a script dropping the given table with all databases.
+func dropTable(table string) Script {
+	query := "DROP TABLE IF EXISTS " + table + ";"
+	return Script{Postgres: query, SQLite: query, MySQL: query}
+}
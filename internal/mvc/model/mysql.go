@@ -0,0 +1,283 @@
+package model
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	errPlaceholder = errors.New("placeholder out of range")
+)
+
+// openMySQL opens a MySQL database. MySQL only supports ? placeholders, which
+// are bound in the order they appear. The connections rewrite the numbered
+// placeholders of the stores and reorder the arguments to match, so the same
+// queries work for all databases.
+func openMySQL(connStr string) (*sqlx.DB, error) {
+	cfg, err := mysql.ParseDSN(connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlx.NewDb(sql.OpenDB(&mysqlConnector{connector}), DriverMySQL), nil
+}
+
+// bindPlaceholders replaces the numbered placeholders of the query with ?. The
+// returned order contains the index of the argument for each placeholder.
+// Strings, quoted identifiers and comments are copied as they are, so a $1 in
+// them isn't mistaken for a placeholder.
+func bindPlaceholders(query string) (string, []int, error) {
+	var builder strings.Builder
+	order := []int{}
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := skipQuoted(query, i)
+			builder.WriteString(query[i:end])
+			i = end
+		case c == '#' || isLineComment(query[i:]):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			builder.WriteString(query[i : i+end])
+			i += end
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				end = len(query) - i
+			} else {
+				end += 4
+			}
+			builder.WriteString(query[i : i+end])
+			i += end
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]) && (i == 0 || !isIdentifierByte(query[i-1])):
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			n, err := strconv.Atoi(query[i+1 : end])
+			if err != nil || n < 1 {
+				return "", nil, errPlaceholder
+			}
+			order = append(order, n-1)
+			builder.WriteByte('?')
+			i = end
+		default:
+			builder.WriteByte(c)
+			i++
+		}
+	}
+	return builder.String(), order, nil
+}
+
+// skipQuoted returns the index after the string or quoted identifier starting
+// at the given index. Quotes are escaped by doubling them, and in strings by a
+// backslash too.
+func skipQuoted(query string, start int) int {
+	quote := query[start]
+	for i := start + 1; i < len(query); i++ {
+		switch {
+		case query[i] == '\\' && quote != '`':
+			i++
+		case query[i] == quote && i+1 < len(query) && query[i+1] == quote:
+			i++
+		case query[i] == quote:
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// isLineComment returns whether the query starts with a -- comment, which
+// MySQL requires to be followed by whitespace.
+func isLineComment(query string) bool {
+	return strings.HasPrefix(query, "--") && (len(query) == 2 || query[2] <= ' ')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isIdentifierByte returns whether the byte can be part of an unquoted
+// identifier, which can contain $ too.
+func isIdentifierByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c) || c == '_' || c == '$' || c >= 0x80
+}
+
+// orderArgs returns the arguments in the order of the placeholders they're
+// bound to. Queries without numbered placeholders keep their arguments.
+func orderArgs(args []driver.NamedValue, order []int) ([]driver.NamedValue, error) {
+	if len(order) == 0 {
+		return args, nil
+	}
+
+	ordered := make([]driver.NamedValue, len(order))
+	for i, index := range order {
+		if index >= len(args) {
+			return nil, errPlaceholder
+		}
+		ordered[i] = args[index]
+		ordered[i].Ordinal = i + 1
+	}
+	return ordered, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
+
+type mysqlConnector struct {
+	connector driver.Connector
+}
+
+func (c *mysqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlConn{conn}, nil
+}
+
+func (c *mysqlConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+// mysqlConn wraps a connection of the MySQL driver, which implements all of
+// the optional interfaces used here.
+type mysqlConn struct {
+	conn driver.Conn
+}
+
+func (c *mysqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *mysqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	bound, order, err := bindPlaceholders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := c.conn.(driver.ConnPrepareContext).PrepareContext(ctx, bound)
+	if err != nil {
+		return nil, err
+	}
+	return &mysqlStmt{stmt, order}, nil
+}
+
+func (c *mysqlConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *mysqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *mysqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *mysqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	bound, order, err := bindPlaceholders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err = orderArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return c.conn.(driver.ExecerContext).ExecContext(ctx, bound, args)
+}
+
+func (c *mysqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	bound, order, err := bindPlaceholders(query)
+	if err != nil {
+		return nil, err
+	}
+
+	args, err = orderArgs(args, order)
+	if err != nil {
+		return nil, err
+	}
+	return c.conn.(driver.QueryerContext).QueryContext(ctx, bound, args)
+}
+
+func (c *mysqlConn) Ping(ctx context.Context) error {
+	return c.conn.(driver.Pinger).Ping(ctx)
+}
+
+func (c *mysqlConn) ResetSession(ctx context.Context) error {
+	return c.conn.(driver.SessionResetter).ResetSession(ctx)
+}
+
+func (c *mysqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+func (c *mysqlConn) IsValid() bool {
+	return c.conn.(driver.Validator).IsValid()
+}
+
+// mysqlStmt wraps a prepared statement of the MySQL driver and reorders the
+// arguments to match its placeholders.
+type mysqlStmt struct {
+	stmt  driver.Stmt
+	order []int
+}
+
+func (s *mysqlStmt) Close() error {
+	return s.stmt.Close()
+}
+
+// NumInput returns -1, as placeholders can be used more than once and the
+// number of arguments can't be checked before they're reordered.
+func (s *mysqlStmt) NumInput() int {
+	return -1
+}
+
+func (s *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *mysqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *mysqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	args, err := orderArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	return s.stmt.(driver.StmtExecContext).ExecContext(ctx, args)
+}
+
+func (s *mysqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	args, err := orderArgs(args, s.order)
+	if err != nil {
+		return nil, err
+	}
+	return s.stmt.(driver.StmtQueryContext).QueryContext(ctx, args)
+}
+
+func (s *mysqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.stmt.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
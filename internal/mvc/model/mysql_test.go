@@ -0,0 +1,85 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBindPlaceholders(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		bound string
+		order []int
+	}{
+		{
+			"placeholders",
+			"SELECT * FROM pastes WHERE id = $2 AND user_id = $1 OR parent_id = $2",
+			"SELECT * FROM pastes WHERE id = ? AND user_id = ? OR parent_id = ?",
+			[]int{1, 0, 1},
+		},
+		{
+			"without placeholders",
+			"SELECT COUNT(*) FROM pastes",
+			"SELECT COUNT(*) FROM pastes",
+			[]int{},
+		},
+		{
+			"adjacent",
+			"VALUES ($1,$2,$10)",
+			"VALUES (?,?,?)",
+			[]int{0, 1, 9},
+		},
+		{
+			"strings",
+			`SELECT '$1', "$2", 'it''s $3', 'a\'$4', "b\"$5" FROM pastes WHERE id = $1`,
+			`SELECT '$1', "$2", 'it''s $3', 'a\'$4', "b\"$5" FROM pastes WHERE id = ?`,
+			[]int{0},
+		},
+		{
+			"quoted identifiers",
+			"SELECT `$1`, `a``$2`, `a\\` FROM pastes WHERE id = $1",
+			"SELECT `$1`, `a``$2`, `a\\` FROM pastes WHERE id = ?",
+			[]int{0},
+		},
+		{
+			"unquoted identifiers",
+			"SELECT price$1, $1 FROM pastes",
+			"SELECT price$1, ? FROM pastes",
+			[]int{0},
+		},
+		{
+			"comments",
+			"SELECT $1 -- $2\n# $3\n/* $4 */ FROM pastes WHERE id = $2 --$5",
+			"SELECT ? -- $2\n# $3\n/* $4 */ FROM pastes WHERE id = ? --?",
+			[]int{0, 1, 4},
+		},
+		{
+			"unterminated",
+			"SELECT $1, '$2",
+			"SELECT ?, '$2",
+			[]int{0},
+		},
+	}
+
+	for _, test := range tests {
+		bound, order, err := bindPlaceholders(test.query)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if bound != test.bound {
+			t.Errorf("%s: expected %s, got %s", test.name, test.bound, bound)
+		}
+		if !reflect.DeepEqual(order, test.order) {
+			t.Errorf("%s: expected order %v, got %v", test.name, test.order, order)
+		}
+	}
+}
+
+func TestBindPlaceholdersOutOfRange(t *testing.T) {
+	_, _, err := bindPlaceholders("SELECT * FROM pastes WHERE id = $0")
+	if err != errPlaceholder {
+		t.Errorf("expected %v, got %v", errPlaceholder, err)
+	}
+}
@@ -7,6 +7,18 @@ import (
 	"bingo/internal/config"
 )
 
+const (
+	// PasteKindText represents a paste stored as plain text.
+	PasteKindText PasteKind = iota
+
+	// PasteKindEncrypted represents a paste encrypted in the browser. Only the
+	// ciphertext is stored, the key never reaches the server.
+	PasteKindEncrypted
+)
+
+// PasteKind determines how the content of a paste is stored and displayed.
+type PasteKind int
+
 // Paste represents the paste contents and surrounding metadata.
 type Paste struct {
 	ID               int64             `db:"id"`
@@ -17,13 +29,144 @@ type Paste struct {
 	Language         string            `db:"language"`
 	TimeExpires      sql.NullTime      `db:"time_expires"`
 	Visibility       config.Visibility `db:"visibility"`
+	UserID           sql.NullInt64     `db:"user_id"`
+	EditKeyHash      sql.NullString    `db:"edit_key_hash"`
+	Revision         int               `db:"revision"`
+	TimeUpdated      sql.NullTime      `db:"time_updated"`
+	ParentID         sql.NullInt64     `db:"parent_id"`
+	ViewsLeft        sql.NullInt64     `db:"views_left"`
+	PasswordHash     sql.NullString    `db:"password_hash"`
+	Kind             PasteKind         `db:"kind"`
+	Filename         string            `db:"filename"`
+	ContentKey       sql.NullString    `db:"content_key"`
+	Files            []PasteFile       `db:"-"`
+	Attachments      []PasteAttachment `db:"-"`
+}
+
+// PasteFile represents an additional named file of a paste. The first file of
+// a paste is stored in the paste itself.
+type PasteFile struct {
+	PasteID          int64          `db:"paste_id"`
+	Position         int            `db:"position"`
+	Filename         string         `db:"filename"`
+	RawContent       string         `db:"raw_content"`
+	FormattedContent string         `db:"formatted_content"`
+	Language         string         `db:"language"`
+	ContentKey       sql.NullString `db:"content_key"`
+}
+
+// PasteAttachment represents a binary file attached to a paste. The content is
+// kept in a blob store under the blob key.
+type PasteAttachment struct {
+	PasteID     int64  `db:"paste_id"`
+	Position    int    `db:"position"`
+	Filename    string `db:"filename"`
+	ContentType string `db:"content_type"`
+	Size        int64  `db:"size"`
+	BlobKey     string `db:"blob_key"`
+	Content     []byte `db:"-"`
+	Hexdump     string `db:"-"`
+}
+
+// PasteRevision represents the content of a paste at some point of time.
+type PasteRevision struct {
+	PasteID     int64          `db:"paste_id"`
+	Revision    int            `db:"revision"`
+	TimeCreated time.Time      `db:"time_created"`
+	Title       string         `db:"title"`
+	RawContent  string         `db:"raw_content"`
+	Language    string         `db:"language"`
+	ContentKey  sql.NullString `db:"content_key"`
 }
 
 // PasteTemplate represents paste changes to be committed to the database.
 type PasteTemplate struct {
-	Title      string
-	RawContent string
-	Visibility config.Visibility
-	Language   string
-	Duration   time.Duration
+	Title       string
+	RawContent  string
+	Visibility  config.Visibility
+	Language    string
+	Duration    time.Duration
+	UserID      sql.NullInt64
+	EditKey     string
+	ParentID    sql.NullInt64
+	Views       int64
+	Password    string
+	Kind        PasteKind
+	Filename    string
+	Files       []PasteFile
+	Attachments []PasteAttachment
+}
+
+// AllFiles returns the first file of the paste followed by its additional files.
+func (paste *Paste) AllFiles() []PasteFile {
+	first := PasteFile{
+		PasteID:          paste.ID,
+		Filename:         paste.Filename,
+		RawContent:       paste.RawContent,
+		FormattedContent: paste.FormattedContent,
+		Language:         paste.Language,
+	}
+	return append([]PasteFile{first}, paste.Files...)
+}
+
+// FindFile returns the file with the given name, or nil if there's no such file.
+func (paste *Paste) FindFile(filename string) *PasteFile {
+	files := paste.AllFiles()
+	for i := range files {
+		if files[i].Filename == filename {
+			return &files[i]
+		}
+	}
+	return nil
+}
+
+// FindAttachment returns the attachment at the given position, or nil if
+// there's no such attachment.
+func (paste *Paste) FindAttachment(position int) *PasteAttachment {
+	for i := range paste.Attachments {
+		if paste.Attachments[i].Position == position {
+			return &paste.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// IsEncrypted returns true if the content of the paste is encrypted.
+func (paste *Paste) IsEncrypted() bool {
+	return paste.Kind == PasteKindEncrypted
+}
+
+// IsImage returns true if the attachment is an image that browsers can display.
+func (attachment *PasteAttachment) IsImage() bool {
+	switch attachment.ContentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp", "image/bmp":
+		return true
+	default:
+		return false
+	}
+}
+
+// AllFiles returns the first file of the template followed by its additional files.
+func (pasteTmpl *PasteTemplate) AllFiles() []PasteFile {
+	first := PasteFile{
+		Filename:   pasteTmpl.Filename,
+		RawContent: pasteTmpl.RawContent,
+		Language:   pasteTmpl.Language,
+	}
+	return append([]PasteFile{first}, pasteTmpl.Files...)
+}
+
+// SetFiles sets the first file of the template and its additional files.
+func (pasteTmpl *PasteTemplate) SetFiles(files []PasteFile) {
+	pasteTmpl.Filename = ""
+	pasteTmpl.RawContent = ""
+	pasteTmpl.Language = ""
+	pasteTmpl.Files = nil
+
+	if len(files) > 0 {
+		pasteTmpl.Filename = files[0].Filename
+		pasteTmpl.RawContent = files[0].RawContent
+		pasteTmpl.Language = files[0].Language
+		pasteTmpl.Files = files[1:]
+	}
 }
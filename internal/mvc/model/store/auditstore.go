@@ -0,0 +1,57 @@
+package store
+
+import (
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// AuditStore is the store for the audit log.
+type AuditStore struct {
+	Database *sqlx.DB
+}
+
+// NewAuditStore creates a new AuditStore.
+func NewAuditStore(db *sqlx.DB) *AuditStore {
+	log.Debug("Initializing audit store")
+	store := new(AuditStore)
+	store.Database = db
+	return store
+}
+
+// FindRange returns a range of entries sorted from newest to oldest.
+func (store *AuditStore) FindRange(limit int64, offset int64) ([]model.AuditEntry, error) {
+	log.Debugf("Retrieving %d audit log entries starting from entry number %d from database", limit, offset)
+
+	query := `
+		SELECT id, time_created, event, uid, address, detail
+		FROM audit_log
+		ORDER BY time_created DESC, id DESC
+		LIMIT $1 OFFSET $2
+		`
+
+	entries := []model.AuditEntry{}
+	err := store.Database.Select(&entries, query, limit, offset)
+	return entries, err
+}
+
+// Insert adds an entry to the audit log. The time of the entry is set when
+// it's empty.
+func (store *AuditStore) Insert(entry *model.AuditEntry) error {
+	log.Debugf("Inserting audit log entry '%s' to database", entry.Event)
+
+	query := `
+		INSERT INTO audit_log (time_created, event, uid, address, detail)
+		VALUES ($1, $2, $3, $4, $5)
+		`
+
+	if entry.TimeCreated.IsZero() {
+		entry.TimeCreated = time.Now().UTC()
+	}
+
+	_, err := store.Database.Exec(query, entry.TimeCreated, entry.Event, entry.UID, entry.Address, entry.Detail)
+	return err
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// blobKeyPattern matches the keys generated for blobs, which makes them
+	// safe to use as filenames.
+	blobKeyPattern = regexp.MustCompile(`^[0-9a-f]+$`)
+
+	errInvalidBlobKey = errors.New("invalid blob key")
+)
+
+// BlobStore stores binary content under a key.
+type BlobStore interface {
+	Get(key string) ([]byte, error)
+	Put(key string, data []byte) error
+	Delete(key string) error
+}
+
+// NewBlobStore creates the blob store configured for the content of pastes
+// and their attachments.
+func NewBlobStore(db *sqlx.DB) BlobStore {
+	switch backend := config.Get().Storage.Backend; backend {
+	case "database":
+		return NewDatabaseBlobStore(db)
+	case "filesystem":
+		return NewFileBlobStore(config.Get().Storage.Directory)
+	case "s3":
+		return NewS3BlobStore()
+	default:
+		log.Fatalf("Invalid storage backend '%s'", backend)
+		return nil
+	}
+}
+
+// DatabaseBlobStore is a blob store keeping the content in the database.
+type DatabaseBlobStore struct {
+	Database *sqlx.DB
+}
+
+// NewDatabaseBlobStore creates a new DatabaseBlobStore.
+func NewDatabaseBlobStore(db *sqlx.DB) *DatabaseBlobStore {
+	log.Debug("Initializing database blob store")
+
+	store := new(DatabaseBlobStore)
+	store.Database = db
+	return store
+}
+
+// Get returns the content stored under the given key.
+func (store *DatabaseBlobStore) Get(key string) ([]byte, error) {
+	log.Debugf("Retrieving blob %s from database", key)
+
+	var data []byte
+	err := store.Database.Get(&data, `SELECT data FROM blobs WHERE "key" = $1`, key)
+	return data, err
+}
+
+// Put stores the content under the given key.
+func (store *DatabaseBlobStore) Put(key string, data []byte) error {
+	log.Debugf("Inserting blob %s of %d bytes into database", key, len(data))
+
+	query := `
+		INSERT INTO blobs ("key", data)
+		VALUES ($1, $2)
+		ON CONFLICT ("key") DO UPDATE SET data = EXCLUDED.data
+		`
+
+	if model.IsMySQL(store.Database) {
+		query = `
+		INSERT INTO blobs ("key", data)
+		VALUES ($1, $2)
+		ON DUPLICATE KEY UPDATE data = VALUES(data)
+		`
+	}
+
+	_, err := store.Database.Exec(query, key, data)
+	return err
+}
+
+// Delete deletes the content stored under the given key.
+func (store *DatabaseBlobStore) Delete(key string) error {
+	log.Debugf("Deleting blob %s from database", key)
+
+	_, err := store.Database.Exec(`DELETE FROM blobs WHERE "key" = $1`, key)
+	return err
+}
+
+// FileBlobStore is a blob store keeping the content as files in a directory.
+type FileBlobStore struct {
+	Directory string
+}
+
+// NewFileBlobStore creates a new FileBlobStore.
+func NewFileBlobStore(directory string) *FileBlobStore {
+	log.Debugf("Initializing filesystem blob store in '%s'", directory)
+
+	err := os.MkdirAll(directory, 0700)
+	if err != nil {
+		log.Fatalf("Failed to create directory '%s': %s", directory, err)
+	}
+
+	store := new(FileBlobStore)
+	store.Directory = directory
+	return store
+}
+
+// Get returns the content stored under the given key.
+func (store *FileBlobStore) Get(key string) ([]byte, error) {
+	path, err := store.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// Put stores the content under the given key. The content is written to a
+// temporary file first, so readers never see a partially written blob.
+func (store *FileBlobStore) Put(key string, data []byte) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(store.Directory, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
+
+// Delete deletes the content stored under the given key.
+func (store *FileBlobStore) Delete(key string) error {
+	path, err := store.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (store *FileBlobStore) path(key string) (string, error) {
+	if !blobKeyPattern.MatchString(key) {
+		return "", errInvalidBlobKey
+	}
+	return filepath.Join(store.Directory, key), nil
+}
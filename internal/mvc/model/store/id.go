@@ -0,0 +1,90 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"bingo/internal/mvc/model"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// newID returns a random positive id for a new row. Postgres derives ids from
+// a sequence with pseudo_encrypt and SQLite generates random ids itself, but
+// MySQL can't return the id of an inserted row unless it's an AUTO_INCREMENT
+// column, so the id is generated beforehand.
+func newID() (int64, error) {
+	bytes := make([]byte, 8)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return 0, err
+	}
+
+	id := int64(binary.BigEndian.Uint64(bytes) >> 1)
+	if id == 0 {
+		return newID()
+	}
+	return id, nil
+}
+
+// insertReturning inserts a row with a new id and scans it into dest. It
+// replaces INSERT ... RETURNING on MySQL: the id is passed as an additional
+// last argument to the insert query and the row is read with selectQuery.
+func insertReturning(db sqlx.Ext, dest interface{}, query string, selectQuery string, args ...interface{}) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(query, append(args, id)...)
+	if err != nil {
+		return err
+	}
+
+	return sqlx.Get(db, dest, selectQuery, id)
+}
+
+// updateReturning updates the row with the given id and scans it into dest.
+// It replaces UPDATE ... RETURNING on MySQL: the id is the first argument of
+// the update query and the row is read with selectQuery.
+func updateReturning(db sqlx.Ext, dest interface{}, query string, selectQuery string, id int64, args ...interface{}) error {
+	_, err := db.Exec(query, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	return sqlx.Get(db, dest, selectQuery, id)
+}
+
+// rowExists returns whether the table has a row with the given id.
+func rowExists(db sqlx.Queryer, table string, id int64) (bool, error) {
+	var count int64
+	err := sqlx.Get(db, &count, "SELECT COUNT(*) FROM "+table+" WHERE id = $1", id)
+	return count > 0, err
+}
+
+// SyncSequences advances the id sequences of Postgres past the rows restored
+// with their ids, so new rows don't get the id of a restored one. Only ids
+// derived from a sequence are taken into account, random ids of other
+// databases map to values the sequences practically never reach.
+func SyncSequences(db *sqlx.DB) error {
+	if model.IsSQLite(db) || model.IsMySQL(db) {
+		return nil
+	}
+
+	query := `
+		SELECT setval('%[1]s_id_seq', MAX(seq))
+		FROM (SELECT pseudo_decrypt(id) AS seq FROM %[1]s) ids
+		WHERE seq BETWEEN (SELECT last_value FROM %[1]s_id_seq) AND 4294967295
+		HAVING COUNT(*) > 0
+		`
+
+	for _, table := range []string{"users", "api_tokens", "pastes"} {
+		_, err := db.Exec(fmt.Sprintf(query, table))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
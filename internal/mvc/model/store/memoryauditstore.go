@@ -0,0 +1,55 @@
+package store
+
+import (
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/log"
+)
+
+// MemoryAuditStore is the store for the audit log kept in a MemoryDatabase.
+type MemoryAuditStore struct {
+	Database *MemoryDatabase
+}
+
+// NewMemoryAuditStore creates a new MemoryAuditStore.
+func NewMemoryAuditStore(db *MemoryDatabase) *MemoryAuditStore {
+	log.Debug("Initializing memory audit store")
+	store := new(MemoryAuditStore)
+	store.Database = db
+	return store
+}
+
+// FindRange returns a range of entries sorted from newest to oldest.
+func (store *MemoryAuditStore) FindRange(limit int64, offset int64) ([]model.AuditEntry, error) {
+	log.Debugf("Retrieving %d audit log entries starting from entry number %d from memory", limit, offset)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	// Entries are appended, so the newest entries are at the end
+	count := len(store.Database.audit)
+	start, end := pageRange(count, limit, offset)
+	entries := make([]model.AuditEntry, 0, end-start)
+	for i := start; i < end; i++ {
+		entries = append(entries, store.Database.audit[count-1-i])
+	}
+	return entries, nil
+}
+
+// Insert adds an entry to the audit log. The time of the entry is set when
+// it's empty.
+func (store *MemoryAuditStore) Insert(entry *model.AuditEntry) error {
+	log.Debugf("Inserting audit log entry '%s' to memory", entry.Event)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if entry.TimeCreated.IsZero() {
+		entry.TimeCreated = time.Now().UTC()
+	}
+
+	entry.ID = int64(len(store.Database.audit) + 1)
+	store.Database.audit = append(store.Database.audit, *entry)
+	return nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"errors"
+	"sync"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/log"
+)
+
+var (
+	errDuplicateUID     = errors.New("a user with this uid already exists")
+	errDuplicateSubject = errors.New("a user with this OpenID Connect subject already exists")
+	errMissingUser      = errors.New("referenced user doesn't exist")
+	errMissingPaste     = errors.New("referenced paste doesn't exist")
+)
+
+// MemoryDatabase keeps users, tokens, pastes and the audit log in memory instead of a
+// database. It's shared by the memory stores, so deleting a user or paste
+// changes the rows referencing it like the foreign keys of a database would.
+// Everything is lost when the server stops.
+type MemoryDatabase struct {
+	mutex         sync.RWMutex
+	users         map[int64]*model.User
+	recoveryCodes map[int64]map[string]bool
+	tokens        map[int64]*model.APIToken
+	pastes        map[int64]*memoryPaste
+	blobs         map[string][]byte
+	audit         []model.AuditEntry
+}
+
+// memoryPaste is a paste kept in memory along with its revisions. The content
+// of its attachments is kept in the blobs of the database.
+type memoryPaste struct {
+	model.Paste
+	Revisions []model.PasteRevision
+}
+
+// NewMemoryDatabase creates a new empty MemoryDatabase.
+func NewMemoryDatabase() *MemoryDatabase {
+	log.Info("Using memory database, all data is lost when the server stops")
+
+	db := new(MemoryDatabase)
+	db.users = make(map[int64]*model.User)
+	db.recoveryCodes = make(map[int64]map[string]bool)
+	db.tokens = make(map[int64]*model.APIToken)
+	db.pastes = make(map[int64]*memoryPaste)
+	db.blobs = make(map[string][]byte)
+	db.audit = []model.AuditEntry{}
+	return db
+}
+
+// newMemoryID returns a new random id for which used returns false.
+func newMemoryID(used func(id int64) bool) (int64, error) {
+	for {
+		id, err := newID()
+		if err != nil || !used(id) {
+			return id, err
+		}
+	}
+}
+
+// pageRange returns the bounds of the page of a slice of the given length.
+func pageRange(length int, limit int64, offset int64) (int, int) {
+	start := int64(length)
+	if offset >= 0 && offset < start {
+		start = offset
+	}
+
+	end := int64(length)
+	if limit >= 0 && start+limit < end {
+		end = start + limit
+	}
+	return int(start), int(end)
+}
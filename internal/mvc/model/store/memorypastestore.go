@@ -0,0 +1,497 @@
+package store
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/fmtutil"
+	"bingo/internal/util/log"
+)
+
+// MemoryPasteStore is the store for pastes kept in a MemoryDatabase. The
+// content of attachments is kept in memory as well, regardless of the
+// configured storage backend.
+type MemoryPasteStore struct {
+	Database *MemoryDatabase
+}
+
+// NewMemoryPasteStore creates a new MemoryPasteStore.
+func NewMemoryPasteStore(db *MemoryDatabase) *MemoryPasteStore {
+	log.Debug("Initializing memory paste store")
+
+	store := new(MemoryPasteStore)
+	store.Database = db
+
+	if config.Get().Expiry.Enabled {
+		go store.monitorExpired()
+	}
+
+	return store
+}
+
+// Count returns the number of pastes.
+func (store *MemoryPasteStore) Count() int64 {
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	return int64(len(store.Database.pastes))
+}
+
+// FindByID returns the paste with the given id.
+func (store *MemoryPasteStore) FindByID(id int64) (*model.Paste, error) {
+	log.Debugf("Retrieving paste %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	paste, ok := store.find(id, time.Now().UTC())
+	if !ok {
+		return new(model.Paste), sql.ErrNoRows
+	}
+
+	// The content of pastes with a view limit is only returned by View
+	found := copyPaste(paste, true)
+	if found.ViewsLeft.Valid {
+		withoutContent(found)
+	}
+	return found, nil
+}
+
+// View returns the paste with the given id and counts it as viewed. Pastes
+// with a view limit are deleted on their last view.
+func (store *MemoryPasteStore) View(id int64) (*model.Paste, error) {
+	log.Debugf("Viewing paste %d", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	paste, ok := store.find(id, time.Now().UTC())
+	if !ok {
+		return new(model.Paste), sql.ErrNoRows
+	}
+
+	viewed := copyPaste(paste, true)
+	if !paste.ViewsLeft.Valid {
+		return viewed, nil
+	}
+
+	if paste.ViewsLeft.Int64 <= 1 {
+		store.delete(id)
+		viewed.ViewsLeft.Int64 = 0
+	} else {
+		paste.ViewsLeft.Int64--
+		viewed.ViewsLeft.Int64 = paste.ViewsLeft.Int64
+	}
+	return viewed, nil
+}
+
+// FindRange returns a slice of public pastes sorted by their creation time.
+func (store *MemoryPasteStore) FindRange(limit int64, offset int64) ([]model.Paste, error) {
+	log.Debugf("Retrieving %d public pastes starting from paste number %d from memory", limit, offset)
+
+	return store.list(limit, offset, func(paste *memoryPaste) bool {
+		return paste.Visibility >= config.VisibilityListed && !paste.ViewsLeft.Valid
+	}), nil
+}
+
+// Search returns a list of public pastes sorted by their creation time and
+// matching given filter. Pastes match if they contain all words of the filter
+// in their title, files or language, ignoring case.
+func (store *MemoryPasteStore) Search(filter string, limit int64, offset int64) ([]model.Paste, error) {
+	log.Debugf("Retrieving %d public pastes starting from paste number %d and matching '%s' from memory", limit, offset, filter)
+
+	words := strings.Fields(strings.ToLower(filter))
+	return store.list(limit, offset, func(paste *memoryPaste) bool {
+		if paste.Visibility < config.VisibilityListed || paste.ViewsLeft.Valid || paste.PasswordHash.Valid {
+			return false
+		}
+		if len(words) == 0 || paste.Kind != model.PasteKindText {
+			return false
+		}
+
+		text := strings.ToLower(strings.Join([]string{paste.Title, searchContent(pasteTemplate(&paste.Paste)), paste.Language}, "\n"))
+		for _, word := range words {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+		return true
+	}), nil
+}
+
+// FindByUser returns a slice of the pastes created by the user with the given id
+// sorted by their creation time. Pastes with a view limit are left out.
+func (store *MemoryPasteStore) FindByUser(userID int64, limit int64, offset int64) ([]model.Paste, error) {
+	log.Debugf("Retrieving %d pastes of user %d starting from paste number %d from memory", limit, userID, offset)
+
+	return store.list(limit, offset, func(paste *memoryPaste) bool {
+		return paste.UserID.Valid && paste.UserID.Int64 == userID && !paste.ViewsLeft.Valid
+	}), nil
+}
+
+// FindFiles returns the additional files of the paste with the given id.
+func (store *MemoryPasteStore) FindFiles(id int64) ([]model.PasteFile, error) {
+	log.Debugf("Retrieving files of paste %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	files := []model.PasteFile{}
+	if paste, ok := store.Database.pastes[id]; ok {
+		files = append(files, paste.Files...)
+		if paste.ViewsLeft.Valid {
+			files = filesWithoutContent(files)
+		}
+	}
+	return files, nil
+}
+
+// FindAttachments returns the attachments of the paste with the given id.
+func (store *MemoryPasteStore) FindAttachments(id int64) ([]model.PasteAttachment, error) {
+	log.Debugf("Retrieving attachments of paste %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	attachments := []model.PasteAttachment{}
+	if paste, ok := store.Database.pastes[id]; ok {
+		attachments = append(attachments, paste.Attachments...)
+	}
+	return attachments, nil
+}
+
+// FindForks returns the pastes forked from the paste with the given id that
+// have at least the given visibility, sorted by their creation time.
+func (store *MemoryPasteStore) FindForks(id int64, visibility config.Visibility) ([]model.Paste, error) {
+	log.Debugf("Retrieving forks of paste %d from memory", id)
+
+	return store.list(-1, 0, func(paste *memoryPaste) bool {
+		return paste.ParentID.Valid && paste.ParentID.Int64 == id && paste.Visibility >= visibility
+	}), nil
+}
+
+// FindRevisions returns all revisions of the paste with the given id, newest first.
+// The current content of the paste is included as the latest revision.
+func (store *MemoryPasteStore) FindRevisions(id int64) ([]model.PasteRevision, error) {
+	log.Debugf("Retrieving revisions of paste %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	paste, ok := store.find(id, time.Now().UTC())
+	if !ok || paste.ViewsLeft.Valid {
+		return []model.PasteRevision{}, nil
+	}
+
+	revisions := append([]model.PasteRevision{currentRevision(paste)}, paste.Revisions...)
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Revision > revisions[j].Revision
+	})
+	return revisions, nil
+}
+
+// FindRevision returns the given revision of the paste with the given id.
+func (store *MemoryPasteStore) FindRevision(id int64, revision int) (*model.PasteRevision, error) {
+	log.Debugf("Retrieving revision %d of paste %d from memory", revision, id)
+
+	revisions, err := store.FindRevisions(id)
+	if err != nil {
+		return new(model.PasteRevision), err
+	}
+
+	for i := range revisions {
+		if revisions[i].Revision == revision {
+			return &revisions[i], nil
+		}
+	}
+	return new(model.PasteRevision), sql.ErrNoRows
+}
+
+// Delete deletes the paste with the given id.
+func (store *MemoryPasteStore) Delete(id int64) error {
+	log.Debugf("Deleting paste %d from memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	store.delete(id)
+	return nil
+}
+
+// Insert inserts a new paste.
+func (store *MemoryPasteStore) Insert(pasteTmpl *model.PasteTemplate) (*model.Paste, error) {
+	log.Debug("Inserting new paste to memory")
+
+	timeCreated := time.Now().UTC()
+
+	// Encrypted pastes are opaque to the server, so they're not highlighted
+	formatted := ""
+	if pasteTmpl.Kind == model.PasteKindText {
+		formatted = fmtutil.FormatCode(pasteTmpl.Language, pasteTmpl.RawContent)
+	}
+
+	var editKeyHash sql.NullString
+	if pasteTmpl.EditKey != "" {
+		editKeyHash.String = auth.HashToken(pasteTmpl.EditKey)
+		editKeyHash.Valid = true
+	}
+
+	var passwordHash sql.NullString
+	if pasteTmpl.Password != "" {
+		hash, err := auth.HashPassword(pasteTmpl.Password)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash.String = hash
+		passwordHash.Valid = true
+	}
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if _, ok := store.Database.users[pasteTmpl.UserID.Int64]; pasteTmpl.UserID.Valid && !ok {
+		return nil, errMissingUser
+	}
+	if _, ok := store.Database.pastes[pasteTmpl.ParentID.Int64]; pasteTmpl.ParentID.Valid && !ok {
+		return nil, errMissingPaste
+	}
+
+	id, err := newMemoryID(func(id int64) bool {
+		_, ok := store.Database.pastes[id]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := store.putAttachments(pasteTmpl.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	paste := &memoryPaste{
+		Paste: model.Paste{
+			ID:               id,
+			TimeCreated:      timeCreated,
+			Title:            pasteTmpl.Title,
+			RawContent:       pasteTmpl.RawContent,
+			FormattedContent: formatted,
+			Language:         pasteTmpl.Language,
+			TimeExpires:      sql.NullTime{Time: timeCreated.Add(pasteTmpl.Duration), Valid: pasteTmpl.Duration > 0},
+			Visibility:       pasteTmpl.Visibility,
+			UserID:           pasteTmpl.UserID,
+			EditKeyHash:      editKeyHash,
+			Revision:         1,
+			ParentID:         pasteTmpl.ParentID,
+			ViewsLeft:        sql.NullInt64{Int64: pasteTmpl.Views, Valid: pasteTmpl.Views > 0},
+			PasswordHash:     passwordHash,
+			Kind:             pasteTmpl.Kind,
+			Filename:         pasteTmpl.Filename,
+			Files:            formatFiles(id, pasteTmpl),
+			Attachments:      positionAttachments(id, attachments),
+		},
+	}
+	store.Database.pastes[id] = paste
+
+	return copyPaste(paste, true), nil
+}
+
+// Update updates the title, files and visibility of an existing paste. The
+// previous title, content and language of the first file are kept as a
+// revision, additional files are replaced.
+func (store *MemoryPasteStore) Update(id int64, pasteTmpl *model.PasteTemplate) (*model.Paste, error) {
+	log.Debugf("Updating paste %d in memory", id)
+
+	formatted := fmtutil.FormatCode(pasteTmpl.Language, pasteTmpl.RawContent)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	timeUpdated := time.Now().UTC()
+	paste, ok := store.find(id, timeUpdated)
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+
+	attachments, err := store.putAttachments(pasteTmpl.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := paste.Attachments
+	paste.Revisions = append(paste.Revisions, currentRevision(paste))
+	paste.Title = pasteTmpl.Title
+	paste.RawContent = pasteTmpl.RawContent
+	paste.FormattedContent = formatted
+	paste.Language = pasteTmpl.Language
+	paste.Visibility = pasteTmpl.Visibility
+	paste.Revision++
+	paste.TimeUpdated = sql.NullTime{Time: timeUpdated, Valid: true}
+	paste.Filename = pasteTmpl.Filename
+	paste.Files = formatFiles(id, pasteTmpl)
+	paste.Attachments = positionAttachments(id, attachments)
+
+	for _, key := range removedAttachments(previous, paste.Attachments) {
+		delete(store.Database.blobs, key)
+	}
+
+	return copyPaste(paste, true), nil
+}
+
+// LoadContent does nothing, the content of pastes kept in memory is always
+// loaded.
+func (store *MemoryPasteStore) LoadContent(paste *model.Paste) error {
+	return nil
+}
+
+// LoadAttachment reads the content of the attachment.
+func (store *MemoryPasteStore) LoadAttachment(attachment *model.PasteAttachment) error {
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	content, ok := store.Database.blobs[attachment.BlobKey]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	attachment.Content = append([]byte(nil), content...)
+	return nil
+}
+
+// find returns the paste with the given id unless it has expired. The caller
+// must hold the lock of the database.
+func (store *MemoryPasteStore) find(id int64, now time.Time) (*memoryPaste, bool) {
+	paste, ok := store.Database.pastes[id]
+	if !ok || isExpired(paste, now) {
+		return nil, false
+	}
+	return paste, true
+}
+
+// list returns the pastes that haven't expired and match the filter, sorted
+// by their creation time. A negative limit returns all of them.
+func (store *MemoryPasteStore) list(limit int64, offset int64, match func(paste *memoryPaste) bool) []model.Paste {
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	now := time.Now().UTC()
+	pastes := []model.Paste{}
+	for _, paste := range store.Database.pastes {
+		if !isExpired(paste, now) && match(paste) {
+			pastes = append(pastes, *copyPaste(paste, false))
+		}
+	}
+
+	sort.Slice(pastes, func(i, j int) bool {
+		if !pastes[i].TimeCreated.Equal(pastes[j].TimeCreated) {
+			return pastes[i].TimeCreated.After(pastes[j].TimeCreated)
+		}
+		return pastes[i].ID < pastes[j].ID
+	})
+
+	start, end := pageRange(len(pastes), limit, offset)
+	return pastes[start:end]
+}
+
+// delete deletes the paste with the given id along with the content of its
+// attachments. Forks of the paste are kept without a parent. The caller must
+// hold the lock of the database.
+func (store *MemoryPasteStore) delete(id int64) {
+	paste, ok := store.Database.pastes[id]
+	if !ok {
+		return
+	}
+
+	delete(store.Database.pastes, id)
+	for _, attachment := range paste.Attachments {
+		delete(store.Database.blobs, attachment.BlobKey)
+	}
+	for _, fork := range store.Database.pastes {
+		if fork.ParentID.Valid && fork.ParentID.Int64 == id {
+			fork.ParentID = sql.NullInt64{}
+		}
+	}
+}
+
+// putAttachments stores the content of new attachments and returns the
+// attachments with their blob keys set. The caller must hold the lock of the
+// database.
+func (store *MemoryPasteStore) putAttachments(attachments []model.PasteAttachment) ([]model.PasteAttachment, error) {
+	stored := make([]model.PasteAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.BlobKey == "" {
+			key, err := auth.GenerateKey()
+			if err != nil {
+				return nil, err
+			}
+			store.Database.blobs[key] = append([]byte(nil), attachment.Content...)
+			attachment.BlobKey = key
+		}
+		stored = append(stored, attachment)
+	}
+
+	return stored, nil
+}
+
+func (store *MemoryPasteStore) monitorExpired() {
+	log.Debug("Monitoring expired pastes")
+
+	for range time.Tick(deleteExpiredInterval) {
+		log.Debugf("Deleted %d expired pastes", store.deleteExpired())
+	}
+}
+
+func (store *MemoryPasteStore) deleteExpired() int64 {
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	now := time.Now().UTC()
+	var count int64
+	for id, paste := range store.Database.pastes {
+		if isExpired(paste, now) {
+			store.delete(id)
+			count++
+		}
+	}
+	return count
+}
+
+func isExpired(paste *memoryPaste, now time.Time) bool {
+	return paste.TimeExpires.Valid && !paste.TimeExpires.Time.After(now)
+}
+
+// copyPaste returns a copy of the paste. Lists of pastes are returned
+// without their files and attachments, like by the database stores.
+func copyPaste(paste *memoryPaste, full bool) *model.Paste {
+	copied := paste.Paste
+	copied.Files = nil
+	copied.Attachments = nil
+	if full {
+		copied.Files = append([]model.PasteFile{}, paste.Files...)
+		copied.Attachments = append([]model.PasteAttachment{}, paste.Attachments...)
+	}
+	return &copied
+}
+
+// currentRevision returns the current title, content and language of the
+// paste as a revision.
+func currentRevision(paste *memoryPaste) model.PasteRevision {
+	timeCreated := paste.TimeCreated
+	if paste.TimeUpdated.Valid {
+		timeCreated = paste.TimeUpdated.Time
+	}
+
+	return model.PasteRevision{
+		PasteID:     paste.ID,
+		Revision:    paste.Revision,
+		TimeCreated: timeCreated,
+		Title:       paste.Title,
+		RawContent:  paste.RawContent,
+		Language:    paste.Language,
+	}
+}
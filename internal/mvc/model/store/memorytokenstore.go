@@ -0,0 +1,121 @@
+package store
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/log"
+)
+
+// MemoryTokenStore is the store for personal API tokens kept in a
+// MemoryDatabase.
+type MemoryTokenStore struct {
+	Database *MemoryDatabase
+}
+
+// NewMemoryTokenStore creates a new MemoryTokenStore.
+func NewMemoryTokenStore(db *MemoryDatabase) *MemoryTokenStore {
+	log.Debug("Initializing memory token store")
+	store := new(MemoryTokenStore)
+	store.Database = db
+	return store
+}
+
+// FindByUser returns all tokens of the given user sorted by their creation time.
+func (store *MemoryTokenStore) FindByUser(userID int64) ([]model.APIToken, error) {
+	log.Debugf("Retrieving tokens of user %d from memory", userID)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	tokens := []model.APIToken{}
+	for _, token := range store.Database.tokens {
+		if token.UserID == userID {
+			tokens = append(tokens, *token)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].TimeCreated.Equal(tokens[j].TimeCreated) {
+			return tokens[i].TimeCreated.After(tokens[j].TimeCreated)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// FindByToken returns the token matching the given plain-text token and marks it as used.
+func (store *MemoryTokenStore) FindByToken(token string) (*model.APIToken, error) {
+	log.Debug("Retrieving token from memory")
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	hash := auth.HashToken(token)
+	for _, apiToken := range store.Database.tokens {
+		if apiToken.TokenHash == hash {
+			apiToken.TimeLastUsed = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+			copied := *apiToken
+			return &copied, nil
+		}
+	}
+	return new(model.APIToken), sql.ErrNoRows
+}
+
+// Delete deletes the token with the given id of the given user.
+func (store *MemoryTokenStore) Delete(id int64, userID int64) (int64, error) {
+	log.Debugf("Deleting token %d from memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	token, ok := store.Database.tokens[id]
+	if !ok || token.UserID != userID {
+		return 0, nil
+	}
+
+	delete(store.Database.tokens, id)
+	return 1, nil
+}
+
+// Insert creates a new token for the given user. The plain-text token is only
+// returned here and can't be recovered later.
+func (store *MemoryTokenStore) Insert(userID int64, name string) (*model.APIToken, string, error) {
+	log.Debugf("Inserting new token for user %d to memory", userID)
+
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if _, ok := store.Database.users[userID]; !ok {
+		return nil, "", errMissingUser
+	}
+
+	id, err := newMemoryID(func(id int64) bool {
+		_, ok := store.Database.tokens[id]
+		return ok
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiToken := &model.APIToken{
+		ID:          id,
+		UserID:      userID,
+		TimeCreated: time.Now().UTC(),
+		Name:        name,
+		Prefix:      token[:auth.TokenDisplayLength],
+		TokenHash:   auth.HashToken(token),
+	}
+	store.Database.tokens[id] = apiToken
+
+	copied := *apiToken
+	return &copied, token, nil
+}
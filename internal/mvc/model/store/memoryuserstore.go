@@ -0,0 +1,365 @@
+package store
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/log"
+)
+
+// MemoryUserStore is the store for users kept in a MemoryDatabase.
+type MemoryUserStore struct {
+	Database *MemoryDatabase
+}
+
+// NewMemoryUserStore creates a new MemoryUserStore.
+func NewMemoryUserStore(db *MemoryDatabase) *MemoryUserStore {
+	log.Debug("Initializing memory user store")
+	store := new(MemoryUserStore)
+	store.Database = db
+	return store
+}
+
+// Count returns the number of users.
+func (store *MemoryUserStore) Count() int64 {
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	return int64(len(store.Database.users))
+}
+
+// FindByID returns the user with the given id.
+func (store *MemoryUserStore) FindByID(id int64) (*model.User, error) {
+	log.Debugf("Retrieving user %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	user, ok := store.Database.users[id]
+	if !ok {
+		return new(model.User), sql.ErrNoRows
+	}
+	copied := *user
+	return &copied, nil
+}
+
+// FindByUID returns the user with the given uid. Uids are compared
+// case-insensitively.
+func (store *MemoryUserStore) FindByUID(uid string) (*model.User, error) {
+	log.Debugf("Retrieving user with name '%s' from memory", uid)
+
+	return store.find(func(user *model.User) bool {
+		return strings.EqualFold(user.UID, uid)
+	})
+}
+
+// FindByEmail returns the user with the given email. Emails are compared
+// case-insensitively.
+func (store *MemoryUserStore) FindByEmail(email string) (*model.User, error) {
+	log.Debugf("Retrieving user with mail '%s' from memory", email)
+
+	return store.find(func(user *model.User) bool {
+		return user.Email.Valid && strings.EqualFold(user.Email.String, email)
+	})
+}
+
+// FindByOIDC returns the user with the given OpenID Connect issuer and
+// subject, which are compared exactly.
+func (store *MemoryUserStore) FindByOIDC(issuer string, subject string) (*model.User, error) {
+	log.Debugf("Retrieving user with OpenID Connect subject '%s' of '%s' from memory", subject, issuer)
+
+	return store.find(func(user *model.User) bool {
+		return user.OIDCIssuer.Valid && user.OIDCIssuer.String == issuer && user.OIDCSubject.Valid && user.OIDCSubject.String == subject
+	})
+}
+
+// FindRange returns a slice of users sorted by their role and name.
+func (store *MemoryUserStore) FindRange(limit int64, offset int64) ([]model.User, error) {
+	log.Debugf("Retrieving %d public users starting from user number %d from memory", limit, offset)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	users := make([]model.User, 0, len(store.Database.users))
+	for _, user := range store.Database.users {
+		users = append(users, *user)
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Role != users[j].Role {
+			return users[i].Role > users[j].Role
+		}
+		if users[i].Name != users[j].Name {
+			return users[i].Name < users[j].Name
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	start, end := pageRange(len(users), limit, offset)
+	return users[start:end], nil
+}
+
+// Delete deletes the user with the given id along with their tokens and
+// recovery codes. Their pastes are kept without an owner.
+func (store *MemoryUserStore) Delete(id int64) error {
+	log.Debugf("Deleting user %d from memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	delete(store.Database.users, id)
+	delete(store.Database.recoveryCodes, id)
+	for tokenID, token := range store.Database.tokens {
+		if token.UserID == id {
+			delete(store.Database.tokens, tokenID)
+		}
+	}
+	for _, paste := range store.Database.pastes {
+		if paste.UserID.Valid && paste.UserID.Int64 == id {
+			paste.UserID = sql.NullInt64{}
+		}
+	}
+	return nil
+}
+
+// Insert inserts a new user.
+func (store *MemoryUserStore) Insert(userTmpl *model.UserTemplate) (*model.User, error) {
+	log.Debug("Inserting new user to memory")
+	log.Tracef("%+v", userTmpl)
+
+	// Users authenticated by an external directory don't have a password
+	var passwordHash sql.NullString
+	if userTmpl.Password.Valid {
+		hash, err := auth.HashPassword(userTmpl.Password.String)
+		if err != nil {
+			return nil, err
+		}
+
+		passwordHash.String = hash
+		passwordHash.Valid = true
+	}
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if store.uidExists(userTmpl.UID.String, 0) {
+		return nil, errDuplicateUID
+	}
+	if userTmpl.OIDCIssuer.Valid && userTmpl.OIDCSubject.Valid && store.subjectExists(userTmpl.OIDCIssuer.String, userTmpl.OIDCSubject.String) {
+		return nil, errDuplicateSubject
+	}
+
+	id, err := newMemoryID(func(id int64) bool {
+		_, ok := store.Database.users[id]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	user := &model.User{
+		ID:            id,
+		TimeCreated:   time.Now().UTC(),
+		UID:           userTmpl.UID.String,
+		Name:          userTmpl.Name.String,
+		Email:         userTmpl.Email,
+		EmailVerified: !userTmpl.EmailVerified.Valid || userTmpl.EmailVerified.Bool,
+		PasswordHash:  passwordHash,
+		AuthMode:      config.AuthMode(userTmpl.AuthMode.Int32),
+		Role:          config.Role(userTmpl.Role.Int32),
+		Theme:         config.Theme(userTmpl.Theme.Int32),
+		OIDCIssuer:    userTmpl.OIDCIssuer,
+		OIDCSubject:   userTmpl.OIDCSubject,
+	}
+	store.Database.users[id] = user
+
+	copied := *user
+	return &copied, nil
+}
+
+// Update updates an existing user. Fields that aren't set in the template
+// are left as they are.
+func (store *MemoryUserStore) Update(userTmpl *model.UserTemplate) (*model.User, error) {
+	log.Debug("Updating existing user in memory")
+	log.Tracef("%+v", userTmpl)
+
+	var passwordHash sql.NullString
+	if userTmpl.Password.Valid {
+		hash, err := auth.HashPassword(userTmpl.Password.String)
+		if err != nil {
+			return nil, err
+		}
+
+		passwordHash.String = hash
+		passwordHash.Valid = true
+	}
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	user, ok := store.Database.users[userTmpl.ID.Int64]
+	if !ok {
+		return new(model.User), sql.ErrNoRows
+	}
+
+	if userTmpl.UID.Valid && store.uidExists(userTmpl.UID.String, user.ID) {
+		return nil, errDuplicateUID
+	}
+
+	updated := *user
+	if userTmpl.UID.Valid {
+		updated.UID = userTmpl.UID.String
+	}
+	if userTmpl.Name.Valid {
+		updated.Name = userTmpl.Name.String
+	}
+	if userTmpl.Email.Valid {
+		updated.Email = userTmpl.Email
+	}
+	if userTmpl.EmailVerified.Valid {
+		updated.EmailVerified = userTmpl.EmailVerified.Bool
+	}
+	if passwordHash.Valid {
+		updated.PasswordHash = passwordHash
+	}
+	if userTmpl.AuthMode.Valid {
+		updated.AuthMode = config.AuthMode(userTmpl.AuthMode.Int32)
+	}
+	if userTmpl.Role.Valid {
+		updated.Role = config.Role(userTmpl.Role.Int32)
+	}
+	if userTmpl.Theme.Valid {
+		updated.Theme = config.Theme(userTmpl.Theme.Int32)
+	}
+	*user = updated
+
+	return &updated, nil
+}
+
+// EnableTOTP enables two-factor authentication for the user with the given
+// secret, replacing their recovery codes with the given hashes.
+func (store *MemoryUserStore) EnableTOTP(id int64, secret string, codeHashes []string) error {
+	log.Debugf("Enabling two-factor authentication of user %d in memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	user, ok := store.Database.users[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	user.TOTPSecret = sql.NullString{String: secret, Valid: true}
+	user.TOTPStep = sql.NullInt64{}
+
+	codes := make(map[string]bool, len(codeHashes))
+	for _, codeHash := range codeHashes {
+		codes[codeHash] = true
+	}
+	store.Database.recoveryCodes[id] = codes
+	return nil
+}
+
+// DisableTOTP disables two-factor authentication for the user with the given
+// id and deletes their recovery codes.
+func (store *MemoryUserStore) DisableTOTP(id int64) error {
+	log.Debugf("Disabling two-factor authentication of user %d in memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if user, ok := store.Database.users[id]; ok {
+		user.TOTPSecret = sql.NullString{}
+		user.TOTPStep = sql.NullInt64{}
+	}
+	delete(store.Database.recoveryCodes, id)
+	return nil
+}
+
+// UseTOTPStep marks the time step of a code as used. False is returned if a
+// code of the same or a later time step was used before, which means the code
+// is replayed.
+func (store *MemoryUserStore) UseTOTPStep(id int64, step int64) (bool, error) {
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	user, ok := store.Database.users[id]
+	if !ok || (user.TOTPStep.Valid && user.TOTPStep.Int64 >= step) {
+		return false, nil
+	}
+
+	user.TOTPStep = sql.NullInt64{Int64: step, Valid: true}
+	return true, nil
+}
+
+// UseRecoveryCode deletes the recovery code with the given hash of the user.
+// False is returned if the user doesn't have the code.
+func (store *MemoryUserStore) UseRecoveryCode(id int64, codeHash string) (bool, error) {
+	log.Debugf("Using recovery code of user %d in memory", id)
+
+	store.Database.mutex.Lock()
+	defer store.Database.mutex.Unlock()
+
+	if !store.Database.recoveryCodes[id][codeHash] {
+		return false, nil
+	}
+
+	delete(store.Database.recoveryCodes[id], codeHash)
+	return true, nil
+}
+
+// FindRecoveryCodes returns the hashes of the unused recovery codes of the
+// user with the given id.
+func (store *MemoryUserStore) FindRecoveryCodes(id int64) ([]string, error) {
+	log.Debugf("Retrieving recovery codes of user %d from memory", id)
+
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	codeHashes := make([]string, 0, len(store.Database.recoveryCodes[id]))
+	for codeHash := range store.Database.recoveryCodes[id] {
+		codeHashes = append(codeHashes, codeHash)
+	}
+	sort.Strings(codeHashes)
+	return codeHashes, nil
+}
+
+func (store *MemoryUserStore) find(match func(user *model.User) bool) (*model.User, error) {
+	store.Database.mutex.RLock()
+	defer store.Database.mutex.RUnlock()
+
+	for _, user := range store.Database.users {
+		if match(user) {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return new(model.User), sql.ErrNoRows
+}
+
+// uidExists returns whether a user other than the one with the given id has
+// the uid. The caller must hold the lock of the database.
+func (store *MemoryUserStore) uidExists(uid string, id int64) bool {
+	for _, user := range store.Database.users {
+		if user.ID != id && strings.EqualFold(user.UID, uid) {
+			return true
+		}
+	}
+	return false
+}
+
+// subjectExists returns whether a user has the OpenID Connect issuer and
+// subject. The caller must hold the lock of the database.
+func (store *MemoryUserStore) subjectExists(issuer string, subject string) bool {
+	for _, user := range store.Database.users {
+		if user.OIDCIssuer.Valid && user.OIDCIssuer.String == issuer && user.OIDCSubject.String == subject {
+			return true
+		}
+	}
+	return false
+}
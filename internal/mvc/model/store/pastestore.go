@@ -2,10 +2,14 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
 	"bingo/internal/config"
 	"bingo/internal/mvc/model"
+	"bingo/internal/util/auth"
 	"bingo/internal/util/fmtutil"
 	"bingo/internal/util/log"
 
@@ -16,18 +20,46 @@ var (
 	deleteExpiredInterval = time.Minute * time.Duration(5)
 )
 
+// selectPasteQuery selects a paste by its id regardless of its expiry, which
+// is used to read pastes after changing them if RETURNING isn't supported.
+const selectPasteQuery = `
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		FROM pastes
+		WHERE id = $1
+		`
+
 // PasteStore is the store for pastes.
 type PasteStore struct {
 	Database *sqlx.DB
+	Blobs    BlobStore
+
+	// ExternalContent is set if new content is kept in the blob store instead
+	// of the pastes table. Content stored before is read from where it is.
+	ExternalContent bool
+}
+
+// blobContent is the content of a paste or file kept in the blob store.
+type blobContent struct {
+	Raw       string `json:"raw"`
+	Formatted string `json:"formatted"`
+}
+
+// storedContent is the content of a paste or file as stored in the database.
+// Content kept in the blob store is only referenced by its key.
+type storedContent struct {
+	Key       sql.NullString
+	Raw       string
+	Formatted string
 }
 
 // NewPasteStore creates a new PasteStore instance.
-func NewPasteStore(db *sqlx.DB) *PasteStore {
+func NewPasteStore(db *sqlx.DB, blobs BlobStore) *PasteStore {
 	log.Debug("Initializing paste store")
 
 	store := new(PasteStore)
 	store.Database = db
-	store.createTable()
+	store.Blobs = blobs
+	store.ExternalContent = config.Get().Storage.Backend != "database"
 
 	if config.Get().Expiry.Enabled {
 		go store.monitorExpired()
@@ -45,31 +77,169 @@ func (store *PasteStore) Count() int64 {
 	return count
 }
 
-// FindByID returns the paste with the given id from the database.
+// FindByID returns the paste with the given id from the database. Pastes with
+// a view limit are returned without their content, which is only returned by
+// View, so no other way of reading a paste can skip counting the view.
 func (store *PasteStore) FindByID(id int64) (*model.Paste, error) {
+	paste, err := store.findByID(id)
+	if err != nil || paste.ViewsLeft.Valid {
+		withoutContent(paste)
+		return paste, err
+	}
+
+	return paste, store.LoadContent(paste)
+}
+
+// FindForExport returns the paste with the given id including the content of
+// pastes with a view limit without counting a view, which is only meant for
+// backups.
+func (store *PasteStore) FindForExport(id int64) (*model.Paste, error) {
+	paste, err := store.findByID(id)
+	if err != nil {
+		return paste, err
+	}
+
+	return paste, store.LoadContent(paste)
+}
+
+func (store *PasteStore) findByID(id int64) (*model.Paste, error) {
 	log.Debugf("Retrieving paste %d from database", id)
 
 	query := `
-		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
 		FROM pastes
 		WHERE id = $1
 		AND (time_expires IS NULL OR time_expires > $2)
+		AND (views_left IS NULL OR views_left > 0)
 		`
 
 	paste := new(model.Paste)
 	err := store.Database.Get(paste, query, id, time.Now().UTC())
+	if err != nil {
+		return paste, err
+	}
+
+	paste.Files, err = store.findFiles(store.Database, id)
+	if err != nil {
+		return paste, err
+	}
+
+	paste.Attachments, err = store.findAttachments(store.Database, id)
 	return paste, err
 }
 
+// View returns the paste with the given id from the database and counts it
+// as viewed. Pastes with a view limit are deleted on their last view. Views are
+// counted by a single statement, so a paste that can be viewed once is never
+// returned to more than one reader.
+func (store *PasteStore) View(id int64) (*model.Paste, error) {
+	log.Debugf("Viewing paste %d", id)
+
+	query := `
+		UPDATE pastes
+		SET views_left = views_left - 1
+		WHERE id = $1
+		AND views_left > 0
+		AND (time_expires IS NULL OR time_expires > $2)
+		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		`
+
+	paste := new(model.Paste)
+	var err error
+	if model.IsMySQL(store.Database) {
+		err = store.viewMySQL(paste, id)
+	} else {
+		err = store.Database.Get(paste, query, id, time.Now().UTC())
+	}
+
+	// Pastes without a view limit aren't changed by counting the view
+	if err == sql.ErrNoRows {
+		paste, err = store.FindByID(id)
+		if err == nil && paste.ViewsLeft.Valid {
+			return new(model.Paste), sql.ErrNoRows
+		}
+		return paste, err
+	} else if err != nil {
+		return paste, err
+	}
+
+	paste.Files, err = store.findFiles(store.Database, id)
+	if err != nil {
+		return paste, err
+	}
+
+	paste.Attachments, err = store.findAttachments(store.Database, id)
+	if err != nil {
+		return paste, err
+	}
+
+	err = store.LoadContent(paste)
+	if err != nil {
+		return paste, err
+	}
+
+	// The content was read before, it's deleted along with the paste
+	if paste.ViewsLeft.Int64 == 0 {
+		err = store.deleteViewed(id)
+	}
+	return paste, err
+}
+
+// viewMySQL counts a view of the paste on MySQL, which doesn't support
+// RETURNING. The paste is locked until the view is counted, so concurrent
+// views wait for each other.
+func (store *PasteStore) viewMySQL(paste *model.Paste, id int64) error {
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := selectPasteQuery + `
+		AND views_left > 0
+		AND (time_expires IS NULL OR time_expires > $2)
+		FOR UPDATE
+		`
+	err = tx.Get(paste, query, id, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE pastes SET views_left = views_left - 1 WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	paste.ViewsLeft.Int64--
+	return tx.Commit()
+}
+
+// deleteViewed deletes the paste with the given id if it has no views left.
+func (store *PasteStore) deleteViewed(id int64) error {
+	keys, err := store.blobKeys("id = $1 AND views_left = 0", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.Database.Exec("DELETE FROM pastes WHERE id = $1 AND views_left = 0", id)
+	if err != nil {
+		return err
+	}
+
+	store.deleteBlobs(keys)
+	return nil
+}
+
 // FindRange returns a slice of public pastes sorted by their creation time.
 func (store *PasteStore) FindRange(limit int64, offset int64) ([]model.Paste, error) {
 	log.Debugf("Retrieving %d public pastes starting from paste number %d from database", limit, offset)
 
 	query := `
-		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
 		FROM pastes
 		WHERE visibility >= $1
 		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
 		ORDER BY time_created DESC, id ASC
 		LIMIT $3 OFFSET $4
 		`
@@ -84,26 +254,132 @@ func (store *PasteStore) Search(filter string, limit int64, offset int64) ([]mod
 	log.Debugf("Retrieving %d public pastes starting from paste number %d and matching matching '%s' from database", limit, offset, filter)
 
 	query := `
-		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
 		FROM pastes
 		WHERE visibility >= $1
 		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
+		AND password_hash IS NULL
 		AND tsv @@ plainto_tsquery($3)
 		ORDER BY time_created DESC, id ASC
 		LIMIT $4 OFFSET $5
 		`
 
+	if model.IsSQLite(store.Database) {
+		query = `
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		FROM pastes
+		WHERE visibility >= $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
+		AND password_hash IS NULL
+		AND id IN (SELECT rowid FROM paste_search WHERE paste_search MATCH $3)
+		ORDER BY time_created DESC, id ASC
+		LIMIT $4 OFFSET $5
+		`
+		filter = matchQuery(filter)
+	}
+
+	if model.IsMySQL(store.Database) {
+		query = `
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		FROM pastes
+		WHERE visibility >= $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
+		AND password_hash IS NULL
+		AND MATCH (search_text) AGAINST ($3 IN BOOLEAN MODE)
+		ORDER BY time_created DESC, id ASC
+		LIMIT $4 OFFSET $5
+		`
+		filter = booleanQuery(filter)
+	}
+
 	pastes := []model.Paste{}
 	err := store.Database.Select(&pastes, query, config.VisibilityListed, time.Now().UTC(), filter, limit, offset)
 	return pastes, err
 }
 
+// FindByUser returns a slice of the pastes created by the user with the given id
+// sorted by their creation time. Pastes with a view limit are left out.
+func (store *PasteStore) FindByUser(userID int64, limit int64, offset int64) ([]model.Paste, error) {
+	log.Debugf("Retrieving %d pastes of user %d starting from paste number %d from database", limit, userID, offset)
+
+	query := `
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		FROM pastes
+		WHERE user_id = $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
+		ORDER BY time_created DESC, id ASC
+		LIMIT $3 OFFSET $4
+		`
+
+	pastes := []model.Paste{}
+	err := store.Database.Select(&pastes, query, userID, time.Now().UTC(), limit, offset)
+	return pastes, err
+}
+
+// FindFiles returns the additional files of the paste with the given id. Like
+// FindByID, it leaves out the content of pastes with a view limit.
+func (store *PasteStore) FindFiles(id int64) ([]model.PasteFile, error) {
+	log.Debugf("Retrieving files of paste %d from database", id)
+
+	files, err := store.findFiles(store.Database, id)
+	if err != nil {
+		return files, err
+	}
+
+	var count int64
+	err = store.Database.Get(&count, "SELECT COUNT(*) FROM pastes WHERE id = $1 AND views_left IS NOT NULL", id)
+	if count > 0 {
+		files = filesWithoutContent(files)
+	}
+	return files, err
+}
+
+// FindAttachments returns the attachments of the paste with the given id.
+func (store *PasteStore) FindAttachments(id int64) ([]model.PasteAttachment, error) {
+	log.Debugf("Retrieving attachments of paste %d from database", id)
+
+	return store.findAttachments(store.Database, id)
+}
+
+// FindForks returns the pastes forked from the paste with the given id that
+// have at least the given visibility, sorted by their creation time.
+func (store *PasteStore) FindForks(id int64, visibility config.Visibility) ([]model.Paste, error) {
+	log.Debugf("Retrieving forks of paste %d from database", id)
+
+	query := `
+		SELECT id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		FROM pastes
+		WHERE parent_id = $1
+		AND visibility >= $2
+		AND (time_expires IS NULL OR time_expires > $3)
+		ORDER BY time_created DESC, id ASC
+		`
+
+	pastes := []model.Paste{}
+	err := store.Database.Select(&pastes, query, id, visibility, time.Now().UTC())
+	return pastes, err
+}
+
 // Delete deletes the paste with the given id from the database.
 func (store *PasteStore) Delete(id int64) error {
 	log.Debugf("Deleting paste %d from database", id)
 
-	_, err := store.Database.Exec("DELETE FROM pastes WHERE id = $1", id)
-	return err
+	keys, err := store.blobKeys("id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = store.Database.Exec("DELETE FROM pastes WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	store.deleteBlobs(keys)
+	return nil
 }
 
 // Insert inserts a new paste to the database.
@@ -111,58 +387,944 @@ func (store *PasteStore) Insert(pasteTmpl *model.PasteTemplate) (*model.Paste, e
 	log.Debug("Inserting new paste to database")
 
 	query := `
-		INSERT INTO pastes (time_created, title, raw_content, formatted_content, language, time_expires, visibility, tsv)
-		VALUES ($1, $2, $3, $4, $5, $6, $7,
-			setweight(to_tsvector($2), 'A')
-			|| setweight(to_tsvector(replace($3, '.', ' ')), 'B')
-			|| setweight(to_tsvector('simple', $5), 'C'))
-		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility
+		INSERT INTO pastes (time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, parent_id, views_left, password_hash, kind, filename, content_key, tsv)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $16,
+			CASE WHEN $13 = 0 THEN
+				setweight(to_tsvector($2), 'A')
+				|| setweight(to_tsvector(replace(CASE WHEN $12::text IS NULL THEN $15 ELSE '' END, '.', ' ')), 'B')
+				|| setweight(to_tsvector('simple', $5), 'C')
+			END)
+		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
 		`
 
+	// SQLite keeps the search index in a separate table, see indexSearch
+	if model.IsSQLite(store.Database) {
+		query = `
+		INSERT INTO pastes (time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, parent_id, views_left, password_hash, kind, filename, content_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $16)
+		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		`
+	}
+
+	// MySQL keeps the search index in the search_text column, see indexSearch
+	if model.IsMySQL(store.Database) {
+		query = `
+		INSERT INTO pastes (id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, parent_id, views_left, password_hash, kind, filename, content_key)
+		VALUES ($17, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $16)
+		`
+	}
+
 	paste := new(model.Paste)
 	timeCreated := time.Now().UTC()
 	timeExpires := timeCreated.Add(pasteTmpl.Duration)
-	formatted := fmtutil.FormatCode(pasteTmpl.Language, pasteTmpl.RawContent)
-	err := store.Database.QueryRowx(
-		query,
+
+	// Encrypted pastes are opaque to the server, so they're neither highlighted
+	// nor added to the search index
+	formatted := ""
+	if pasteTmpl.Kind == model.PasteKindText {
+		formatted = fmtutil.FormatCode(pasteTmpl.Language, pasteTmpl.RawContent)
+	}
+
+	var editKeyHash sql.NullString
+	if pasteTmpl.EditKey != "" {
+		editKeyHash.String = auth.HashToken(pasteTmpl.EditKey)
+		editKeyHash.Valid = true
+	}
+
+	// Content of password protected pastes is left out of the search index
+	var passwordHash sql.NullString
+	if pasteTmpl.Password != "" {
+		hash, err := auth.HashPassword(pasteTmpl.Password)
+		if err != nil {
+			return nil, err
+		}
+		passwordHash.String = hash
+		passwordHash.Valid = true
+	}
+
+	// Blobs are stored before the transaction and deleted again if it fails
+	added := []string{}
+	committed := false
+	defer func() {
+		if !committed {
+			store.deleteBlobs(added)
+		}
+	}()
+
+	content, err := store.putContent(pasteTmpl.RawContent, formatted, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := store.putAttachments(pasteTmpl.Attachments, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{
 		timeCreated,
 		pasteTmpl.Title,
-		pasteTmpl.RawContent,
-		formatted,
+		content.Raw,
+		content.Formatted,
 		pasteTmpl.Language,
 		sql.NullTime{Time: timeExpires, Valid: pasteTmpl.Duration > 0},
 		pasteTmpl.Visibility,
-	).StructScan(paste)
+		pasteTmpl.UserID,
+		editKeyHash,
+		pasteTmpl.ParentID,
+		sql.NullInt64{Int64: pasteTmpl.Views, Valid: pasteTmpl.Views > 0},
+		passwordHash,
+		pasteTmpl.Kind,
+		pasteTmpl.Filename,
+		searchContent(pasteTmpl),
+		content.Key,
+	}
 
-	return paste, err
+	if model.IsMySQL(store.Database) {
+		err = insertReturning(tx, paste, query, selectPasteQuery, args...)
+	} else {
+		err = tx.QueryRowx(query, args...).StructScan(paste)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.indexSearch(tx, paste, pasteTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.Files, err = store.insertFiles(tx, paste.ID, pasteTmpl, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.Attachments, err = store.insertAttachments(tx, paste.ID, attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	committed = true
+	paste.RawContent = pasteTmpl.RawContent
+	paste.FormattedContent = formatted
+	return paste, nil
+}
+
+// Update updates the title, files and visibility of an existing paste. The
+// previous title, content and language of the first file are kept as a
+// revision, additional files are replaced.
+func (store *PasteStore) Update(id int64, pasteTmpl *model.PasteTemplate) (*model.Paste, error) {
+	log.Debugf("Updating paste %d in database", id)
+
+	archiveQuery := `
+		INSERT INTO paste_revisions (paste_id, revision, time_created, title, raw_content, language, content_key)
+		SELECT id, revision, COALESCE(time_updated, time_created), title, raw_content, language, content_key
+		FROM pastes
+		WHERE id = $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		FOR UPDATE
+		`
+
+	updateQuery := `
+		UPDATE pastes
+		SET
+			title 				= $2,
+			raw_content 		= $3,
+			formatted_content 	= $4,
+			language 			= $5,
+			visibility 			= $6,
+			revision 			= revision + 1,
+			time_updated 		= $7,
+			filename 			= $8,
+			content_key 		= $10,
+			tsv 				= setweight(to_tsvector($2), 'A')
+								|| setweight(to_tsvector(replace(CASE WHEN password_hash IS NULL THEN $9 ELSE '' END, '.', ' ')), 'B')
+								|| setweight(to_tsvector('simple', $5), 'C')
+		WHERE id = $1
+		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		`
+
+	// SQLite locks the whole database for the transaction and keeps the
+	// search index in a separate table, see indexSearch
+	if model.IsSQLite(store.Database) {
+		archiveQuery = `
+		INSERT INTO paste_revisions (paste_id, revision, time_created, title, raw_content, language, content_key)
+		SELECT id, revision, COALESCE(time_updated, time_created), title, raw_content, language, content_key
+		FROM pastes
+		WHERE id = $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		`
+
+		updateQuery = `
+		UPDATE pastes
+		SET
+			title 				= $2,
+			raw_content 		= $3,
+			formatted_content 	= $4,
+			language 			= $5,
+			visibility 			= $6,
+			revision 			= revision + 1,
+			time_updated 		= $7,
+			filename 			= $8,
+			content_key 		= $10
+		WHERE id = $1
+		RETURNING id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key
+		`
+	}
+
+	if model.IsMySQL(store.Database) {
+		archiveQuery = `
+		INSERT INTO paste_revisions (paste_id, revision, time_created, title, raw_content, language, content_key)
+		SELECT id, revision, COALESCE(time_updated, time_created), title, raw_content, language, content_key
+		FROM pastes
+		WHERE id = $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		`
+
+		updateQuery = `
+		UPDATE pastes
+		SET
+			title 				= $2,
+			raw_content 		= $3,
+			formatted_content 	= $4,
+			language 			= $5,
+			visibility 			= $6,
+			revision 			= revision + 1,
+			time_updated 		= $7,
+			filename 			= $8,
+			content_key 		= $10
+		WHERE id = $1
+		`
+	}
+
+	// Blobs are stored before the transaction and deleted again if it fails
+	added := []string{}
+	committed := false
+	defer func() {
+		if !committed {
+			store.deleteBlobs(added)
+		}
+	}()
+
+	formatted := fmtutil.FormatCode(pasteTmpl.Language, pasteTmpl.RawContent)
+	content, err := store.putContent(pasteTmpl.RawContent, formatted, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := store.putAttachments(pasteTmpl.Attachments, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	timeUpdated := time.Now().UTC()
+	result, err := tx.Exec(archiveQuery, id, timeUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	args := []interface{}{
+		pasteTmpl.Title,
+		content.Raw,
+		content.Formatted,
+		pasteTmpl.Language,
+		pasteTmpl.Visibility,
+		timeUpdated,
+		pasteTmpl.Filename,
+		searchContent(pasteTmpl),
+		content.Key,
+	}
+
+	paste := new(model.Paste)
+	if model.IsMySQL(store.Database) {
+		err = updateReturning(tx, paste, updateQuery, selectPasteQuery, id, args...)
+	} else {
+		err = tx.QueryRowx(updateQuery, append([]interface{}{id}, args...)...).StructScan(paste)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = store.indexSearch(tx, paste, pasteTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files aren't kept in revisions, so their previous content is removed
+	removed := []string{}
+	err = tx.Select(&removed, "SELECT content_key FROM paste_files WHERE paste_id = $1 AND content_key IS NOT NULL", id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM paste_files WHERE paste_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.Files, err = store.insertFiles(tx, id, pasteTmpl, &added)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := store.findAttachments(tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec("DELETE FROM paste_attachments WHERE paste_id = $1", id)
+	if err != nil {
+		return nil, err
+	}
+
+	paste.Attachments, err = store.insertAttachments(tx, id, attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	committed = true
+	paste.RawContent = pasteTmpl.RawContent
+	paste.FormattedContent = formatted
+	store.deleteBlobs(append(removed, removedAttachments(previous, paste.Attachments)...))
+	return paste, nil
 }
 
-func (store *PasteStore) createTable() {
-	log.Debug("Creating table 'pastes'")
+// FindRevisions returns all revisions of the paste with the given id, newest first.
+// The current content of the paste is included as the latest revision.
+func (store *PasteStore) FindRevisions(id int64) ([]model.PasteRevision, error) {
+	log.Debugf("Retrieving revisions of paste %d from database", id)
 
 	query := `
-		CREATE SEQUENCE IF NOT EXISTS pastes_id_seq AS bigint;
+		SELECT r.paste_id, r.revision, r.time_created, r.title, r.raw_content, r.language, r.content_key
+		FROM paste_revisions r
+		JOIN pastes p ON p.id = r.paste_id
+		WHERE r.paste_id = $1
+		AND (p.time_expires IS NULL OR p.time_expires > $2)
+		AND p.views_left IS NULL
+		UNION ALL
+		SELECT id, revision, COALESCE(time_updated, time_created), title, raw_content, language, content_key
+		FROM pastes
+		WHERE id = $1
+		AND (time_expires IS NULL OR time_expires > $2)
+		AND views_left IS NULL
+		ORDER BY revision DESC
+		`
 
-		CREATE TABLE IF NOT EXISTS pastes (
-			id bigint PRIMARY KEY DEFAULT pseudo_encrypt(nextval('pastes_id_seq')),
-			time_created timestamptz NOT NULL,
-			title text NOT NULL,
-			raw_content text NOT NULL,
-			formatted_content text NOT NULL,
-			language text NOT NULL,
-			time_expires timestamptz,
-			visibility int NOT NULL,
-			tsv TSVECTOR
-		);
+	revisions := []model.PasteRevision{}
+	err := store.Database.Select(&revisions, query, id, time.Now().UTC())
+	if err != nil {
+		return revisions, err
+	}
 
-		ALTER SEQUENCE pastes_id_seq OWNED BY pastes.id;
-		CREATE INDEX IF NOT EXISTS pastes_time_expires_id_idx ON pastes (time_expires, id);
-		CREATE INDEX IF NOT EXISTS pastes_tsv_idx ON pastes USING GIN(tsv)
+	for i := range revisions {
+		err = store.getContent(revisions[i].ContentKey, &revisions[i].RawContent, nil)
+		if err != nil {
+			return revisions, err
+		}
+	}
+	return revisions, nil
+}
+
+// FindRevision returns the given revision of the paste with the given id.
+func (store *PasteStore) FindRevision(id int64, revision int) (*model.PasteRevision, error) {
+	log.Debugf("Retrieving revision %d of paste %d from database", revision, id)
+
+	query := `
+		SELECT r.paste_id, r.revision, r.time_created, r.title, r.raw_content, r.language, r.content_key
+		FROM paste_revisions r
+		JOIN pastes p ON p.id = r.paste_id
+		WHERE r.paste_id = $1
+		AND r.revision = $2
+		AND (p.time_expires IS NULL OR p.time_expires > $3)
+		AND p.views_left IS NULL
+		UNION ALL
+		SELECT id, revision, COALESCE(time_updated, time_created), title, raw_content, language, content_key
+		FROM pastes
+		WHERE id = $1
+		AND revision = $2
+		AND (time_expires IS NULL OR time_expires > $3)
+		AND views_left IS NULL
 		`
-	_, err := store.Database.Exec(query)
+
+	pasteRevision := new(model.PasteRevision)
+	err := store.Database.Get(pasteRevision, query, id, revision, time.Now().UTC())
+	if err != nil {
+		return pasteRevision, err
+	}
+
+	return pasteRevision, store.getContent(pasteRevision.ContentKey, &pasteRevision.RawContent, nil)
+}
+
+// FindIDs returns the ids of all pastes that haven't expired sorted by their
+// creation time. They're limited to the pastes of the given user if it's set
+// and to those created in the given time range, zero times don't limit it.
+func (store *PasteStore) FindIDs(userID sql.NullInt64, since time.Time, until time.Time) ([]int64, error) {
+	log.Debug("Retrieving ids of pastes from database")
+
+	conditions := []string{"(time_expires IS NULL OR time_expires > $1)"}
+	args := []interface{}{time.Now().UTC()}
+	if userID.Valid {
+		args = append(args, userID.Int64)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	if !since.IsZero() {
+		args = append(args, since.UTC())
+		conditions = append(conditions, fmt.Sprintf("time_created >= $%d", len(args)))
+	}
+	if !until.IsZero() {
+		args = append(args, until.UTC())
+		conditions = append(conditions, fmt.Sprintf("time_created < $%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id
+		FROM pastes
+		WHERE %s
+		ORDER BY time_created ASC, id ASC
+		`, strings.Join(conditions, " AND "))
+
+	ids := []int64{}
+	err := store.Database.Select(&ids, query, args...)
+	return ids, err
+}
+
+// Restore inserts a paste read from a backup along with its files,
+// attachments and previous revisions, keeping its id, times and hashes. The
+// content of the attachments must be set. References to a user or paste that
+// doesn't exist are removed. Nothing is changed and false is returned if a
+// paste with the id already exists.
+func (store *PasteStore) Restore(paste *model.Paste, revisions []model.PasteRevision) (bool, error) {
+	log.Debugf("Restoring paste %d to database", paste.ID)
+
+	query := `
+		INSERT INTO pastes (id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key, tsv)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18,
+			CASE WHEN $16 = 0 THEN
+				setweight(to_tsvector($3), 'A')
+				|| setweight(to_tsvector(replace(CASE WHEN $15::text IS NULL THEN $19 ELSE '' END, '.', ' ')), 'B')
+				|| setweight(to_tsvector('simple', $6), 'C')
+			END)
+		`
+
+	// SQLite and MySQL keep the search index elsewhere, see indexSearch
+	if model.IsSQLite(store.Database) || model.IsMySQL(store.Database) {
+		query = `
+		INSERT INTO pastes (id, time_created, title, raw_content, formatted_content, language, time_expires, visibility, user_id, edit_key_hash, revision, time_updated, parent_id, views_left, password_hash, kind, filename, content_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		`
+	}
+
+	revisionQuery := `
+		INSERT INTO paste_revisions (paste_id, revision, time_created, title, raw_content, language, content_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+	exists, err := rowExists(store.Database, "pastes", paste.ID)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if paste.UserID.Valid {
+		paste.UserID.Valid, err = rowExists(store.Database, "users", paste.UserID.Int64)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if paste.ParentID.Valid {
+		paste.ParentID.Valid, err = rowExists(store.Database, "pastes", paste.ParentID.Int64)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	pasteTmpl := pasteTemplate(paste)
+	paste.FormattedContent = ""
+	if paste.Kind == model.PasteKindText {
+		paste.FormattedContent = fmtutil.FormatCode(paste.Language, paste.RawContent)
+	}
+
+	// Blobs are stored before the transaction and deleted again if it fails
+	added := []string{}
+	committed := false
+	defer func() {
+		if !committed {
+			store.deleteBlobs(added)
+		}
+	}()
+
+	content, err := store.putContent(paste.RawContent, paste.FormattedContent, &added)
+	if err != nil {
+		return false, err
+	}
+
+	attachments, err := store.putAttachments(paste.Attachments, &added)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	args := []interface{}{
+		paste.ID,
+		paste.TimeCreated,
+		paste.Title,
+		content.Raw,
+		content.Formatted,
+		paste.Language,
+		paste.TimeExpires,
+		paste.Visibility,
+		paste.UserID,
+		paste.EditKeyHash,
+		paste.Revision,
+		paste.TimeUpdated,
+		paste.ParentID,
+		paste.ViewsLeft,
+		paste.PasswordHash,
+		paste.Kind,
+		paste.Filename,
+		content.Key,
+		searchContent(pasteTmpl),
+	}
+
+	_, err = tx.Exec(query, args...)
 	if err != nil {
-		log.Fatalln("Failed to create table 'pastes':", err)
+		return false, err
 	}
+
+	err = store.indexSearch(tx, paste, pasteTmpl)
+	if err != nil {
+		return false, err
+	}
+
+	paste.Files, err = store.insertFiles(tx, paste.ID, pasteTmpl, &added)
+	if err != nil {
+		return false, err
+	}
+
+	paste.Attachments, err = store.insertAttachments(tx, paste.ID, attachments)
+	if err != nil {
+		return false, err
+	}
+
+	for _, revision := range revisions {
+		revisionContent, err := store.putContent(revision.RawContent, "", &added)
+		if err != nil {
+			return false, err
+		}
+
+		_, err = tx.Exec(revisionQuery, paste.ID, revision.Revision, revision.TimeCreated, revision.Title, revisionContent.Raw, revision.Language, revisionContent.Key)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	committed = true
+	paste.ContentKey = content.Key
+	return true, nil
+}
+
+func (store *PasteStore) findFiles(db sqlx.Queryer, id int64) ([]model.PasteFile, error) {
+	query := `
+		SELECT paste_id, position, filename, raw_content, formatted_content, language, content_key
+		FROM paste_files
+		WHERE paste_id = $1
+		ORDER BY position
+		`
+
+	files := []model.PasteFile{}
+	err := sqlx.Select(db, &files, query, id)
+	return files, err
+}
+
+func (store *PasteStore) insertFiles(tx *sqlx.Tx, id int64, pasteTmpl *model.PasteTemplate, added *[]string) ([]model.PasteFile, error) {
+	query := `
+		INSERT INTO paste_files (paste_id, position, filename, raw_content, formatted_content, language, content_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+	files := formatFiles(id, pasteTmpl)
+	for i := range files {
+		file := &files[i]
+		content, err := store.putContent(file.RawContent, file.FormattedContent, added)
+		if err != nil {
+			return nil, err
+		}
+		file.ContentKey = content.Key
+
+		_, err = tx.Exec(query, file.PasteID, file.Position, file.Filename, content.Raw, content.Formatted, file.Language, content.Key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func (store *PasteStore) findAttachments(db sqlx.Queryer, id int64) ([]model.PasteAttachment, error) {
+	query := `
+		SELECT paste_id, position, filename, content_type, size, blob_key
+		FROM paste_attachments
+		WHERE paste_id = $1
+		ORDER BY position
+		`
+
+	attachments := []model.PasteAttachment{}
+	err := sqlx.Select(db, &attachments, query, id)
+	return attachments, err
+}
+
+// insertAttachments inserts the attachments of a paste, see
+// positionAttachments.
+func (store *PasteStore) insertAttachments(tx *sqlx.Tx, id int64, attachments []model.PasteAttachment) ([]model.PasteAttachment, error) {
+	query := `
+		INSERT INTO paste_attachments (paste_id, position, filename, content_type, size, blob_key)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`
+
+	inserted := positionAttachments(id, attachments)
+	for _, attachment := range inserted {
+		_, err := tx.Exec(query, attachment.PasteID, attachment.Position, attachment.Filename, attachment.ContentType, attachment.Size, attachment.BlobKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return inserted, nil
+}
+
+// LoadAttachment reads the content of the attachment from the blob store.
+func (store *PasteStore) LoadAttachment(attachment *model.PasteAttachment) error {
+	content, err := store.Blobs.Get(attachment.BlobKey)
+	if err != nil {
+		return err
+	}
+
+	attachment.Content = content
+	return nil
+}
+
+// LoadContent reads the content of the paste and its files kept in the blob
+// store. Lists of pastes are returned without it, FindByID loads it.
+func (store *PasteStore) LoadContent(paste *model.Paste) error {
+	err := store.getContent(paste.ContentKey, &paste.RawContent, &paste.FormattedContent)
+	if err != nil {
+		return err
+	}
+
+	for i := range paste.Files {
+		file := &paste.Files[i]
+		err = store.getContent(file.ContentKey, &file.RawContent, &file.FormattedContent)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putContent stores the content in the blob store unless it's kept in the
+// database. The key of the new blob is added to added.
+func (store *PasteStore) putContent(raw string, formatted string, added *[]string) (storedContent, error) {
+	if !store.ExternalContent {
+		return storedContent{Raw: raw, Formatted: formatted}, nil
+	}
+
+	data, err := json.Marshal(blobContent{Raw: raw, Formatted: formatted})
+	if err != nil {
+		return storedContent{}, err
+	}
+
+	key, err := store.putBlob(data, added)
+	return storedContent{Key: sql.NullString{String: key, Valid: true}}, err
+}
+
+// getContent reads the content with the given key from the blob store. The
+// content is left as it is if it's kept in the database.
+func (store *PasteStore) getContent(key sql.NullString, raw *string, formatted *string) error {
+	if !key.Valid {
+		return nil
+	}
+
+	data, err := store.Blobs.Get(key.String)
+	if err != nil {
+		return err
+	}
+
+	var content blobContent
+	err = json.Unmarshal(data, &content)
+	if err != nil {
+		return err
+	}
+
+	*raw = content.Raw
+	if formatted != nil {
+		*formatted = content.Formatted
+	}
+	return nil
+}
+
+// putAttachments stores the content of new attachments in the blob store and
+// returns the attachments with their blob keys set.
+func (store *PasteStore) putAttachments(attachments []model.PasteAttachment, added *[]string) ([]model.PasteAttachment, error) {
+	stored := make([]model.PasteAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		if attachment.BlobKey == "" {
+			key, err := store.putBlob(attachment.Content, added)
+			if err != nil {
+				return nil, err
+			}
+			attachment.BlobKey = key
+		}
+		stored = append(stored, attachment)
+	}
+
+	return stored, nil
+}
+
+// putBlob stores the data in the blob store under a new random key, which is
+// added to added.
+func (store *PasteStore) putBlob(data []byte, added *[]string) (string, error) {
+	key, err := auth.GenerateKey()
+	if err != nil {
+		return "", err
+	}
+
+	err = store.Blobs.Put(key, data)
+	if err != nil {
+		return "", err
+	}
+
+	*added = append(*added, key)
+	return key, nil
+}
+
+// blobKeys returns the keys of all blobs of the pastes matching the condition,
+// which are the content of the pastes, their files and revisions and their
+// attachments.
+func (store *PasteStore) blobKeys(condition string, args ...interface{}) ([]string, error) {
+	query := fmt.Sprintf(`
+		WITH p AS (SELECT id, content_key FROM pastes WHERE %s)
+		SELECT content_key FROM p WHERE content_key IS NOT NULL
+		UNION ALL
+		SELECT f.content_key FROM paste_files f JOIN p ON p.id = f.paste_id WHERE f.content_key IS NOT NULL
+		UNION ALL
+		SELECT r.content_key FROM paste_revisions r JOIN p ON p.id = r.paste_id WHERE r.content_key IS NOT NULL
+		UNION ALL
+		SELECT a.blob_key FROM paste_attachments a JOIN p ON p.id = a.paste_id
+		`, condition)
+
+	keys := []string{}
+	err := store.Database.Select(&keys, query, args...)
+	return keys, err
+}
+
+// deleteBlobs deletes the blobs with the given keys from the blob store.
+// Failures are only logged, the pastes referencing them are already gone.
+func (store *PasteStore) deleteBlobs(keys []string) {
+	for _, key := range keys {
+		err := store.Blobs.Delete(key)
+		if err != nil {
+			log.Errorf("Failed to delete blob %s: %s", key, err)
+		}
+	}
+}
+
+// removedAttachments returns the blob keys of the previous attachments
+// missing from current.
+func removedAttachments(previous []model.PasteAttachment, current []model.PasteAttachment) []string {
+	kept := make(map[string]bool, len(current))
+	for _, attachment := range current {
+		kept[attachment.BlobKey] = true
+	}
+
+	removed := []string{}
+	for _, attachment := range previous {
+		if !kept[attachment.BlobKey] {
+			removed = append(removed, attachment.BlobKey)
+		}
+	}
+	return removed
+}
+
+// indexSearch adds the paste to the search index if SQLite or MySQL is used.
+// Postgres keeps the index in the tsv column, which is set along with the paste.
+func (store *PasteStore) indexSearch(tx *sqlx.Tx, paste *model.Paste, pasteTmpl *model.PasteTemplate) error {
+	// Content of password protected pastes is left out of the search index
+	content := ""
+	if !paste.PasswordHash.Valid {
+		content = searchContent(pasteTmpl)
+	}
+
+	switch {
+	case model.IsSQLite(store.Database):
+		_, err := tx.Exec("DELETE FROM paste_search WHERE rowid = $1", paste.ID)
+		if err != nil || paste.Kind != model.PasteKindText {
+			return err
+		}
+
+		query := `
+		INSERT INTO paste_search (rowid, title, content, language)
+		VALUES ($1, $2, $3, $4)
+		`
+
+		_, err = tx.Exec(query, paste.ID, paste.Title, content, paste.Language)
+		return err
+	case model.IsMySQL(store.Database):
+		search := sql.NullString{}
+		if paste.Kind == model.PasteKindText {
+			search.String = strings.Join([]string{paste.Title, content, paste.Language}, "\n")
+			search.Valid = true
+		}
+
+		_, err := tx.Exec("UPDATE pastes SET search_text = $2 WHERE id = $1", paste.ID, search)
+		return err
+	default:
+		return nil
+	}
+}
+
+// matchQuery converts a search filter to a SQLite full-text query matching
+// all of its words. Every word is quoted, so the filter can't contain any
+// query syntax.
+func matchQuery(filter string) string {
+	words := strings.Fields(filter)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// booleanQuery converts a search filter to a MySQL boolean full-text query
+// requiring all of its words. Every word is quoted, so the filter can't contain
+// any operators.
+func booleanQuery(filter string) string {
+	words := strings.Fields(strings.ReplaceAll(filter, `"`, " "))
+	for i, word := range words {
+		words[i] = `+"` + word + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+// withoutContent removes the content of the paste and its files, including
+// the keys of content kept in the blob store.
+func withoutContent(paste *model.Paste) {
+	paste.RawContent = ""
+	paste.FormattedContent = ""
+	paste.ContentKey = sql.NullString{}
+	paste.Files = filesWithoutContent(paste.Files)
+}
+
+func filesWithoutContent(files []model.PasteFile) []model.PasteFile {
+	for i := range files {
+		files[i].RawContent = ""
+		files[i].FormattedContent = ""
+		files[i].ContentKey = sql.NullString{}
+	}
+	return files
+}
+
+// formatFiles returns the additional files of the paste with their position
+// set and their content highlighted.
+func formatFiles(id int64, pasteTmpl *model.PasteTemplate) []model.PasteFile {
+	files := make([]model.PasteFile, 0, len(pasteTmpl.Files))
+	for i, file := range pasteTmpl.Files {
+		file.PasteID = id
+		file.Position = i + 1
+		if pasteTmpl.Kind == model.PasteKindText {
+			file.FormattedContent = fmtutil.FormatCode(file.Language, file.RawContent)
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// positionAttachments returns the attachments of the paste with their
+// position set and without their content. Attachments keep their position, so
+// links to them stay valid when other attachments are removed. New attachments
+// are added after the existing ones.
+func positionAttachments(id int64, attachments []model.PasteAttachment) []model.PasteAttachment {
+	next := 1
+	for _, attachment := range attachments {
+		if attachment.Position >= next {
+			next = attachment.Position + 1
+		}
+	}
+
+	positioned := make([]model.PasteAttachment, 0, len(attachments))
+	for _, attachment := range attachments {
+		attachment.PasteID = id
+		attachment.Content = nil
+		if attachment.Position == 0 {
+			attachment.Position = next
+			next++
+		}
+		positioned = append(positioned, attachment)
+	}
+	return positioned
+}
+
+// pasteTemplate returns a template with the files of the paste, which is used
+// to format and index them like the files of a new paste.
+func pasteTemplate(paste *model.Paste) *model.PasteTemplate {
+	return &model.PasteTemplate{
+		Title:      paste.Title,
+		Kind:       paste.Kind,
+		Filename:   paste.Filename,
+		RawContent: paste.RawContent,
+		Language:   paste.Language,
+		Files:      paste.Files,
+	}
+}
+
+// searchContent returns the content of all files of the paste to be indexed
+// for searching.
+func searchContent(pasteTmpl *model.PasteTemplate) string {
+	var builder strings.Builder
+	for _, file := range pasteTmpl.AllFiles() {
+		builder.WriteString(file.Filename)
+		builder.WriteString("\n")
+		builder.WriteString(file.RawContent)
+		builder.WriteString("\n")
+	}
+	return builder.String()
 }
 
 func (store *PasteStore) monitorExpired() {
@@ -178,11 +1340,20 @@ func (store *PasteStore) monitorExpired() {
 	}
 }
 
+// deleteExpired deletes expired pastes, and pastes that used up their views
+// but weren't deleted on their last view because the server stopped.
 func (store *PasteStore) deleteExpired() (int64, error) {
-	result, err := store.Database.Exec("DELETE FROM pastes WHERE time_expires <= $1", time.Now().UTC())
-	count, err := result.RowsAffected()
+	now := time.Now().UTC()
+	keys, err := store.blobKeys("time_expires <= $1 OR views_left = 0", now)
 	if err != nil {
 		return 0, err
 	}
-	return count, nil
+
+	result, err := store.Database.Exec("DELETE FROM pastes WHERE time_expires <= $1 OR views_left = 0", now)
+	if err != nil {
+		return 0, err
+	}
+
+	store.deleteBlobs(keys)
+	return result.RowsAffected()
 }
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+)
+
+// PasteRepository is a repository of pastes, which is either backed by a
+// database or kept in memory. Pastes that don't exist or have expired aren't
+// found and sql.ErrNoRows is returned.
+type PasteRepository interface {
+	Count() int64
+	FindByID(id int64) (*model.Paste, error)
+	View(id int64) (*model.Paste, error)
+	FindRange(limit int64, offset int64) ([]model.Paste, error)
+	Search(filter string, limit int64, offset int64) ([]model.Paste, error)
+	FindByUser(userID int64, limit int64, offset int64) ([]model.Paste, error)
+	FindFiles(id int64) ([]model.PasteFile, error)
+	FindAttachments(id int64) ([]model.PasteAttachment, error)
+	FindForks(id int64, visibility config.Visibility) ([]model.Paste, error)
+	FindRevisions(id int64) ([]model.PasteRevision, error)
+	FindRevision(id int64, revision int) (*model.PasteRevision, error)
+	Delete(id int64) error
+	Insert(pasteTmpl *model.PasteTemplate) (*model.Paste, error)
+	Update(id int64, pasteTmpl *model.PasteTemplate) (*model.Paste, error)
+	LoadContent(paste *model.Paste) error
+	LoadAttachment(attachment *model.PasteAttachment) error
+}
+
+// UserRepository is a repository of users, which is either backed by a
+// database or kept in memory. Users that don't exist aren't found and
+// sql.ErrNoRows is returned.
+type UserRepository interface {
+	Count() int64
+	FindByID(id int64) (*model.User, error)
+	FindByUID(uid string) (*model.User, error)
+	FindByEmail(email string) (*model.User, error)
+	FindByOIDC(issuer string, subject string) (*model.User, error)
+	FindRange(limit int64, offset int64) ([]model.User, error)
+	Delete(id int64) error
+	Insert(userTmpl *model.UserTemplate) (*model.User, error)
+	Update(userTmpl *model.UserTemplate) (*model.User, error)
+	EnableTOTP(id int64, secret string, codeHashes []string) error
+	DisableTOTP(id int64) error
+	UseTOTPStep(id int64, step int64) (bool, error)
+	UseRecoveryCode(id int64, codeHash string) (bool, error)
+	FindRecoveryCodes(id int64) ([]string, error)
+}
+
+// TokenRepository is a repository of personal API tokens, which is either
+// backed by a database or kept in memory.
+type TokenRepository interface {
+	FindByUser(userID int64) ([]model.APIToken, error)
+	FindByToken(token string) (*model.APIToken, error)
+	Delete(id int64, userID int64) (int64, error)
+	Insert(userID int64, name string) (*model.APIToken, string, error)
+}
+
+// AuditRepository is a repository of audit log entries, which is either
+// backed by a database or kept in memory.
+type AuditRepository interface {
+	FindRange(limit int64, offset int64) ([]model.AuditEntry, error)
+	Insert(entry *model.AuditEntry) error
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+)
+
+var (
+	s3RequestTimeout = 30 * time.Second
+)
+
+// S3BlobStore is a blob store keeping the content as objects in a bucket of
+// an S3 compatible object storage. Requests are signed with AWS Signature
+// Version 4, which is supported by AWS and self-hosted services alike.
+type S3BlobStore struct {
+	Endpoint  *url.URL
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool
+	client    *http.Client
+}
+
+// NewS3BlobStore creates a new S3BlobStore from the storage configuration.
+func NewS3BlobStore() *S3BlobStore {
+	conf := config.Get().Storage.S3
+	log.Debugf("Initializing S3 blob store in bucket '%s' of '%s'", conf.Bucket, conf.Endpoint)
+
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		log.Fatalf("Invalid S3 endpoint '%s'", conf.Endpoint)
+	}
+
+	if conf.Bucket == "" {
+		log.Fatal("S3 bucket required")
+	}
+
+	store := new(S3BlobStore)
+	store.Endpoint = endpoint
+	store.Region = conf.Region
+	store.Bucket = conf.Bucket
+	store.Prefix = conf.Prefix
+	store.AccessKey = conf.AccessKey
+	store.SecretKey = conf.SecretKey
+	store.PathStyle = conf.PathStyle
+	store.client = &http.Client{Timeout: s3RequestTimeout}
+	return store
+}
+
+// Get returns the content stored under the given key.
+func (store *S3BlobStore) Get(key string) ([]byte, error) {
+	log.Debugf("Retrieving blob %s from S3", key)
+
+	resp, err := store.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, s3Error(resp)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Put stores the content under the given key.
+func (store *S3BlobStore) Put(key string, data []byte) error {
+	log.Debugf("Uploading blob %s of %d bytes to S3", key, len(data))
+
+	resp, err := store.do(http.MethodPut, key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Delete deletes the content stored under the given key. Deleting a missing
+// object isn't an error.
+func (store *S3BlobStore) Delete(key string) error {
+	log.Debugf("Deleting blob %s from S3", key)
+
+	resp, err := store.do(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (store *S3BlobStore) do(method string, key string, body []byte) (*http.Response, error) {
+	if !blobKeyPattern.MatchString(key) {
+		return nil, errInvalidBlobKey
+	}
+
+	target := *store.Endpoint
+	object := "/" + escapeS3Path(store.Prefix+key)
+	if store.PathStyle {
+		target.Path = strings.TrimSuffix(target.Path, "/") + "/" + escapeS3Path(store.Bucket) + object
+	} else {
+		target.Host = store.Bucket + "." + target.Host
+		target.Path = strings.TrimSuffix(target.Path, "/") + object
+	}
+	target.RawPath = target.Path
+
+	req, err := http.NewRequest(method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	store.sign(req, body, time.Now().UTC())
+	return store.client.Do(req)
+}
+
+// sign adds the AWS Signature Version 4 headers to the request.
+func (store *S3BlobStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, store.Region)
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+store.SecretKey), date)
+	key = hmacSHA256(key, store.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		store.AccessKey, scope, signedHeaders, signature))
+}
+
+// escapeS3Path escapes a path as required by S3, which leaves slashes and
+// unreserved characters as they are.
+func escapeS3Path(path string) string {
+	var builder strings.Builder
+	for _, b := range []byte(path) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			builder.WriteByte(b)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", b)
+		}
+	}
+	return builder.String()
+}
+
+func s3Error(resp *http.Response) error {
+	message, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("S3 request failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
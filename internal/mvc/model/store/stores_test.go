@@ -0,0 +1,581 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+	"bingo/internal/mvc/model/migration"
+)
+
+// testStores are the repositories of one implementation. Every test of this
+// file runs against the memory stores and the database stores using SQLite,
+// so the implementations behave the same.
+type testStores struct {
+	users  UserRepository
+	tokens TokenRepository
+	pastes PasteRepository
+	audit  AuditRepository
+}
+
+func forEachStore(t *testing.T, test func(t *testing.T, stores testStores)) {
+	t.Run("memory", func(t *testing.T) {
+		config.NewDefaultConfig()
+		memory := NewMemoryDatabase()
+		test(t, testStores{
+			users:  NewMemoryUserStore(memory),
+			tokens: NewMemoryTokenStore(memory),
+			pastes: NewMemoryPasteStore(memory),
+			audit:  NewMemoryAuditStore(memory),
+		})
+	})
+
+	t.Run("sqlite", func(t *testing.T) {
+		conf := config.NewDefaultConfig()
+		conf.Database.Driver = model.DriverSQLite
+		conf.Database.Path = filepath.Join(t.TempDir(), "bingo.db")
+
+		db := model.NewDatabase()
+		t.Cleanup(func() { db.Close() })
+		err := migration.Up(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		test(t, testStores{
+			users:  NewUserStore(db),
+			tokens: NewTokenStore(db),
+			pastes: NewPasteStore(db, NewDatabaseBlobStore(db)),
+			audit:  NewAuditStore(db),
+		})
+	})
+}
+
+func newTestUserTemplate(uid string, role config.Role) *model.UserTemplate {
+	return &model.UserTemplate{
+		UID:      sql.NullString{String: uid, Valid: true},
+		Name:     sql.NullString{String: uid, Valid: true},
+		Email:    sql.NullString{String: uid + "@example.org", Valid: true},
+		Password: sql.NullString{String: uid + "-secret", Valid: true},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthStandard), Valid: true},
+		Role:     sql.NullInt32{Int32: int32(role), Valid: true},
+		Theme:    sql.NullInt32{Int32: int32(config.Get().Theme.Default), Valid: true},
+	}
+}
+
+func insertTestUser(t *testing.T, users UserRepository, uid string, role config.Role) *model.User {
+	user, err := users.Insert(newTestUserTemplate(uid, role))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func insertTestPaste(t *testing.T, pastes PasteRepository, pasteTmpl *model.PasteTemplate) *model.Paste {
+	if pasteTmpl.Language == "" {
+		pasteTmpl.Language = "plaintext"
+	}
+	paste, err := pastes.Insert(pasteTmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paste
+}
+
+func pasteIDs(pastes []model.Paste) []int64 {
+	ids := []int64{}
+	for _, paste := range pastes {
+		ids = append(ids, paste.ID)
+	}
+	return ids
+}
+
+func equalIDs(a []int64, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestUserRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		alice := insertTestUser(t, stores.users, "alice", config.RoleEditor)
+		bob := insertTestUser(t, stores.users, "bob", config.RoleAdmin)
+
+		if !alice.PasswordHash.Valid || alice.PasswordHash.String == "alice-secret" {
+			t.Errorf("expected a hashed password, got %v", alice.PasswordHash)
+		}
+		if !alice.EmailVerified {
+			t.Errorf("expected the email to be verified unless set otherwise")
+		}
+
+		found, err := stores.users.FindByUID("ALICE")
+		if err != nil || found.ID != alice.ID {
+			t.Errorf("expected to find alice by her uid ignoring case, got %+v, %v", found, err)
+		}
+
+		found, err = stores.users.FindByEmail("Bob@Example.org")
+		if err != nil || found.ID != bob.ID {
+			t.Errorf("expected to find bob by his email ignoring case, got %+v, %v", found, err)
+		}
+
+		_, err = stores.users.Insert(newTestUserTemplate("Alice", config.RoleEditor))
+		if err == nil {
+			t.Errorf("expected inserting a duplicate uid to fail")
+		}
+
+		updated, err := stores.users.Update(&model.UserTemplate{
+			ID:   sql.NullInt64{Int64: alice.ID, Valid: true},
+			Name: sql.NullString{String: "Alice Example", Valid: true},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Name != "Alice Example" || updated.Email != alice.Email || updated.PasswordHash != alice.PasswordHash {
+			t.Errorf("expected only the name to change, got %+v", updated)
+		}
+
+		users, err := stores.users.FindRange(10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 2 || users[0].ID != bob.ID || users[1].ID != alice.ID {
+			t.Errorf("expected the admin bob before alice, got %+v", users)
+		}
+
+		err = stores.users.Delete(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stores.users.FindByID(alice.ID)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v for the deleted user, got %v", sql.ErrNoRows, err)
+		}
+		if count := stores.users.Count(); count != 1 {
+			t.Errorf("expected 1 user, got %d", count)
+		}
+	})
+}
+
+func TestUserRepositoryTOTP(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		user := insertTestUser(t, stores.users, "alice", config.RoleEditor)
+
+		err := stores.users.EnableTOTP(user.ID, "secret", []string{"hash-b", "hash-a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		codes, err := stores.users.FindRecoveryCodes(user.ID)
+		if err != nil || len(codes) != 2 || codes[0] != "hash-a" || codes[1] != "hash-b" {
+			t.Errorf("expected the sorted recovery codes, got %v, %v", codes, err)
+		}
+
+		for _, step := range []struct {
+			step int64
+			used bool
+		}{{5, true}, {5, false}, {4, false}, {6, true}} {
+			used, err := stores.users.UseTOTPStep(user.ID, step.step)
+			if err != nil || used != step.used {
+				t.Errorf("step %d: expected %t, got %t, %v", step.step, step.used, used, err)
+			}
+		}
+
+		for _, expected := range []bool{true, false} {
+			used, err := stores.users.UseRecoveryCode(user.ID, "hash-a")
+			if err != nil || used != expected {
+				t.Errorf("expected using the recovery code to return %t, got %t, %v", expected, used, err)
+			}
+		}
+
+		err = stores.users.DisableTOTP(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := stores.users.FindByID(user.ID)
+		if err != nil || found.HasTOTP() || found.TOTPStep.Valid {
+			t.Errorf("expected two-factor authentication to be disabled, got %+v, %v", found, err)
+		}
+		codes, err = stores.users.FindRecoveryCodes(user.ID)
+		if err != nil || len(codes) != 0 {
+			t.Errorf("expected no recovery codes, got %v, %v", codes, err)
+		}
+	})
+}
+
+func TestUserRepositoryOIDC(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		userTmpl := newTestUserTemplate("alice", config.RoleEditor)
+		userTmpl.Password = sql.NullString{}
+		userTmpl.AuthMode = sql.NullInt32{Int32: int32(config.AuthOIDC), Valid: true}
+		userTmpl.OIDCIssuer = sql.NullString{String: "https://id.example.org", Valid: true}
+		userTmpl.OIDCSubject = sql.NullString{String: "Subject", Valid: true}
+		user, err := stores.users.Insert(userTmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		insertTestUser(t, stores.users, "bob", config.RoleEditor)
+
+		found, err := stores.users.FindByOIDC("https://id.example.org", "Subject")
+		if err != nil || found.ID != user.ID || found.OIDCSubject.String != "Subject" {
+			t.Errorf("expected to find alice by her subject, got %+v, %v", found, err)
+		}
+
+		for _, key := range [][2]string{{"https://id.example.org", "subject"}, {"https://other.example.org", "Subject"}, {"", ""}} {
+			_, err = stores.users.FindByOIDC(key[0], key[1])
+			if err != sql.ErrNoRows {
+				t.Errorf("expected %v for subject '%s' of '%s', got %v", sql.ErrNoRows, key[1], key[0], err)
+			}
+		}
+
+		userTmpl.UID = sql.NullString{String: "alice2", Valid: true}
+		_, err = stores.users.Insert(userTmpl)
+		if err == nil {
+			t.Errorf("expected inserting a duplicate subject to fail")
+		}
+	})
+}
+
+func TestTokenRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		alice := insertTestUser(t, stores.users, "alice", config.RoleEditor)
+		bob := insertTestUser(t, stores.users, "bob", config.RoleEditor)
+
+		token, plain, err := stores.tokens.Insert(alice.ID, "laptop")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.TokenHash == plain || token.Prefix != plain[:len(token.Prefix)] {
+			t.Errorf("expected a hashed token with a prefix of the plain token, got %+v", token)
+		}
+
+		found, err := stores.tokens.FindByToken(plain)
+		if err != nil || found.ID != token.ID || !found.TimeLastUsed.Valid {
+			t.Errorf("expected to find the token and mark it used, got %+v, %v", found, err)
+		}
+
+		_, err = stores.tokens.FindByToken(plain + "x")
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v for an unknown token, got %v", sql.ErrNoRows, err)
+		}
+
+		_, _, err = stores.tokens.Insert(-1, "missing")
+		if err == nil {
+			t.Errorf("expected inserting a token of a missing user to fail")
+		}
+
+		deleted, err := stores.tokens.Delete(token.ID, bob.ID)
+		if err != nil || deleted != 0 {
+			t.Errorf("expected the token of another user to be kept, got %d, %v", deleted, err)
+		}
+
+		tokens, err := stores.tokens.FindByUser(alice.ID)
+		if err != nil || len(tokens) != 1 {
+			t.Errorf("expected 1 token, got %+v, %v", tokens, err)
+		}
+
+		err = stores.users.Delete(alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens, err = stores.tokens.FindByUser(alice.ID)
+		if err != nil || len(tokens) != 0 {
+			t.Errorf("expected the tokens of the deleted user to be deleted, got %+v, %v", tokens, err)
+		}
+	})
+}
+
+func TestAuditRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		for _, event := range []string{model.AuditAccountLocked, model.AuditAccountUnlocked} {
+			err := stores.audit.Insert(&model.AuditEntry{Event: event, UID: "alice", Address: "192.0.2.1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		entries, err := stores.audit.FindRange(10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Event != model.AuditAccountUnlocked || entries[1].Event != model.AuditAccountLocked {
+			t.Errorf("expected the newest entry first, got %+v", entries)
+		}
+
+		entries, err = stores.audit.FindRange(1, 1)
+		if err != nil || len(entries) != 1 || entries[0].Event != model.AuditAccountLocked {
+			t.Errorf("expected the second entry, got %+v, %v", entries, err)
+		}
+	})
+}
+
+func TestPasteRepository(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		user := insertTestUser(t, stores.users, "alice", config.RoleEditor)
+		paste := insertTestPaste(t, stores.pastes, &model.PasteTemplate{
+			Title:      "first",
+			RawContent: "package main",
+			Language:   "Go",
+			Filename:   "main.go",
+			Visibility: config.VisibilityListed,
+			UserID:     sql.NullInt64{Int64: user.ID, Valid: true},
+			Files: []model.PasteFile{
+				{Filename: "README.md", RawContent: "# Readme", Language: "Markdown"},
+			},
+			Attachments: []model.PasteAttachment{
+				{Filename: "image.png", ContentType: "image/png", Size: 3, Content: []byte{1, 2, 3}},
+			},
+		})
+
+		found, err := stores.pastes.FindByID(paste.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.RawContent != "package main" || found.FormattedContent == "" || found.Revision != 1 {
+			t.Errorf("expected the highlighted content of the first revision, got %+v", found)
+		}
+		if len(found.Files) != 1 || found.Files[0].RawContent != "# Readme" || found.Files[0].Position != 1 {
+			t.Errorf("expected the additional file, got %+v", found.Files)
+		}
+		if len(found.Attachments) != 1 || found.Attachments[0].Filename != "image.png" {
+			t.Fatalf("expected the attachment, got %+v", found.Attachments)
+		}
+
+		attachment := found.Attachments[0]
+		err = stores.pastes.LoadAttachment(&attachment)
+		if err != nil || string(attachment.Content) != "\x01\x02\x03" {
+			t.Errorf("expected the content of the attachment, got %v, %v", attachment.Content, err)
+		}
+
+		updated, err := stores.pastes.Update(paste.ID, &model.PasteTemplate{
+			Title:      "second",
+			RawContent: "package other",
+			Language:   "Go",
+			Filename:   "main.go",
+			Visibility: config.VisibilityUnlisted,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Revision != 2 || updated.Title != "second" || len(updated.Files) != 0 || len(updated.Attachments) != 0 {
+			t.Errorf("expected the second revision without files or attachments, got %+v", updated)
+		}
+
+		revisions, err := stores.pastes.FindRevisions(paste.ID)
+		if err != nil || len(revisions) != 2 || revisions[0].Revision != 2 || revisions[1].RawContent != "package main" {
+			t.Errorf("expected both revisions newest first, got %+v, %v", revisions, err)
+		}
+
+		revision, err := stores.pastes.FindRevision(paste.ID, 1)
+		if err != nil || revision.Title != "first" {
+			t.Errorf("expected the first revision, got %+v, %v", revision, err)
+		}
+		_, err = stores.pastes.FindRevision(paste.ID, 3)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v for a missing revision, got %v", sql.ErrNoRows, err)
+		}
+
+		err = stores.users.Delete(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err = stores.pastes.FindByID(paste.ID)
+		if err != nil || found.UserID.Valid {
+			t.Errorf("expected the paste to be kept without an owner, got %+v, %v", found, err)
+		}
+
+		err = stores.pastes.Delete(paste.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stores.pastes.FindByID(paste.ID)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v for the deleted paste, got %v", sql.ErrNoRows, err)
+		}
+		if err := stores.pastes.LoadAttachment(&attachment); err == nil {
+			t.Errorf("expected the attachment to be deleted with the paste")
+		}
+	})
+}
+
+func TestPasteRepositoryLists(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		user := insertTestUser(t, stores.users, "alice", config.RoleEditor)
+		owner := sql.NullInt64{Int64: user.ID, Valid: true}
+
+		older := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "needle in a haystack", Visibility: config.VisibilityListed, UserID: owner})
+		newer := insertTestPaste(t, stores.pastes, &model.PasteTemplate{Title: "Needle", RawContent: "haystack", Visibility: config.VisibilityListed})
+		unlisted := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "needle", Visibility: config.VisibilityUnlisted, UserID: owner})
+		insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "needle", Visibility: config.VisibilityListed, UserID: owner, Views: 1})
+		insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "needle", Visibility: config.VisibilityListed, Password: "secret"})
+		expired := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "needle", Visibility: config.VisibilityListed, Duration: time.Millisecond})
+		time.Sleep(10 * time.Millisecond)
+
+		pastes, err := stores.pastes.FindRange(10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Password protected pastes are listed, but can't be searched
+		if ids := pasteIDs(pastes); len(ids) != 3 || ids[1] != newer.ID || ids[2] != older.ID {
+			t.Errorf("expected the listed pastes newest first, got %v", ids)
+		}
+		for _, paste := range pastes {
+			if len(paste.Files) != 0 || len(paste.Attachments) != 0 {
+				t.Errorf("expected lists without files and attachments, got %+v", paste)
+			}
+		}
+
+		pastes, err = stores.pastes.Search("NEEDLE haystack", 10, 0)
+		if ids := pasteIDs(pastes); err != nil || !equalIDs(ids, []int64{newer.ID, older.ID}) {
+			t.Errorf("expected the listed pastes containing all words, got %v, %v", ids, err)
+		}
+
+		pastes, err = stores.pastes.FindByUser(user.ID, 10, 0)
+		if ids := pasteIDs(pastes); err != nil || !equalIDs(ids, []int64{unlisted.ID, older.ID}) {
+			t.Errorf("expected the pastes of the user without a view limit, got %v, %v", ids, err)
+		}
+
+		pastes, err = stores.pastes.FindByUser(user.ID, 1, 1)
+		if ids := pasteIDs(pastes); err != nil || !equalIDs(ids, []int64{older.ID}) {
+			t.Errorf("expected the second paste of the user, got %v, %v", ids, err)
+		}
+
+		_, err = stores.pastes.FindByID(expired.ID)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v for the expired paste, got %v", sql.ErrNoRows, err)
+		}
+	})
+}
+
+func TestPasteRepositoryForks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		parent := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "parent", Visibility: config.VisibilityListed})
+		parentID := sql.NullInt64{Int64: parent.ID, Valid: true}
+		listed := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "fork", Visibility: config.VisibilityListed, ParentID: parentID})
+		unlisted := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "fork", Visibility: config.VisibilityUnlisted, ParentID: parentID})
+
+		_, err := stores.pastes.Insert(&model.PasteTemplate{RawContent: "fork", Language: "plaintext", ParentID: sql.NullInt64{Int64: -1, Valid: true}})
+		if err == nil {
+			t.Errorf("expected forking a missing paste to fail")
+		}
+
+		forks, err := stores.pastes.FindForks(parent.ID, config.VisibilityListed)
+		if ids := pasteIDs(forks); err != nil || !equalIDs(ids, []int64{listed.ID}) {
+			t.Errorf("expected the listed fork, got %v, %v", ids, err)
+		}
+
+		forks, err = stores.pastes.FindForks(parent.ID, config.VisibilityUnlisted)
+		if ids := pasteIDs(forks); err != nil || !equalIDs(ids, []int64{unlisted.ID, listed.ID}) {
+			t.Errorf("expected both forks, got %v, %v", ids, err)
+		}
+
+		err = stores.pastes.Delete(parent.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := stores.pastes.FindByID(listed.ID)
+		if err != nil || found.ParentID.Valid {
+			t.Errorf("expected the fork to be kept without a parent, got %+v, %v", found, err)
+		}
+	})
+}
+
+func TestPasteRepositoryView(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		paste := insertTestPaste(t, stores.pastes, &model.PasteTemplate{
+			RawContent: "secret",
+			Visibility: config.VisibilityListed,
+			Views:      2,
+			Files:      []model.PasteFile{{Filename: "more.txt", RawContent: "more secrets", Language: "plaintext"}},
+		})
+
+		found, err := stores.pastes.FindByID(paste.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.RawContent != "" || found.FormattedContent != "" || len(found.Files) != 1 || found.Files[0].RawContent != "" {
+			t.Errorf("expected the paste without content until it's viewed, got %+v", found)
+		}
+
+		files, err := stores.pastes.FindFiles(paste.ID)
+		if err != nil || len(files) != 1 || files[0].RawContent != "" {
+			t.Errorf("expected the files without content, got %+v, %v", files, err)
+		}
+
+		revisions, err := stores.pastes.FindRevisions(paste.ID)
+		if err != nil || len(revisions) != 0 {
+			t.Errorf("expected no revisions of a paste with a view limit, got %+v, %v", revisions, err)
+		}
+
+		for _, viewsLeft := range []int64{1, 0} {
+			viewed, err := stores.pastes.View(paste.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if viewed.RawContent != "secret" || len(viewed.Files) != 1 || viewed.Files[0].RawContent != "more secrets" {
+				t.Errorf("expected the content of the viewed paste, got %+v", viewed)
+			}
+			if viewed.ViewsLeft.Int64 != viewsLeft {
+				t.Errorf("expected %d views left, got %d", viewsLeft, viewed.ViewsLeft.Int64)
+			}
+		}
+
+		_, err = stores.pastes.View(paste.ID)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v after the last view, got %v", sql.ErrNoRows, err)
+		}
+		_, err = stores.pastes.FindByID(paste.ID)
+		if err != sql.ErrNoRows {
+			t.Errorf("expected %v after the last view, got %v", sql.ErrNoRows, err)
+		}
+
+		unlimited := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "public", Visibility: config.VisibilityListed})
+		for i := 0; i < 2; i++ {
+			viewed, err := stores.pastes.View(unlimited.ID)
+			if err != nil || viewed.RawContent != "public" || viewed.ViewsLeft.Valid {
+				t.Errorf("expected views of a paste without a limit not to be counted, got %+v, %v", viewed, err)
+			}
+		}
+	})
+}
+
+func TestPasteRepositoryViewConcurrently(t *testing.T) {
+	forEachStore(t, func(t *testing.T, stores testStores) {
+		paste := insertTestPaste(t, stores.pastes, &model.PasteTemplate{RawContent: "secret", Visibility: config.VisibilityListed, Views: 5})
+
+		var wait sync.WaitGroup
+		results := make(chan error, 20)
+		for i := 0; i < cap(results); i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				_, err := stores.pastes.View(paste.ID)
+				results <- err
+			}()
+		}
+		wait.Wait()
+		close(results)
+
+		viewed := 0
+		for err := range results {
+			if err == nil {
+				viewed++
+			} else if err != sql.ErrNoRows {
+				t.Errorf("expected %v for views after the last, got %v", sql.ErrNoRows, err)
+			}
+		}
+		if viewed != 5 {
+			t.Errorf("expected 5 views, got %d", viewed)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"time"
+
+	"bingo/internal/mvc/model"
+	"bingo/internal/util/auth"
+	"bingo/internal/util/log"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// TokenStore is the store for personal API tokens.
+type TokenStore struct {
+	Database *sqlx.DB
+}
+
+// NewTokenStore creates a new TokenStore.
+func NewTokenStore(db *sqlx.DB) *TokenStore {
+	log.Debug("Initializing token store")
+	store := new(TokenStore)
+	store.Database = db
+	return store
+}
+
+// FindByUser returns all tokens of the given user sorted by their creation time.
+func (store *TokenStore) FindByUser(userID int64) ([]model.APIToken, error) {
+	log.Debugf("Retrieving tokens of user %d from database", userID)
+
+	query := `
+		SELECT id, user_id, time_created, time_last_used, name, prefix, token_hash
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY time_created DESC, id ASC
+		`
+
+	tokens := []model.APIToken{}
+	err := store.Database.Select(&tokens, query, userID)
+	return tokens, err
+}
+
+// FindByToken returns the token matching the given plain-text token and marks it as used.
+func (store *TokenStore) FindByToken(token string) (*model.APIToken, error) {
+	log.Debug("Retrieving token from database")
+
+	query := `
+		UPDATE api_tokens
+		SET time_last_used = $2
+		WHERE token_hash = $1
+		RETURNING id, user_id, time_created, time_last_used, name, prefix, token_hash
+		`
+
+	apiToken := new(model.APIToken)
+	if model.IsMySQL(store.Database) {
+		_, err := store.Database.Exec("UPDATE api_tokens SET time_last_used = $2 WHERE token_hash = $1", auth.HashToken(token), time.Now().UTC())
+		if err != nil {
+			return apiToken, err
+		}
+
+		query = `
+		SELECT id, user_id, time_created, time_last_used, name, prefix, token_hash
+		FROM api_tokens
+		WHERE token_hash = $1
+		`
+	}
+
+	err := store.Database.Get(apiToken, query, auth.HashToken(token), time.Now().UTC())
+	return apiToken, err
+}
+
+// Delete deletes the token with the given id of the given user from the database.
+func (store *TokenStore) Delete(id int64, userID int64) (int64, error) {
+	log.Debugf("Deleting token %d from database", id)
+
+	result, err := store.Database.Exec("DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Insert creates a new token for the given user. The plain-text token is only
+// returned here and can't be recovered later.
+func (store *TokenStore) Insert(userID int64, name string) (*model.APIToken, string, error) {
+	log.Debugf("Inserting new token for user %d to database", userID)
+
+	query := `
+		INSERT INTO api_tokens (user_id, time_created, name, prefix, token_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, user_id, time_created, time_last_used, name, prefix, token_hash
+		`
+
+	token, err := auth.GenerateToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	args := []interface{}{
+		userID,
+		time.Now().UTC(),
+		name,
+		token[:auth.TokenDisplayLength],
+		auth.HashToken(token),
+	}
+
+	apiToken := new(model.APIToken)
+	if model.IsMySQL(store.Database) {
+		query = `
+		INSERT INTO api_tokens (id, user_id, time_created, name, prefix, token_hash)
+		VALUES ($6, $1, $2, $3, $4, $5)
+		`
+		selectQuery := `
+		SELECT id, user_id, time_created, time_last_used, name, prefix, token_hash
+		FROM api_tokens
+		WHERE id = $1
+		`
+		err = insertReturning(store.Database, apiToken, query, selectQuery, args...)
+		return apiToken, token, err
+	}
+
+	err = store.Database.QueryRowx(query, args...).StructScan(apiToken)
+	return apiToken, token, err
+}
+
+// Restore inserts a token read from a backup as it is, keeping its id and
+// hash. Nothing is changed and false is returned if a token with the id
+// already exists.
+func (store *TokenStore) Restore(token *model.APIToken) (bool, error) {
+	log.Debugf("Restoring token %d to database", token.ID)
+
+	query := `
+		INSERT INTO api_tokens (id, user_id, time_created, time_last_used, name, prefix, token_hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+
+	exists, err := rowExists(store.Database, "api_tokens", token.ID)
+	if err != nil || exists {
+		return false, err
+	}
+
+	_, err = store.Database.Exec(query, token.ID, token.UserID, token.TimeCreated, token.TimeLastUsed, token.Name, token.Prefix, token.TokenHash)
+	return err == nil, err
+}
@@ -21,7 +21,6 @@ func NewUserStore(db *sqlx.DB) *UserStore {
 	log.Debug("Initializing user store")
 	store := new(UserStore)
 	store.Database = db
-	store.createTable()
 	return store
 }
 
@@ -37,7 +36,7 @@ func (store *UserStore) FindByID(id int64) (*model.User, error) {
 	log.Debugf("Retrieving user %d from database", id)
 
 	query := `
-		SELECT id, time_created, uid, name, email, password_hash, auth_mode, role, theme
+		SELECT id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject
 		FROM users
 		WHERE id = $1
 		`
@@ -52,7 +51,7 @@ func (store *UserStore) FindByUID(uid string) (*model.User, error) {
 	log.Debugf("Retrieving user with name '%s' from database", uid)
 
 	query := `
-		SELECT id, time_created, uid, name, email, password_hash, auth_mode, role, theme
+		SELECT id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject
 		FROM users
 		WHERE lower(uid) = lower($1)
 		`
@@ -67,9 +66,9 @@ func (store *UserStore) FindByEmail(email string) (*model.User, error) {
 	log.Debugf("Retrieving user with mail '%s' from database", email)
 
 	query := `
-		SELECT id, time_created, uid, name, email, password_hash, auth_mode, role, theme
+		SELECT id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject
 		FROM users
-		WHERE email ILIKE $1
+		WHERE lower(email) = lower($1)
 		`
 
 	user := new(model.User)
@@ -77,12 +76,28 @@ func (store *UserStore) FindByEmail(email string) (*model.User, error) {
 	return user, err
 }
 
+// FindByOIDC returns the user with the given OpenID Connect issuer and subject
+// from the database.
+func (store *UserStore) FindByOIDC(issuer string, subject string) (*model.User, error) {
+	log.Debugf("Retrieving user with OpenID Connect subject '%s' of '%s' from database", subject, issuer)
+
+	query := `
+		SELECT id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject
+		FROM users
+		WHERE oidc_issuer = $1 AND oidc_subject = $2
+		`
+
+	user := new(model.User)
+	err := store.Database.Get(user, query, issuer, subject)
+	return user, err
+}
+
 // FindRange returns a slice of public users sorted by their creation time.
 func (store *UserStore) FindRange(limit int64, offset int64) ([]model.User, error) {
 	log.Debugf("Retrieving %d public users starting from user number %d from database", limit, offset)
 
 	query := `
-		SELECT id, time_created, uid, name, email, password_hash, auth_mode, role, theme
+		SELECT id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject
 		FROM users
 		ORDER BY role DESC, name ASC, id ASC
 		LIMIT $1 OFFSET $2
@@ -115,19 +130,27 @@ func (store *UserStore) Insert(userTmpl *model.UserTemplate) (*model.User, error
 				password_hash,
 				auth_mode,
 				role,
-				theme)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				theme,
+				email_verified,
+				oidc_issuer,
+				oidc_subject)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 		RETURNING *
 	`
 
-	passwordHash, err := auth.HashPassword(userTmpl.Password.String)
-	if err != nil {
-		return nil, err
+	// Users authenticated by an external directory don't have a password
+	var passwordHash sql.NullString
+	if userTmpl.Password.Valid {
+		hash, err := auth.HashPassword(userTmpl.Password.String)
+		if err != nil {
+			return nil, err
+		}
+
+		passwordHash.String = hash
+		passwordHash.Valid = true
 	}
 
-	user := new(model.User)
-	err = store.Database.QueryRowx(
-		query,
+	args := []interface{}{
 		time.Now().UTC(),
 		userTmpl.UID,
 		userTmpl.Name,
@@ -136,8 +159,22 @@ func (store *UserStore) Insert(userTmpl *model.UserTemplate) (*model.User, error
 		userTmpl.AuthMode,
 		userTmpl.Role,
 		userTmpl.Theme,
-	).StructScan(user)
+		!userTmpl.EmailVerified.Valid || userTmpl.EmailVerified.Bool,
+		userTmpl.OIDCIssuer,
+		userTmpl.OIDCSubject,
+	}
+
+	user := new(model.User)
+	if model.IsMySQL(store.Database) {
+		query = `
+		INSERT INTO users (id, time_created, uid, name, email, password_hash, auth_mode, role, theme, email_verified, oidc_issuer, oidc_subject)
+		VALUES ($12, $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		`
+		err := insertReturning(store.Database, user, query, "SELECT * FROM users WHERE id = $1", args...)
+		return user, err
+	}
 
+	err := store.Database.QueryRowx(query, args...).StructScan(user)
 	return user, err
 }
 
@@ -155,7 +192,8 @@ func (store *UserStore) Update(userTmpl *model.UserTemplate) (*model.User, error
 			password_hash 		= COALESCE($5, password_hash),
 			auth_mode 			= COALESCE($6, auth_mode),
 			role 				= COALESCE($7, role),
-			theme 				= COALESCE($8, theme)
+			theme 				= COALESCE($8, theme),
+			email_verified		= COALESCE($9, email_verified)
 		WHERE id = $1
 		RETURNING *
 	`
@@ -171,10 +209,7 @@ func (store *UserStore) Update(userTmpl *model.UserTemplate) (*model.User, error
 		passwordHash.Valid = true
 	}
 
-	user := new(model.User)
-	err := store.Database.QueryRowx(
-		query,
-		userTmpl.ID,
+	args := []interface{}{
 		userTmpl.UID,
 		userTmpl.Name,
 		userTmpl.Email,
@@ -182,33 +217,178 @@ func (store *UserStore) Update(userTmpl *model.UserTemplate) (*model.User, error
 		userTmpl.AuthMode,
 		userTmpl.Role,
 		userTmpl.Theme,
-	).StructScan(user)
+		userTmpl.EmailVerified,
+	}
+
+	user := new(model.User)
+	if model.IsMySQL(store.Database) {
+		query = `
+		UPDATE users
+		SET
+			uid 				= COALESCE($2, uid),
+			name 				= COALESCE($3, name),
+			email 				= COALESCE($4, email),
+			password_hash 		= COALESCE($5, password_hash),
+			auth_mode 			= COALESCE($6, auth_mode),
+			role 				= COALESCE($7, role),
+			theme 				= COALESCE($8, theme),
+			email_verified		= COALESCE($9, email_verified)
+		WHERE id = $1
+		`
+		err := updateReturning(store.Database, user, query, "SELECT * FROM users WHERE id = $1", userTmpl.ID.Int64, args...)
+		return user, err
+	}
 
+	err := store.Database.QueryRowx(query, append([]interface{}{userTmpl.ID}, args...)...).StructScan(user)
 	return user, err
 }
 
-func (store *UserStore) createTable() {
+// EnableTOTP enables two-factor authentication for the user with the given
+// secret, replacing their recovery codes with the given hashes.
+func (store *UserStore) EnableTOTP(id int64, secret string, codeHashes []string) error {
+	log.Debugf("Enabling two-factor authentication of user %d in database", id)
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE users SET totp_secret = $2, totp_step = NULL WHERE id = $1", id, secret)
+	if err != nil {
+		return err
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = insertRecoveryCodes(tx, id, codeHashes)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP disables two-factor authentication for the user with the given
+// id and deletes their recovery codes.
+func (store *UserStore) DisableTOTP(id int64) error {
+	log.Debugf("Disabling two-factor authentication of user %d in database", id)
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE users SET totp_secret = NULL, totp_step = NULL WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPStep marks the time step of a code as used. False is returned if a
+// code of the same or a later time step was used before, which means the code
+// is replayed.
+func (store *UserStore) UseTOTPStep(id int64, step int64) (bool, error) {
 	query := `
-		CREATE SEQUENCE IF NOT EXISTS users_id_seq AS bigint;
-
-		CREATE TABLE IF NOT EXISTS users (
-			id				bigint PRIMARY KEY DEFAULT pseudo_encrypt(nextval('users_id_seq')),
-			time_created	timestamptz NOT NULL,
-			uid 			text NOT NULL,
-			name 			text NOT NULL,
-			email 			varchar(254),
-			password_hash	char(60),
-			auth_mode		int NOT NULL,
-			role 			int NOT NULL,
-			theme 			int NOT NULL
-		);
-
-		ALTER SEQUENCE users_id_seq OWNED BY users.id;
-		CREATE UNIQUE INDEX IF NOT EXISTS users_uid_lower_idx ON users(lower(uid));
-	`
+		UPDATE users
+		SET totp_step = $2
+		WHERE id = $1 AND (totp_step IS NULL OR totp_step < $2)
+		`
 
-	_, err := store.Database.Exec(query)
+	result, err := store.Database.Exec(query, id, step)
 	if err != nil {
-		log.Fatalf("Failed to create table 'users': %s", err)
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// UseRecoveryCode deletes the recovery code with the given hash of the user.
+// False is returned if the user doesn't have the code.
+func (store *UserStore) UseRecoveryCode(id int64, codeHash string) (bool, error) {
+	log.Debugf("Using recovery code of user %d in database", id)
+
+	result, err := store.Database.Exec("DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2", id, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	count, err := result.RowsAffected()
+	return count > 0, err
+}
+
+// FindRecoveryCodes returns the hashes of the unused recovery codes of the
+// user with the given id.
+func (store *UserStore) FindRecoveryCodes(id int64) ([]string, error) {
+	log.Debugf("Retrieving recovery codes of user %d from database", id)
+
+	codeHashes := []string{}
+	err := store.Database.Select(&codeHashes, "SELECT code_hash FROM recovery_codes WHERE user_id = $1 ORDER BY code_hash", id)
+	return codeHashes, err
+}
+
+// Restore inserts a user read from a backup as it is, keeping its id, password
+// hash and two-factor authentication along with the hashes of their recovery
+// codes. Nothing is changed and false is returned if a user with the id
+// already exists.
+func (store *UserStore) Restore(user *model.User, codeHashes []string) (bool, error) {
+	log.Debugf("Restoring user %d to database", user.ID)
+
+	query := `
+		INSERT INTO users (id, time_created, uid, name, email, email_verified, password_hash, auth_mode, role, theme, totp_secret, totp_step, oidc_issuer, oidc_subject)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`
+
+	exists, err := rowExists(store.Database, "users", user.ID)
+	if err != nil || exists {
+		return false, err
+	}
+
+	tx, err := store.Database.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(query, user.ID, user.TimeCreated, user.UID, user.Name, user.Email, user.EmailVerified, user.PasswordHash, user.AuthMode, user.Role, user.Theme, user.TOTPSecret, user.TOTPStep, user.OIDCIssuer, user.OIDCSubject)
+	if err != nil {
+		return false, err
+	}
+
+	err = insertRecoveryCodes(tx, user.ID, codeHashes)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	return err == nil, err
+}
+
+// insertRecoveryCodes replaces the recovery codes of the user with the given
+// hashes.
+func insertRecoveryCodes(tx *sqlx.Tx, id int64, codeHashes []string) error {
+	_, err := tx.Exec("DELETE FROM recovery_codes WHERE user_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	for _, codeHash := range codeHashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)", id, codeHash)
+		if err != nil {
+			return err
+		}
 	}
+	return nil
 }
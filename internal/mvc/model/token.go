@@ -0,0 +1,17 @@
+package model
+
+import (
+	"database/sql"
+	"time"
+)
+
+// APIToken represents a personal token used to authenticate non-browser clients.
+type APIToken struct {
+	ID           int64        `db:"id"`
+	UserID       int64        `db:"user_id"`
+	TimeCreated  time.Time    `db:"time_created"`
+	TimeLastUsed sql.NullTime `db:"time_last_used"`
+	Name         string       `db:"name"`
+	Prefix       string       `db:"prefix"`
+	TokenHash    string       `db:"token_hash"`
+}
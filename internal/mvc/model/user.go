@@ -9,26 +9,54 @@ import (
 
 // User represents an authenticated user.
 type User struct {
-	ID           int64           `db:"id"`
-	TimeCreated  time.Time       `db:"time_created"`
-	UID          string          `db:"uid"`
-	Name         string          `db:"name"`
-	Email        sql.NullString  `db:"email"`
-	PasswordHash sql.NullString  `db:"password_hash"`
-	AuthMode     config.AuthMode `db:"auth_mode"`
-	Role         config.Role     `db:"role"`
-	Theme        config.Theme    `db:"theme"`
+	ID            int64           `db:"id"`
+	TimeCreated   time.Time       `db:"time_created"`
+	UID           string          `db:"uid"`
+	Name          string          `db:"name"`
+	Email         sql.NullString  `db:"email"`
+	EmailVerified bool            `db:"email_verified"`
+	PasswordHash  sql.NullString  `db:"password_hash"`
+	AuthMode      config.AuthMode `db:"auth_mode"`
+	Role          config.Role     `db:"role"`
+	Theme         config.Theme    `db:"theme"`
+	TOTPSecret    sql.NullString  `db:"totp_secret"`
+	TOTPStep      sql.NullInt64   `db:"totp_step"`
+	OIDCIssuer    sql.NullString  `db:"oidc_issuer"`
+	OIDCSubject   sql.NullString  `db:"oidc_subject"`
 }
 
 // UserTemplate represents user changes to be committed to the database.
 type UserTemplate struct {
-	ID          sql.NullInt64
-	TimeCreated sql.NullTime
-	UID         sql.NullString
-	Name        sql.NullString
-	Email       sql.NullString
-	Password    sql.NullString
-	AuthMode    sql.NullInt32
-	Role        sql.NullInt32
-	Theme       sql.NullInt32
+	ID            sql.NullInt64
+	TimeCreated   sql.NullTime
+	UID           sql.NullString
+	Name          sql.NullString
+	Email         sql.NullString
+	EmailVerified sql.NullBool
+	Password      sql.NullString
+	AuthMode      sql.NullInt32
+	Role          sql.NullInt32
+	Theme         sql.NullInt32
+	OIDCIssuer    sql.NullString
+	OIDCSubject   sql.NullString
+}
+
+// HasTOTP returns whether the user has enabled two-factor authentication.
+func (user *User) HasTOTP() bool {
+	return user.TOTPSecret.Valid
+}
+
+// NeedsTOTP returns whether the user has to enable two-factor authentication
+// before they can log in. Only admins using standard authentication can be
+// required to.
+func (user *User) NeedsTOTP() bool {
+	conf := config.Get().Authentication.Standard.TOTP
+	return conf.RequireForAdmins && user.Role == config.RoleAdmin && user.AuthMode == config.AuthStandard && !user.HasTOTP()
+}
+
+// CanLogin returns whether the user is allowed to log in, which they aren't
+// until they verified their email address if verification is required.
+func (user *User) CanLogin() bool {
+	conf := config.Get()
+	return user.EmailVerified || !conf.SMTP.Enabled || !conf.Authentication.Standard.VerifyEmail
 }
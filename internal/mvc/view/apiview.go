@@ -0,0 +1,125 @@
+package view
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"bingo/internal/mvc/model"
+)
+
+// PasteJSON represents a paste serialized for the JSON API.
+type PasteJSON struct {
+	ID          int64                 `json:"id"`
+	URL         string                `json:"url"`
+	RawURL      string                `json:"raw_url"`
+	TimeCreated time.Time             `json:"time_created"`
+	TimeExpires *time.Time            `json:"time_expires"`
+	Title       string                `json:"title"`
+	Language    string                `json:"language"`
+	Visibility  string                `json:"visibility"`
+	Revision    int                   `json:"revision"`
+	ViewsLeft   *int64                `json:"views_left"`
+	Protected   bool                  `json:"protected"`
+	Encrypted   bool                  `json:"encrypted"`
+	Content     string                `json:"content,omitempty"`
+	Files       []PasteFileJSON       `json:"files,omitempty"`
+	Attachments []PasteAttachmentJSON `json:"attachments,omitempty"`
+	EditKey     string                `json:"edit_key,omitempty"`
+}
+
+// PasteFileJSON represents a single file of a paste with multiple files
+// serialized for the JSON API.
+type PasteFileJSON struct {
+	Filename string `json:"filename"`
+	Language string `json:"language"`
+	RawURL   string `json:"raw_url"`
+	Content  string `json:"content"`
+}
+
+// PasteAttachmentJSON represents a file attached to a paste serialized for the
+// JSON API.
+type PasteAttachmentJSON struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+// PasteListJSON represents a list of pastes serialized for the JSON API.
+type PasteListJSON struct {
+	Pastes []PasteJSON `json:"pastes"`
+	Limit  int64       `json:"limit"`
+	Offset int64       `json:"offset"`
+}
+
+// NewPasteJSON creates a new PasteJSON. Content is only included if requested
+// to keep listings small.
+func NewPasteJSON(paste *model.Paste, withContent bool) PasteJSON {
+	result := PasteJSON{
+		ID:          paste.ID,
+		URL:         pasteURL(paste.ID),
+		RawURL:      pasteURL(paste.ID) + "/raw",
+		TimeCreated: paste.TimeCreated,
+		Title:       paste.Title,
+		Language:    paste.Language,
+		Visibility:  strings.ToLower(paste.Visibility.String()),
+		Revision:    paste.Revision,
+		Protected:   paste.PasswordHash.Valid,
+		Encrypted:   paste.IsEncrypted(),
+	}
+
+	if paste.TimeExpires.Valid {
+		result.TimeExpires = &paste.TimeExpires.Time
+	}
+
+	if paste.ViewsLeft.Valid {
+		result.ViewsLeft = &paste.ViewsLeft.Int64
+	}
+
+	if withContent {
+		result.Content = paste.RawContent
+	}
+
+	if withContent && len(paste.Files) > 0 {
+		for _, file := range paste.AllFiles() {
+			result.Files = append(result.Files, PasteFileJSON{
+				Filename: file.Filename,
+				Language: file.Language,
+				RawURL:   result.URL + "/raw/" + url.PathEscape(file.Filename),
+				Content:  file.RawContent,
+			})
+		}
+	}
+
+	for _, attachment := range paste.Attachments {
+		result.Attachments = append(result.Attachments, PasteAttachmentJSON{
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			URL:         fmt.Sprintf("%s/attachments/%d", result.URL, attachment.Position),
+		})
+	}
+
+	return result
+}
+
+// NewPasteListJSON creates a new PasteListJSON.
+func NewPasteListJSON(pastes []model.Paste, limit int64, offset int64) PasteListJSON {
+	result := PasteListJSON{
+		Pastes: make([]PasteJSON, 0, len(pastes)),
+		Limit:  limit,
+		Offset: offset,
+	}
+
+	for i := range pastes {
+		result.Pastes = append(result.Pastes, NewPasteJSON(&pastes[i], false))
+	}
+
+	return result
+}
+
+func pasteURL(id int64) string {
+	return fmt.Sprintf("/pastes/%d", id)
+}
@@ -1,11 +1,43 @@
 package view
 
-import "net/http"
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+)
 
 // AuthView represents the view used to render errors.
 type AuthView struct {
 	Login    *Page
 	Register *Page
+	TOTP     *Page
+	Enroll   *Page
+	Recovery *Page
+	Forgot   *Page
+	Reset    *Page
+}
+
+// EnrollContext represents a rendering context for the page enabling
+// two-factor authentication.
+type EnrollContext struct {
+	PageContext
+	Action string
+	Secret string
+	QRCode template.URL
+}
+
+// RecoveryContext represents a rendering context for the page showing new
+// recovery codes.
+type RecoveryContext struct {
+	PageContext
+	Codes []string
+}
+
+// ResetContext represents a rendering context for the page resetting a
+// password.
+type ResetContext struct {
+	PageContext
+	Token string
 }
 
 // NewAuthView creates a new AuthView.
@@ -22,9 +54,44 @@ func NewAuthView() *AuthView {
 		"web/css/common/*.css",
 	}
 
+	totpPaths := []string{
+		"web/template/*.go.html",
+		"web/template/auth/totp/*.go.html",
+		"web/css/common/*.css",
+	}
+
+	enrollPaths := []string{
+		"web/template/*.go.html",
+		"web/template/auth/enroll/*.go.html",
+		"web/css/common/*.css",
+	}
+
+	recoveryPaths := []string{
+		"web/template/*.go.html",
+		"web/template/auth/recovery/*.go.html",
+		"web/css/common/*.css",
+	}
+
+	forgotPaths := []string{
+		"web/template/*.go.html",
+		"web/template/auth/forgot/*.go.html",
+		"web/css/common/*.css",
+	}
+
+	resetPaths := []string{
+		"web/template/*.go.html",
+		"web/template/auth/reset/*.go.html",
+		"web/css/common/*.css",
+	}
+
 	v := new(AuthView)
 	v.Login = NewPage("Login", "login", loginPaths)
 	v.Register = NewPage("Register", "register", registerPaths)
+	v.TOTP = NewPage("Two-Factor Authentication", "login/totp", totpPaths)
+	v.Enroll = NewPage("Enable Two-Factor Authentication", "profile/totp", enrollPaths)
+	v.Recovery = NewPage("Recovery Codes", "profile/totp/recovery", recoveryPaths)
+	v.Forgot = NewPage("Forgot Password", "forgot-password", forgotPaths)
+	v.Reset = NewPage("Reset Password", "reset-password", resetPaths)
 	return v
 }
 
@@ -39,3 +106,42 @@ func (v *AuthView) NewRegisterContext(r *http.Request) PageContext {
 	ctx := NewPageContext(r, v.Register)
 	return ctx
 }
+
+// NewTOTPContext creates a new AuthContext.
+func (v *AuthView) NewTOTPContext(r *http.Request) PageContext {
+	ctx := NewPageContext(r, v.TOTP)
+	return ctx
+}
+
+// NewEnrollContext creates a new EnrollContext. The QR code is embedded in
+// the page as a PNG image, so the secret isn't sent anywhere else.
+func (v *AuthView) NewEnrollContext(r *http.Request, action string, secret string, qrCode []byte) EnrollContext {
+	return EnrollContext{
+		Action:      action,
+		Secret:      secret,
+		QRCode:      template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(qrCode)),
+		PageContext: NewPageContext(r, v.Enroll),
+	}
+}
+
+// NewRecoveryContext creates a new RecoveryContext.
+func (v *AuthView) NewRecoveryContext(r *http.Request, codes []string) RecoveryContext {
+	return RecoveryContext{
+		Codes:       codes,
+		PageContext: NewPageContext(r, v.Recovery),
+	}
+}
+
+// NewForgotContext creates a new AuthContext.
+func (v *AuthView) NewForgotContext(r *http.Request) PageContext {
+	ctx := NewPageContext(r, v.Forgot)
+	return ctx
+}
+
+// NewResetContext creates a new ResetContext.
+func (v *AuthView) NewResetContext(r *http.Request, token string) ResetContext {
+	return ResetContext{
+		Token:       token,
+		PageContext: NewPageContext(r, v.Reset),
+	}
+}
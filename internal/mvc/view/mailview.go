@@ -0,0 +1,44 @@
+package view
+
+import (
+	"bytes"
+	"text/template"
+
+	"bingo/internal/config"
+	"bingo/internal/mvc/model"
+)
+
+// MailView represents the view used to render the plain text body of mails.
+type MailView struct {
+	Template *template.Template
+}
+
+// MailContext represents a rendering context for a mail.
+type MailContext struct {
+	Config *config.Config
+	User   *model.User
+	URL    string
+}
+
+// NewMailView creates a new MailView.
+func NewMailView() *MailView {
+	v := new(MailView)
+	v.Template = template.Must(template.ParseGlob("web/template/mail/*.go.txt"))
+	return v
+}
+
+// NewMailContext creates a new MailContext.
+func (v *MailView) NewMailContext(user *model.User, url string) MailContext {
+	return MailContext{
+		Config: config.Get(),
+		User:   user,
+		URL:    url,
+	}
+}
+
+// Render renders the mail with the given name.
+func (v *MailView) Render(name string, ctx MailContext) (string, error) {
+	var buf bytes.Buffer
+	err := v.Template.ExecuteTemplate(&buf, name, ctx)
+	return buf.String(), err
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"time"
 
 	"bingo/internal/config"
@@ -67,6 +68,15 @@ func (page *Page) Render(w http.ResponseWriter, ctx interface{}) error {
 	return err
 }
 
+// RenderStatus renders the page as a HTTP response with the given status code.
+func (page *Page) RenderStatus(w http.ResponseWriter, code int, ctx interface{}) error {
+	err := httpext.WriteTemplateStatus(w, code, page.Template, ctx)
+	if err != nil {
+		log.Errorf("Failed to render page %s: %s", page.Name, err)
+	}
+	return err
+}
+
 func newTemplate(paths []string) *template.Template {
 	tmpl := template.New("index").Funcs(newFuncMap())
 	for _, path := range paths {
@@ -78,8 +88,10 @@ func newTemplate(paths []string) *template.Template {
 func newFuncMap() template.FuncMap {
 	return template.FuncMap{
 		"duration":       duration,
+		"formatByteSize": fmtutil.FormatByteSize,
 		"formatExpiry":   formatExpiry,
 		"formatPastDate": formatPastDate,
+		"pathEscape":     url.PathEscape,
 		"unescape":       unescape,
 	}
 }
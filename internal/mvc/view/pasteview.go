@@ -8,15 +8,47 @@ import (
 
 // PasteView represents the view used to render pastes.
 type PasteView struct {
-	Write *Page
-	List  *Page
-	View  *Page
+	Write     *Page
+	List      *Page
+	View      *Page
+	Revisions *Page
+	Diff      *Page
+	Confirm   *Page
+	Decrypt   *Page
+	Claim     *Page
+}
+
+// WritePasteContext represents a rendering context for the Write Paste page.
+// Paste is nil unless an existing paste is being edited, and Parent is nil
+// unless an existing paste is being forked. Draft is set when the page is
+// shown again after adding or removing a file.
+type WritePasteContext struct {
+	PageContext
+	Paste  *model.Paste
+	Parent *model.Paste
+	Draft  *model.PasteTemplate
+	Title  string
+	Files  []model.PasteFile
 }
 
 // ViewPasteContext represents a rendering context for the View Paste page.
 type ViewPasteContext struct {
 	PageContext
-	Paste *model.Paste
+	Paste   *model.Paste
+	Parent  *model.Paste
+	Forks   []model.Paste
+	CanEdit bool
+}
+
+// ConfirmViewContext represents a rendering context for the page asking the
+// reader for the password of a protected paste, or to confirm viewing a paste
+// with a view limit. Action is appended to the paste URL the form is posted to.
+type ConfirmViewContext struct {
+	PageContext
+	Paste         *model.Paste
+	Action        string
+	Locked        bool
+	WrongPassword bool
 }
 
 // ListPastesContext represents a rendering context for the List Pastes page.
@@ -25,6 +57,23 @@ type ListPastesContext struct {
 	Pastes []model.Paste
 }
 
+// RevisionsContext represents a rendering context for the Paste Revisions page.
+type RevisionsContext struct {
+	PageContext
+	Paste     *model.Paste
+	Revisions []model.PasteRevision
+}
+
+// DiffContext represents a rendering context for the Paste Diff page.
+type DiffContext struct {
+	PageContext
+	Paste         *model.Paste
+	Revisions     []model.PasteRevision
+	From          *model.PasteRevision
+	To            *model.PasteRevision
+	FormattedDiff string
+}
+
 // NewPasteView creates a new ErrorView.
 func NewPasteView() *PasteView {
 	writePaths := []string{
@@ -32,6 +81,7 @@ func NewPasteView() *PasteView {
 		"web/template/paste/write/*.go.html",
 		"web/css/common/*.css",
 		"web/css/paste/*.css",
+		"web/js/*.js",
 	}
 
 	listPaths := []string{
@@ -48,26 +98,133 @@ func NewPasteView() *PasteView {
 		"web/css/paste/*.css",
 	}
 
+	revisionsPaths := []string{
+		"web/template/*.go.html",
+		"web/template/paste/revisions/*.go.html",
+		"web/css/common/*.css",
+		"web/css/paste/*.css",
+	}
+
+	diffPaths := []string{
+		"web/template/*.go.html",
+		"web/template/paste/diff/*.go.html",
+		"web/css/common/*.css",
+		"web/css/paste/*.css",
+	}
+
+	confirmPaths := []string{
+		"web/template/*.go.html",
+		"web/template/paste/confirm/*.go.html",
+		"web/css/common/*.css",
+		"web/css/paste/*.css",
+	}
+
+	claimPaths := []string{
+		"web/template/*.go.html",
+		"web/template/paste/claim/*.go.html",
+		"web/css/common/*.css",
+		"web/css/paste/*.css",
+	}
+
+	decryptPaths := []string{
+		"web/template/*.go.html",
+		"web/template/paste/decrypt/*.go.html",
+		"web/css/common/*.css",
+		"web/css/paste/*.css",
+		"web/js/*.js",
+	}
+
 	v := new(PasteView)
 	v.Write = NewPage("Write Paste", "/", writePaths)
 	v.List = NewPage("List Pastes", "/pastes", listPaths)
 	v.View = NewPage("View Paste", "/paste/:id", viewPaths)
+	v.Revisions = NewPage("Paste Revisions", "/paste/:id/revisions", revisionsPaths)
+	v.Diff = NewPage("Paste Diff", "/paste/:id/diff", diffPaths)
+	v.Confirm = NewPage("View Paste", "/paste/:id", confirmPaths)
+	v.Decrypt = NewPage("View Paste", "/paste/:id", decryptPaths)
+	v.Claim = NewPage("Edit Paste", "/paste/:id/claim", claimPaths)
 	return v
 }
 
-// NewWritePasteContext creates a new PageContext for write paste page.
-func (v *PasteView) NewWritePasteContext(r *http.Request) PageContext {
-	return NewPageContext(r, v.Write)
+// NewWritePasteContext creates a new WritePasteContext for creating a paste.
+func (v *PasteView) NewWritePasteContext(r *http.Request) WritePasteContext {
+	return WritePasteContext{
+		Files:       []model.PasteFile{{Language: "plaintext"}},
+		PageContext: NewPageContext(r, v.Write),
+	}
+}
+
+// NewEditPasteContext creates a new WritePasteContext for editing an existing paste.
+func (v *PasteView) NewEditPasteContext(r *http.Request, paste *model.Paste) WritePasteContext {
+	return WritePasteContext{
+		Paste:       paste,
+		Title:       paste.Title,
+		Files:       paste.AllFiles(),
+		PageContext: NewPageContext(r, v.Write),
+	}
+}
+
+// NewForkPasteContext creates a new WritePasteContext for forking an existing paste.
+func (v *PasteView) NewForkPasteContext(r *http.Request, parent *model.Paste) WritePasteContext {
+	return WritePasteContext{
+		Parent:      parent,
+		Title:       parent.Title,
+		Files:       parent.AllFiles(),
+		PageContext: NewPageContext(r, v.Write),
+	}
+}
+
+// NewDraftPasteContext creates a new WritePasteContext for continuing to write
+// a paste after adding or removing a file. Paste and parent are nil unless an
+// existing paste is being edited or forked.
+func (v *PasteView) NewDraftPasteContext(r *http.Request, draft *model.PasteTemplate, paste *model.Paste, parent *model.Paste) WritePasteContext {
+	return WritePasteContext{
+		Paste:       paste,
+		Parent:      parent,
+		Draft:       draft,
+		Title:       draft.Title,
+		Files:       draft.AllFiles(),
+		PageContext: NewPageContext(r, v.Write),
+	}
 }
 
 // NewViewPasteContext creates a new PasteViewerContext.
-func (v *PasteView) NewViewPasteContext(r *http.Request, paste *model.Paste) ViewPasteContext {
+func (v *PasteView) NewViewPasteContext(r *http.Request, paste *model.Paste, canEdit bool) ViewPasteContext {
 	return ViewPasteContext{
 		Paste:       paste,
+		CanEdit:     canEdit,
 		PageContext: NewPageContext(r, v.View),
 	}
 }
 
+// NewDecryptPasteContext creates a new ViewPasteContext for an encrypted paste.
+func (v *PasteView) NewDecryptPasteContext(r *http.Request, paste *model.Paste, canEdit bool) ViewPasteContext {
+	return ViewPasteContext{
+		Paste:       paste,
+		CanEdit:     canEdit,
+		PageContext: NewPageContext(r, v.Decrypt),
+	}
+}
+
+// NewConfirmViewContext creates a new ConfirmViewContext.
+func (v *PasteView) NewConfirmViewContext(r *http.Request, paste *model.Paste, action string, locked bool) ConfirmViewContext {
+	return ConfirmViewContext{
+		Paste:       paste,
+		Action:      action,
+		Locked:      locked,
+		PageContext: NewPageContext(r, v.Confirm),
+	}
+}
+
+// NewClaimPasteContext creates a new ViewPasteContext for the page asking for
+// the edit key of an anonymous paste.
+func (v *PasteView) NewClaimPasteContext(r *http.Request, paste *model.Paste) ViewPasteContext {
+	return ViewPasteContext{
+		Paste:       paste,
+		PageContext: NewPageContext(r, v.Claim),
+	}
+}
+
 // NewListPastesContext creates a new PasteListContext.
 func (v *PasteView) NewListPastesContext(r *http.Request, pastes []model.Paste) ListPastesContext {
 	return ListPastesContext{
@@ -75,3 +232,24 @@ func (v *PasteView) NewListPastesContext(r *http.Request, pastes []model.Paste)
 		PageContext: NewPageContext(r, v.List),
 	}
 }
+
+// NewRevisionsContext creates a new RevisionsContext.
+func (v *PasteView) NewRevisionsContext(r *http.Request, paste *model.Paste, revisions []model.PasteRevision) RevisionsContext {
+	return RevisionsContext{
+		Paste:       paste,
+		Revisions:   revisions,
+		PageContext: NewPageContext(r, v.Revisions),
+	}
+}
+
+// NewDiffContext creates a new DiffContext.
+func (v *PasteView) NewDiffContext(r *http.Request, paste *model.Paste, revisions []model.PasteRevision, from *model.PasteRevision, to *model.PasteRevision, diff string) DiffContext {
+	return DiffContext{
+		Paste:         paste,
+		Revisions:     revisions,
+		From:          from,
+		To:            to,
+		FormattedDiff: diff,
+		PageContext:   NewPageContext(r, v.Diff),
+	}
+}
@@ -2,6 +2,7 @@ package view
 
 import (
 	"net/http"
+	"time"
 
 	"bingo/internal/mvc/model"
 )
@@ -16,7 +17,9 @@ type UserView struct {
 // EditUserContext represents a rendering context for the User Edit page.
 type EditUserContext struct {
 	PageContext
-	User *model.User
+	User          *model.User
+	Tokens        []model.APIToken
+	RecoveryCodes int
 }
 
 // ListUsersContext represents a rendering context for the User List page.
@@ -24,6 +27,19 @@ type ListUsersContext struct {
 	PageContext
 	TotalCount int
 	Users      []model.User
+	Locked     []LockedUser
+	Audit      []model.AuditEntry
+}
+
+// LockedUser is a user locked after too many failed logins.
+type LockedUser struct {
+	User  model.User
+	Until time.Time
+}
+
+// Remaining returns how long the user stays locked.
+func (locked LockedUser) Remaining() time.Duration {
+	return time.Until(locked.Until)
 }
 
 // NewUserView creates a new UserView.
@@ -48,10 +64,12 @@ func NewUserView() *UserView {
 }
 
 // NewEditProfileContext creates a new EditUserContext.
-func (v *UserView) NewEditProfileContext(r *http.Request, user *model.User) EditUserContext {
+func (v *UserView) NewEditProfileContext(r *http.Request, user *model.User, tokens []model.APIToken, recoveryCodes int) EditUserContext {
 	return EditUserContext{
-		User:        user,
-		PageContext: NewPageContext(r, v.Profile),
+		User:          user,
+		Tokens:        tokens,
+		RecoveryCodes: recoveryCodes,
+		PageContext:   NewPageContext(r, v.Profile),
 	}
 }
 
@@ -64,9 +82,11 @@ func (v *UserView) NewEditUserContext(r *http.Request, user *model.User) EditUse
 }
 
 // NewListUsersContext creates a new ListUsersContext.
-func (v *UserView) NewListUsersContext(r *http.Request, users []model.User) ListUsersContext {
+func (v *UserView) NewListUsersContext(r *http.Request, users []model.User, locked []LockedUser, audit []model.AuditEntry) ListUsersContext {
 	return ListUsersContext{
 		Users:       users,
+		Locked:      locked,
+		Audit:       audit,
 		PageContext: NewPageContext(r, v.List),
 	}
 }
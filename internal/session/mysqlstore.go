@@ -0,0 +1,41 @@
+package session
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/alexedwards/scs/mysqlstore"
+)
+
+// MySQLStore is a wrapper around mysqlstore.MySQLStore. The sessions table
+// is created by the migrations.
+type MySQLStore struct {
+	store *mysqlstore.MySQLStore
+}
+
+// NewMySQLStore creates a new MySQLStore.
+func NewMySQLStore(db *sql.DB) *MySQLStore {
+	store := new(MySQLStore)
+	store.store = mysqlstore.New(db)
+	return store
+}
+
+// Find returns the data for a given session token from the MySQLStore instance.
+// If the session token is not found or is expired, the returned exists flag
+// will be set to false.
+func (store *MySQLStore) Find(token string) (b []byte, exists bool, err error) {
+	return store.store.Find(token)
+}
+
+// Commit adds a session token and data to the MySQLStore instance with the
+// given expiry time. If the session token already exists then the data and
+// expiry time are updated.
+func (store *MySQLStore) Commit(token string, b []byte, expiry time.Time) error {
+	return store.store.Commit(token, b, expiry)
+}
+
+// Delete removes a session token and corresponding data from the MySQLStore
+// instance.
+func (store *MySQLStore) Delete(token string) error {
+	return store.store.Delete(token)
+}
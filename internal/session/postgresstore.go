@@ -2,14 +2,13 @@ package session
 
 import (
 	"database/sql"
-	"log"
 	"time"
 
 	"github.com/alexedwards/scs/postgresstore"
 )
 
-// PostgresStore is a wrapper around postgresstore.PostgresStore that creates
-// the required sessions table.
+// PostgresStore is a wrapper around postgresstore.PostgresStore. The
+// sessions table is created by the migrations.
 type PostgresStore struct {
 	store *postgresstore.PostgresStore
 }
@@ -18,7 +17,6 @@ type PostgresStore struct {
 func NewPostgresStore(db *sql.DB) *PostgresStore {
 	store := new(PostgresStore)
 	store.store = postgresstore.New(db)
-	createTable(db)
 	return store
 }
 
@@ -41,19 +39,3 @@ func (store *PostgresStore) Commit(token string, b []byte, expiry time.Time) err
 func (store *PostgresStore) Delete(token string) error {
 	return store.store.Delete(token)
 }
-
-func createTable(db *sql.DB) {
-	q := `
-	CREATE TABLE IF NOT EXISTS sessions (
-		token TEXT PRIMARY KEY,
-		data BYTEA NOT NULL,
-		expiry TIMESTAMPTZ NOT NULL
-	);
-	CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry);
-	`
-
-	_, err := db.Exec(q)
-	if err != nil {
-		log.Fatalln("Failed to create table 'sessions':", err)
-	}
-}
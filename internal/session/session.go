@@ -1,29 +1,43 @@
 package session
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
+	"strings"
 	"time"
 
 	"bingo/internal/config"
 	"bingo/internal/mvc/model"
 	"bingo/internal/mvc/model/store"
+	"bingo/internal/util/auth"
 	"bingo/internal/util/log"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	bearerPrefix = "Bearer "
+
+	// pendingLoginLifetime is how long users have to enter their second
+	// factor after entering their password.
+	pendingLoginLifetime = 5 * time.Minute
 )
 
 var session *Session
 
 // Session handles user sessions.
 type Session struct {
-	Manager   *scs.SessionManager
-	userStore *store.UserStore
+	Manager    *scs.SessionManager
+	userStore  store.UserRepository
+	tokenStore store.TokenRepository
+	proxy      *auth.ProxyAuthenticator
 }
 
 // Init initializes the default session.
-func Init(store *store.UserStore) {
-	session = New(store)
+func Init(db *sqlx.DB, userStore store.UserRepository, tokenStore store.TokenRepository) {
+	session = New(db, userStore, tokenStore)
 }
 
 // Get returns the default session.
@@ -31,8 +45,9 @@ func Get() *scs.SessionManager {
 	return session.Manager
 }
 
-// New creates a new Session.
-func New(store *store.UserStore) *Session {
+// New creates a new Session. Sessions are kept in memory if the db store is
+// configured but the memory database is used, in which case db is nil.
+func New(db *sqlx.DB, userStore store.UserRepository, tokenStore store.TokenRepository) *Session {
 	manager := scs.New()
 	manager.Lifetime = 365 * 24 * time.Hour
 	manager.Cookie.Name = config.Get().Authentication.Session.Name
@@ -44,30 +59,46 @@ func New(store *store.UserStore) *Session {
 
 	if config.Get().Authentication.Session.Store == "redis" {
 		manager.Store = NewRedisStore()
+	} else if config.Get().Authentication.Session.Store == "db" && db == nil {
+		log.Debug("Keeping sessions in memory with the memory database")
+	} else if config.Get().Authentication.Session.Store == "db" && model.IsSQLite(db) {
+		manager.Store = NewSQLiteStore(db.DB)
+	} else if config.Get().Authentication.Session.Store == "db" && model.IsMySQL(db) {
+		manager.Store = NewMySQLStore(db.DB)
 	} else if config.Get().Authentication.Session.Store == "db" {
-		manager.Store = NewPostgresStore(store.Database.DB)
+		manager.Store = NewPostgresStore(db.DB)
 	}
 
 	session := new(Session)
 	session.Manager = manager
-	session.userStore = store
+	session.userStore = userStore
+	session.tokenStore = tokenStore
+
+	if config.Get().Authentication.Enabled && config.Get().Authentication.Proxy.Enabled {
+		session.proxy = auth.NewProxyAuthenticator()
+	}
 	return session
 }
 
-// User returns the active user for the session.
+// User returns the active user for the session, the owner of the API token if
+// the request is authenticated using a bearer token, or the user set by a
+// trusted proxy if proxy authentication is enabled.
 func User(r *http.Request) *model.User {
 	cachedUser := GetRequestValue(r, "user")
 	if cachedUser != nil {
 		return cachedUser.(*model.User)
 	}
 
-	if !session.Manager.Exists(r.Context(), "user_id") {
-		return nil
+	var user *model.User
+	if token, ok := BearerToken(r); ok {
+		user = tokenUser(token)
+	} else if proxyUser, ok := authenticateProxy(r); ok {
+		user = proxyUser
+	} else {
+		user = sessionUser(r)
 	}
 
-	id := session.Manager.Get(r.Context(), "user_id").(int64)
-	user, err := session.userStore.FindByID(id)
-	if err != nil {
+	if user == nil {
 		return nil
 	}
 
@@ -75,6 +106,17 @@ func User(r *http.Request) *model.User {
 	return user
 }
 
+// BearerToken returns the API token from the Authorization header of the request.
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+		return "", false
+	}
+
+	token := strings.TrimSpace(header[len(bearerPrefix):])
+	return token, token != ""
+}
+
 // Login sets the active user for the session.
 func Login(r *http.Request, user *model.User) error {
 	if session.Manager == nil {
@@ -88,9 +130,50 @@ func Login(r *http.Request, user *model.User) error {
 	}
 
 	session.Manager.Put(r.Context(), "user_id", user.ID)
+	session.Manager.Remove(r.Context(), "pending_user_id")
+	session.Manager.Remove(r.Context(), "pending_time")
+	return nil
+}
+
+// BeginLogin remembers the user who entered their password but has yet to
+// enter their second factor. The user isn't logged in until Login is called.
+func BeginLogin(r *http.Request, user *model.User) error {
+	if session.Manager == nil {
+		return errors.New("no session configured")
+	}
+
+	err := renewToken(r)
+	if err != nil {
+		session.Manager.Clear(r.Context())
+		return err
+	}
+
+	session.Manager.Remove(r.Context(), "user_id")
+	session.Manager.Put(r.Context(), "pending_user_id", user.ID)
+	session.Manager.Put(r.Context(), "pending_time", time.Now().Unix())
 	return nil
 }
 
+// PendingUser returns the user who began logging in, or nil if no login is
+// pending or it has expired.
+func PendingUser(r *http.Request) *model.User {
+	if !session.Manager.Exists(r.Context(), "pending_user_id") {
+		return nil
+	}
+
+	started := time.Unix(session.Manager.Get(r.Context(), "pending_time").(int64), 0)
+	if time.Since(started) > pendingLoginLifetime {
+		return nil
+	}
+
+	id := session.Manager.Get(r.Context(), "pending_user_id").(int64)
+	user, err := session.userStore.FindByID(id)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
 // Logout clears the active user for the session.
 func Logout(r *http.Request) error {
 	if session == nil {
@@ -110,3 +193,94 @@ func renewToken(r *http.Request) error {
 	}
 	return err
 }
+
+func sessionUser(r *http.Request) *model.User {
+	if !session.Manager.Exists(r.Context(), "user_id") {
+		return nil
+	}
+
+	id := session.Manager.Get(r.Context(), "user_id").(int64)
+	user, err := session.userStore.FindByID(id)
+	if err != nil {
+		return nil
+	}
+
+	// Admins who have to enable two-factor authentication are logged out
+	// until they do
+	if user.NeedsTOTP() {
+		log.Debugf("User '%s' has to enable two-factor authentication", user.UID)
+		return nil
+	}
+	return user
+}
+
+func tokenUser(token string) *model.User {
+	apiToken, err := session.tokenStore.FindByToken(token)
+	if err != nil {
+		log.Debugln("Failed to authenticate API token:", err)
+		return nil
+	}
+
+	user, err := session.userStore.FindByID(apiToken.UserID)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func authenticateProxy(r *http.Request) (*model.User, bool) {
+	if session.proxy == nil {
+		return nil, false
+	}
+
+	proxyUser, ok := session.proxy.Authenticate(r)
+	if !ok {
+		return nil, false
+	}
+	return provisionProxyUser(proxyUser), true
+}
+
+// provisionProxyUser returns the local user of a user authenticated by the
+// proxy. The user is created on their first request and updated when their
+// details change. Users using another authentication mode aren't taken over.
+func provisionProxyUser(proxyUser *auth.ProxyUser) *model.User {
+	userTmpl := model.UserTemplate{
+		UID:      sql.NullString{String: proxyUser.UID, Valid: true},
+		Name:     sql.NullString{String: proxyUser.Name, Valid: true},
+		Email:    sql.NullString{String: proxyUser.Email, Valid: proxyUser.Email != ""},
+		AuthMode: sql.NullInt32{Int32: int32(config.AuthProxy), Valid: true},
+		Role:     sql.NullInt32{Int32: int32(proxyUser.Role), Valid: true},
+	}
+
+	user, err := session.userStore.FindByUID(proxyUser.UID)
+	if err == sql.ErrNoRows {
+		log.Debugf("Creating user '%s' authenticated by the proxy", proxyUser.UID)
+		userTmpl.Theme = sql.NullInt32{Int32: int32(config.Get().Theme.Default), Valid: true}
+		user, err = session.userStore.Insert(&userTmpl)
+		if err != nil {
+			log.Errorf("Failed to create user '%s' authenticated by the proxy: %s", proxyUser.UID, err)
+			return nil
+		}
+		return user
+	} else if err != nil {
+		log.Debugln("Failed to find user authenticated by the proxy:", err)
+		return nil
+	}
+
+	if user.AuthMode != config.AuthProxy {
+		log.Warnf("User '%s' authenticated by the proxy already exists with %s authentication", user.UID, user.AuthMode)
+		return nil
+	}
+
+	if user.Name == proxyUser.Name && (!userTmpl.Email.Valid || user.Email == userTmpl.Email) && user.Role == proxyUser.Role {
+		return user
+	}
+
+	userTmpl.ID = sql.NullInt64{Int64: user.ID, Valid: true}
+	updated, err := session.userStore.Update(&userTmpl)
+	if err != nil {
+		log.Errorf("Failed to update user '%s' authenticated by the proxy: %s", user.UID, err)
+		return user
+	}
+	return updated
+}
@@ -0,0 +1,41 @@
+package session
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/alexedwards/scs/sqlite3store"
+)
+
+// SQLiteStore is a wrapper around sqlite3store.SQLite3Store. The sessions table
+// is created by the migrations.
+type SQLiteStore struct {
+	store *sqlite3store.SQLite3Store
+}
+
+// NewSQLiteStore creates a new SQLiteStore.
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	store := new(SQLiteStore)
+	store.store = sqlite3store.New(db)
+	return store
+}
+
+// Find returns the data for a given session token from the SQLiteStore instance.
+// If the session token is not found or is expired, the returned exists flag
+// will be set to false.
+func (store *SQLiteStore) Find(token string) (b []byte, exists bool, err error) {
+	return store.store.Find(token)
+}
+
+// Commit adds a session token and data to the SQLiteStore instance with the
+// given expiry time. If the session token already exists then the data and
+// expiry time are updated.
+func (store *SQLiteStore) Commit(token string, b []byte, expiry time.Time) error {
+	return store.store.Commit(token, b, expiry)
+}
+
+// Delete removes a session token and corresponding data from the SQLiteStore
+// instance.
+func (store *SQLiteStore) Delete(token string) error {
+	return store.store.Delete(token)
+}
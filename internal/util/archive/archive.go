@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	// FormatZip represents a zip archive.
+	FormatZip Format = "zip"
+
+	// FormatTarGz represents a gzip compressed tar archive.
+	FormatTarGz Format = "tar.gz"
+)
+
+// Format is the file format of an archive.
+type Format string
+
+// File represents a single file written to an archive.
+type File struct {
+	Name    string
+	Content []byte
+	ModTime time.Time
+}
+
+// ParseFormat parses the archive format from a string. Zip is used by default.
+func ParseFormat(format string) (Format, error) {
+	switch format {
+	case "", "zip":
+		return FormatZip, nil
+	case "tar.gz", "tgz":
+		return FormatTarGz, nil
+	default:
+		return "", fmt.Errorf("unknown archive format '%s'", format)
+	}
+}
+
+// ContentType returns the MIME type of the archive format.
+func (format Format) ContentType() string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Write writes the files to w as an archive of the given format.
+func Write(w io.Writer, format Format, files []File) error {
+	if format == FormatTarGz {
+		return WriteTarGz(w, files)
+	}
+	return WriteZip(w, files)
+}
+
+// WriteZip writes the files to w as a zip archive.
+func WriteZip(w io.Writer, files []File) error {
+	archive := zip.NewWriter(w)
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:     file.Name,
+			Method:   zip.Deflate,
+			Modified: file.ModTime,
+		}
+
+		writer, err := archive.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = writer.Write(file.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// WriteTarGz writes the files to w as a gzip compressed tar archive.
+func WriteTarGz(w io.Writer, files []File) error {
+	compressed := gzip.NewWriter(w)
+	archive := tar.NewWriter(compressed)
+	for _, file := range files {
+		header := &tar.Header{
+			Name:    file.Name,
+			Mode:    0644,
+			Size:    int64(len(file.Content)),
+			ModTime: file.ModTime,
+		}
+
+		err := archive.WriteHeader(header)
+		if err != nil {
+			return err
+		}
+
+		_, err = archive.Write(file.Content)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := archive.Close()
+	if err != nil {
+		return err
+	}
+	return compressed.Close()
+}
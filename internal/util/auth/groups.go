@@ -0,0 +1,41 @@
+package auth
+
+import "bingo/internal/config"
+
+// GroupRoles maps the groups of users from an external identity provider to
+// roles.
+type GroupRoles struct {
+	Admin   []string
+	Editor  []string
+	Viewer  []string
+	Default config.Role
+}
+
+// Find returns the highest role any of the groups is given, or the default
+// role if none of them are configured.
+func (roles GroupRoles) Find(groups []string) config.Role {
+	names := make(map[string]bool)
+	for _, group := range groups {
+		names[group] = true
+	}
+
+	switch {
+	case containsAny(names, roles.Admin):
+		return config.RoleAdmin
+	case containsAny(names, roles.Editor):
+		return config.RoleEditor
+	case containsAny(names, roles.Viewer):
+		return config.RoleViewer
+	default:
+		return roles.Default
+	}
+}
+
+func containsAny(names map[string]bool, groups []string) bool {
+	for _, group := range groups {
+		if names[group] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the username or password is wrong.
+	ErrInvalidCredentials = errors.New("invalid username or password")
+
+	ldapTimeout = 10 * time.Second
+)
+
+// LDAPUser represents a user entry found in the LDAP directory.
+type LDAPUser struct {
+	DN    string
+	UID   string
+	Name  string
+	Email string
+	Role  config.Role
+}
+
+// LDAPAuthenticator authenticates users against the configured LDAP directory.
+type LDAPAuthenticator struct {
+	Host         string
+	Port         int
+	UseTLS       bool
+	Base         string
+	BindDN       string
+	BindPassword string
+	UIDAttr      string
+	NameAttr     string
+	EmailAttr    string
+	UserFilter   string
+	AdminFilter  string
+	DefaultRole  config.Role
+}
+
+// NewLDAPAuthenticator creates a new LDAPAuthenticator using the global configuration.
+func NewLDAPAuthenticator() *LDAPAuthenticator {
+	conf := config.Get().Authentication
+	return &LDAPAuthenticator{
+		Host:         conf.LDAP.Host,
+		Port:         conf.LDAP.Port,
+		UseTLS:       conf.LDAP.UseTLS,
+		Base:         conf.LDAP.Base,
+		BindDN:       conf.LDAP.Bind.DN,
+		BindPassword: conf.LDAP.Bind.Password,
+		UIDAttr:      conf.LDAP.Attributes.UID,
+		NameAttr:     conf.LDAP.Attributes.Name,
+		EmailAttr:    conf.LDAP.Attributes.Email,
+		UserFilter:   conf.LDAP.UserFilter,
+		AdminFilter:  conf.LDAP.AdminFilter,
+		DefaultRole:  conf.DefaultRole,
+	}
+}
+
+// Authenticate binds to the directory as the given user and returns the user entry.
+func (ldapAuth *LDAPAuthenticator) Authenticate(username string, password string) (*LDAPUser, error) {
+	log.Debugf("Authenticating user '%s' using LDAP", username)
+
+	// An empty password would be treated as an unauthenticated bind, which
+	// most servers accept. Never let that pass as a successful login.
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := ldapAuth.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = ldapAuth.bindService(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := ldapAuth.findUser(conn, username, ldapAuth.UserFilter)
+	if err != nil {
+		return nil, err
+	} else if entry == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	err = conn.Bind(entry.DN, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to bind as user: %s", err)
+	}
+
+	// Group membership is resolved using the service account because regular
+	// users often lack permissions to search the directory.
+	err = ldapAuth.bindService(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := ldapAuth.findRole(conn, username)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &LDAPUser{
+		DN:    entry.DN,
+		UID:   entry.GetAttributeValue(ldapAuth.UIDAttr),
+		Name:  entry.GetAttributeValue(ldapAuth.NameAttr),
+		Email: entry.GetAttributeValue(ldapAuth.EmailAttr),
+		Role:  role,
+	}
+
+	if user.UID == "" {
+		user.UID = username
+	}
+	if user.Name == "" {
+		user.Name = user.UID
+	}
+
+	log.Debugf("User '%s' authenticated using LDAP as %s", user.UID, user.Role)
+	return user, nil
+}
+
+func (ldapAuth *LDAPAuthenticator) dial() (*ldap.Conn, error) {
+	addr := fmt.Sprintf("%s:%d", ldapAuth.Host, ldapAuth.Port)
+	log.Debugf("Connecting to LDAP server %s", addr)
+
+	var conn *ldap.Conn
+	var err error
+	if ldapAuth.UseTLS {
+		conn, err = ldap.DialTLS("tcp", addr, &tls.Config{ServerName: ldapAuth.Host})
+	} else {
+		conn, err = ldap.Dial("tcp", addr)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LDAP server: %s", err)
+	}
+
+	conn.SetTimeout(ldapTimeout)
+	return conn, nil
+}
+
+func (ldapAuth *LDAPAuthenticator) bindService(conn *ldap.Conn) error {
+	var err error
+	if ldapAuth.BindDN == "" {
+		err = conn.UnauthenticatedBind("")
+	} else {
+		err = conn.Bind(ldapAuth.BindDN, ldapAuth.BindPassword)
+	}
+
+	if err != nil {
+		return fmt.Errorf("failed to bind to LDAP server: %s", err)
+	}
+	return nil
+}
+
+func (ldapAuth *LDAPAuthenticator) findRole(conn *ldap.Conn, username string) (config.Role, error) {
+	if ldapAuth.AdminFilter == "" {
+		return ldapAuth.DefaultRole, nil
+	}
+
+	entry, err := ldapAuth.findUser(conn, username, ldapAuth.AdminFilter)
+	if err != nil {
+		return ldapAuth.DefaultRole, err
+	} else if entry != nil {
+		return config.RoleAdmin, nil
+	}
+	return ldapAuth.DefaultRole, nil
+}
+
+// findUser returns the single entry matching both the username and the given
+// filter, or nil if there's no such entry.
+func (ldapAuth *LDAPAuthenticator) findUser(conn *ldap.Conn, username string, filter string) (*ldap.Entry, error) {
+	if filter == "" {
+		filter = "(objectClass=*)"
+	} else if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+
+	query := fmt.Sprintf("(&%s(%s=%s))", filter, ldapAuth.UIDAttr, ldap.EscapeFilter(username))
+	log.Tracef("Searching LDAP directory using filter %s", query)
+
+	request := ldap.NewSearchRequest(
+		ldapAuth.Base,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(ldapTimeout.Seconds()),
+		false,
+		query,
+		[]string{"dn", ldapAuth.UIDAttr, ldapAuth.NameAttr, ldapAuth.EmailAttr},
+		nil,
+	)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to search LDAP directory: %s", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return result.Entries[0], nil
+	default:
+		return nil, fmt.Errorf("multiple LDAP entries match user '%s'", username)
+	}
+}
@@ -0,0 +1,328 @@
+package auth
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"bingo/internal/config"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapEntry is an entry of the test directory.
+type ldapEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// ldapTestServer is an LDAP server running in the test process. It supports
+// simple binds and searches with equality, presence, and, or and not filters,
+// which is all the authenticator uses. Values are compared case-insensitively
+// like most attributes of real directories.
+type ldapTestServer struct {
+	listener net.Listener
+	entries  []ldapEntry
+}
+
+func newLDAPTestServer(t *testing.T, entries []ldapEntry) *ldapTestServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &ldapTestServer{listener: listener, entries: entries}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (server *ldapTestServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		go server.handle(conn)
+	}
+}
+
+func (server *ldapTestServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+
+		id := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := server.bind(op.Children[1].Data.String(), op.Children[2].Data.String())
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			for _, entry := range server.entries {
+				if strings.HasSuffix(strings.ToLower(entry.DN), strings.ToLower(op.Children[0].Data.String())) && matchFilter(op.Children[6], entry) {
+					conn.Write(ldapSearchEntry(id, entry).Bytes())
+				}
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (server *ldapTestServer) bind(dn string, password string) int64 {
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+
+	for _, entry := range server.entries {
+		if strings.EqualFold(entry.DN, dn) && entry.Password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (server *ldapTestServer) authenticator() *LDAPAuthenticator {
+	addr := server.listener.Addr().(*net.TCPAddr)
+	return &LDAPAuthenticator{
+		Host:         addr.IP.String(),
+		Port:         addr.Port,
+		Base:         "dc=example,dc=org",
+		BindDN:       "cn=admin,dc=example,dc=org",
+		BindPassword: "admin",
+		UIDAttr:      "uid",
+		NameAttr:     "cn",
+		EmailAttr:    "mail",
+		UserFilter:   "(objectClass=inetOrgPerson)",
+		AdminFilter:  "(memberOf=cn=admins,ou=groups,dc=example,dc=org)",
+		DefaultRole:  config.RoleEditor,
+	}
+}
+
+func matchFilter(filter *ber.Packet, entry ldapEntry) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !matchFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if matchFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !matchFilter(filter.Children[0], entry)
+	case ldap.FilterPresent:
+		return len(findAttribute(entry, filter.Data.String())) > 0
+	case ldap.FilterEqualityMatch:
+		for _, value := range findAttribute(entry, filter.Children[0].Data.String()) {
+			if strings.EqualFold(value, filter.Children[1].Data.String()) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+func findAttribute(entry ldapEntry, name string) []string {
+	for key, values := range entry.Attributes {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func ldapResult(id int64, tag ber.Tag, code int64) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	packet.AppendChild(result)
+	return packet
+}
+
+func ldapSearchEntry(id int64, entry ldapEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.DN, "DN"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.Attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	result.AppendChild(attributes)
+	packet.AppendChild(result)
+	return packet
+}
+
+func testDirectory() []ldapEntry {
+	return []ldapEntry{
+		{
+			DN:       "cn=admin,dc=example,dc=org",
+			Password: "admin",
+		},
+		{
+			DN:       "uid=alice,ou=people,dc=example,dc=org",
+			Password: "alice-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {"alice"},
+				"cn":          {"Alice Example"},
+				"mail":        {"alice@example.org"},
+				"memberOf":    {"cn=admins,ou=groups,dc=example,dc=org"},
+			},
+		},
+		{
+			DN:       "uid=bob,ou=people,dc=example,dc=org",
+			Password: "bob-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {"bob"},
+			},
+		},
+		{
+			DN:       "uid=service,ou=apps,dc=example,dc=org",
+			Password: "service-secret",
+			Attributes: map[string][]string{
+				"objectClass": {"device"},
+				"uid":         {"service"},
+			},
+		},
+	}
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	ldapAuth := newLDAPTestServer(t, testDirectory()).authenticator()
+
+	user, err := ldapAuth.Authenticate("alice", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := LDAPUser{
+		DN:    "uid=alice,ou=people,dc=example,dc=org",
+		UID:   "alice",
+		Name:  "Alice Example",
+		Email: "alice@example.org",
+		Role:  config.RoleAdmin,
+	}
+	if *user != expected {
+		t.Errorf("expected %+v, got %+v", expected, *user)
+	}
+}
+
+func TestLDAPAuthenticateDefaults(t *testing.T) {
+	ldapAuth := newLDAPTestServer(t, testDirectory()).authenticator()
+
+	user, err := ldapAuth.Authenticate("bob", "bob-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.Name != "bob" || user.Email != "" || user.Role != config.RoleEditor {
+		t.Errorf("expected name bob without email as editor, got %+v", *user)
+	}
+}
+
+func TestLDAPAuthenticateReturnsDirectoryUID(t *testing.T) {
+	ldapAuth := newLDAPTestServer(t, testDirectory()).authenticator()
+
+	user, err := ldapAuth.Authenticate("ALICE", "alice-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user.UID != "alice" {
+		t.Errorf("expected uid of the directory entry alice, got %s", user.UID)
+	}
+}
+
+func TestLDAPAuthenticateRejects(t *testing.T) {
+	ldapAuth := newLDAPTestServer(t, testDirectory()).authenticator()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+	}{
+		{"wrong password", "alice", "wrong"},
+		{"empty password", "alice", ""},
+		{"empty username", "", "alice-secret"},
+		{"unknown user", "mallory", "alice-secret"},
+		{"filtered user", "service", "service-secret"},
+		{"filter injection", "*", "alice-secret"},
+	}
+
+	for _, test := range tests {
+		_, err := ldapAuth.Authenticate(test.username, test.password)
+		if err != ErrInvalidCredentials {
+			t.Errorf("%s: expected %v, got %v", test.name, ErrInvalidCredentials, err)
+		}
+	}
+}
+
+func TestLDAPAuthenticateServiceBindFails(t *testing.T) {
+	ldapAuth := newLDAPTestServer(t, testDirectory()).authenticator()
+	ldapAuth.BindPassword = "wrong"
+
+	_, err := ldapAuth.Authenticate("alice", "alice-secret")
+	if err == nil || err == ErrInvalidCredentials {
+		t.Errorf("expected the failed service bind to be reported, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticateUnreachable(t *testing.T) {
+	server := newLDAPTestServer(t, testDirectory())
+	ldapAuth := server.authenticator()
+	server.listener.Close()
+
+	_, err := ldapAuth.Authenticate("alice", "alice-secret")
+	if err == nil || !strings.Contains(err.Error(), "connect") {
+		t.Errorf("expected a connection error, got %v", err)
+	}
+}
+
+func TestLDAPAuthenticateAmbiguous(t *testing.T) {
+	entries := append(testDirectory(), ldapEntry{
+		DN:       "uid=alice,ou=other,dc=example,dc=org",
+		Password: "other-secret",
+		Attributes: map[string][]string{
+			"objectClass": {"inetOrgPerson"},
+			"uid":         {"Alice"},
+		},
+	})
+	ldapAuth := newLDAPTestServer(t, entries).authenticator()
+
+	_, err := ldapAuth.Authenticate("alice", "alice-secret")
+	if err == nil || !strings.Contains(err.Error(), "multiple") {
+		t.Errorf("expected an error about multiple entries, got %v", err)
+	}
+}
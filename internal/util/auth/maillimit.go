@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// mailKeyPrefix marks the keys of sent mails in the session store.
+const mailKeyPrefix = "mail:"
+
+// MailLimiter limits how often the same mail is sent, so requests can't flood
+// the mailbox of a user. When mails were sent is kept in the session store
+// like failed logins, so the limit is shared by all instances using it.
+type MailLimiter struct {
+	Interval time.Duration
+	store    AttemptStore
+	mutex    sync.Mutex
+}
+
+// NewMailLimiter creates a new MailLimiter keeping when mails were sent in
+// the given store.
+func NewMailLimiter(store AttemptStore, interval time.Duration) *MailLimiter {
+	return &MailLimiter{
+		Interval: interval,
+		store:    store,
+	}
+}
+
+// Allow returns whether the mail with the given key may be sent, and records
+// that it's sent if so.
+func (limiter *MailLimiter) Allow(key string, now time.Time) (bool, error) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	data, found, err := limiter.store.Find(storeKey(mailKeyPrefix, key))
+	if err != nil {
+		return false, err
+	}
+
+	if found {
+		sent, err := strconv.ParseInt(string(data), 10, 64)
+		if err == nil && time.Unix(sent, 0).Add(limiter.Interval).After(now) {
+			return false, nil
+		}
+	}
+
+	data = []byte(strconv.FormatInt(now.Unix(), 10))
+	return true, limiter.store.Commit(storeKey(mailKeyPrefix, key), data, now.Add(limiter.Interval))
+}
+
+// Forget forgets that the mail with the given key was sent, so it can be sent
+// again right away after sending it failed.
+func (limiter *MailLimiter) Forget(key string) error {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	return limiter.store.Delete(storeKey(mailKeyPrefix, key))
+}
@@ -0,0 +1,652 @@
+package auth
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	// Register the hashes used by the signatures of ID tokens
+	_ "crypto/sha512"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+)
+
+var (
+	// ErrInvalidIDToken is returned when the ID token of the provider can't be
+	// verified.
+	ErrInvalidIDToken = errors.New("invalid ID token")
+
+	oidcTimeout = 10 * time.Second
+
+	// oidcLeeway is the difference allowed between the clocks of the provider
+	// and bingo when checking the expiry of ID tokens.
+	oidcLeeway = time.Minute
+
+	// oidcKeysInterval is how long to wait before fetching the keys of the
+	// provider again for a token signed with an unknown key, so such tokens
+	// don't make bingo flood the provider with requests.
+	oidcKeysInterval = time.Minute
+)
+
+// OIDCUser represents a user authenticated by the OpenID Connect provider.
+// The issuer and subject identify the user, the other claims can change.
+type OIDCUser struct {
+	Issuer  string
+	Subject string
+	UID     string
+	Name    string
+	Email   string
+	Role    config.Role
+}
+
+// OIDCLogin holds the secrets of a login in progress. They're kept in the
+// session of the user until the provider redirects back.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// OIDCAuthenticator authenticates users with an OpenID Connect provider using
+// the authorization code flow with PKCE.
+type OIDCAuthenticator struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	UIDClaim     string
+	NameClaim    string
+	EmailClaim   string
+	GroupsClaim  string
+	Roles        GroupRoles
+
+	client      *http.Client
+	mutex       sync.Mutex
+	provider    *oidcProvider
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+// oidcProvider is the configuration of the provider found by discovery.
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserInfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcTokens is the response of the token endpoint.
+type oidcTokens struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// jsonWebKey is a public key of the provider used to sign ID tokens.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewOIDCAuthenticator creates a new OIDCAuthenticator using the global
+// configuration. The provider is discovered on the first login, so bingo can
+// start while the provider is down.
+func NewOIDCAuthenticator() *OIDCAuthenticator {
+	conf := config.Get().Authentication
+	if conf.OIDC.Issuer == "" || conf.OIDC.ClientID == "" || conf.OIDC.RedirectURL == "" {
+		log.Fatal("OpenID Connect needs an issuer, a client id and a redirect url")
+	}
+
+	return &OIDCAuthenticator{
+		Issuer:       strings.TrimSuffix(conf.OIDC.Issuer, "/"),
+		ClientID:     conf.OIDC.ClientID,
+		ClientSecret: conf.OIDC.ClientSecret,
+		RedirectURL:  conf.OIDC.RedirectURL,
+		Scopes:       conf.OIDC.Scopes,
+		UIDClaim:     conf.OIDC.Claims.UID,
+		NameClaim:    conf.OIDC.Claims.Name,
+		EmailClaim:   conf.OIDC.Claims.Email,
+		GroupsClaim:  conf.OIDC.Claims.Groups,
+		Roles: GroupRoles{
+			Admin:   conf.OIDC.AdminGroups,
+			Editor:  conf.OIDC.EditorGroups,
+			Viewer:  conf.OIDC.ViewerGroups,
+			Default: conf.DefaultRole,
+		},
+		client: &http.Client{Timeout: oidcTimeout},
+	}
+}
+
+// NewOIDCLogin creates the random secrets of a new login.
+func NewOIDCLogin() (*OIDCLogin, error) {
+	values := make([]string, 3)
+	for i := range values {
+		value, err := GenerateKey()
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+
+	return &OIDCLogin{State: values[0], Nonce: values[1], Verifier: values[2]}, nil
+}
+
+// CheckState checks if the state the provider redirected back with matches
+// the login in constant time.
+func (login *OIDCLogin) CheckState(state string) bool {
+	return login.State != "" && subtle.ConstantTimeCompare([]byte(login.State), []byte(state)) == 1
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to for logging
+// in.
+func (oidcAuth *OIDCAuthenticator) AuthCodeURL(login *OIDCLogin) (string, error) {
+	provider, err := oidcAuth.discover()
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", oidcAuth.ClientID)
+	params.Set("redirect_uri", oidcAuth.RedirectURL)
+	params.Set("scope", strings.Join(oidcAuth.scopes(), " "))
+	params.Set("state", login.State)
+	params.Set("nonce", login.Nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return provider.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Authenticate exchanges the authorization code the provider redirected back
+// with for the tokens of the user, and returns the user of the verified ID
+// token. Claims missing from the ID token are fetched from the userinfo
+// endpoint.
+func (oidcAuth *OIDCAuthenticator) Authenticate(code string, login *OIDCLogin) (*OIDCUser, error) {
+	if code == "" {
+		return nil, errors.New("the provider didn't return an authorization code")
+	}
+
+	provider, err := oidcAuth.discover()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := oidcAuth.exchange(provider, code, login.Verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := oidcAuth.verifyIDToken(provider, tokens.IDToken, login.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if provider.UserInfoEndpoint != "" && tokens.AccessToken != "" && oidcAuth.missingClaims(claims) {
+		err = oidcAuth.addUserInfo(provider, tokens.AccessToken, claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user := &OIDCUser{
+		Issuer:  claimString(claims, "iss"),
+		Subject: claimString(claims, "sub"),
+		UID:     claimString(claims, oidcAuth.UIDClaim),
+		Name:    claimString(claims, oidcAuth.NameClaim),
+		Email:   claimString(claims, oidcAuth.EmailClaim),
+		Role:    oidcAuth.Roles.Find(claimStrings(claims, oidcAuth.GroupsClaim)),
+	}
+
+	if user.UID == "" {
+		user.UID = user.Email
+	}
+	if user.UID == "" {
+		user.UID = user.Subject
+	}
+	if user.Name == "" {
+		user.Name = user.UID
+	}
+
+	log.Debugf("User '%s' authenticated using OpenID Connect as %s", user.UID, user.Role)
+	return user, nil
+}
+
+// scopes returns the configured scopes, which always include openid.
+func (oidcAuth *OIDCAuthenticator) scopes() []string {
+	for _, scope := range oidcAuth.Scopes {
+		if scope == "openid" {
+			return oidcAuth.Scopes
+		}
+	}
+	return append([]string{"openid"}, oidcAuth.Scopes...)
+}
+
+// discover fetches the configuration of the provider once it's needed. The
+// lock isn't held while fetching, so a slow provider doesn't block logins
+// that already have what they need.
+func (oidcAuth *OIDCAuthenticator) discover() (*oidcProvider, error) {
+	oidcAuth.mutex.Lock()
+	provider := oidcAuth.provider
+	oidcAuth.mutex.Unlock()
+	if provider != nil {
+		return provider, nil
+	}
+
+	log.Debugf("Discovering OpenID Connect provider %s", oidcAuth.Issuer)
+	provider = new(oidcProvider)
+	err := oidcAuth.getJSON(oidcAuth.Issuer+"/.well-known/openid-configuration", "", provider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID Connect provider: %s", err)
+	}
+
+	// The issuer must match exactly, otherwise tokens of another issuer
+	// could be accepted
+	if strings.TrimSuffix(provider.Issuer, "/") != oidcAuth.Issuer {
+		return nil, fmt.Errorf("OpenID Connect provider has issuer '%s', expected '%s'", provider.Issuer, oidcAuth.Issuer)
+	} else if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, errors.New("OpenID Connect provider is missing endpoints")
+	}
+
+	oidcAuth.mutex.Lock()
+	defer oidcAuth.mutex.Unlock()
+
+	if oidcAuth.provider == nil {
+		oidcAuth.provider = provider
+	}
+	return oidcAuth.provider, nil
+}
+
+func (oidcAuth *OIDCAuthenticator) exchange(provider *oidcProvider, code string, verifier string) (*oidcTokens, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", oidcAuth.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	// Public clients only identify themselves, confidential clients use
+	// basic authentication
+	if oidcAuth.ClientSecret == "" {
+		form.Set("client_id", oidcAuth.ClientID)
+	}
+
+	request, err := http.NewRequest(http.MethodPost, provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if oidcAuth.ClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(oidcAuth.ClientID), url.QueryEscape(oidcAuth.ClientSecret))
+	}
+
+	tokens := new(oidcTokens)
+	err = oidcAuth.doJSON(request, tokens)
+	if tokens.Error != "" {
+		return nil, fmt.Errorf("failed to get tokens from OpenID Connect provider: %s %s", tokens.Error, tokens.ErrorDescription)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get tokens from OpenID Connect provider: %s", err)
+	} else if tokens.IDToken == "" {
+		return nil, errors.New("OpenID Connect provider didn't return an ID token")
+	}
+	return tokens, nil
+}
+
+// verifyIDToken verifies the signature and claims of the ID token and returns
+// its claims.
+func (oidcAuth *OIDCAuthenticator) verifyIDToken(provider *oidcProvider, idToken string, nonce string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	headerData, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(headerData, &header) != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := oidcAuth.findKey(provider, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	err = verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	claims := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	err = decoder.Decode(&claims)
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	expiry, err := claimNumber(claims, "exp")
+	switch {
+	case claimString(claims, "iss") != provider.Issuer:
+		return nil, fmt.Errorf("%s, it was issued by '%s'", ErrInvalidIDToken, claimString(claims, "iss"))
+	case !containsString(claimStrings(claims, "aud"), oidcAuth.ClientID):
+		return nil, fmt.Errorf("%s, it's meant for another client", ErrInvalidIDToken)
+	case claims["azp"] != nil && claimString(claims, "azp") != oidcAuth.ClientID:
+		return nil, fmt.Errorf("%s, it was issued to another client", ErrInvalidIDToken)
+	case err != nil || time.Now().After(time.Unix(expiry, 0).Add(oidcLeeway)):
+		return nil, fmt.Errorf("%s, it has expired", ErrInvalidIDToken)
+	case subtle.ConstantTimeCompare([]byte(claimString(claims, "nonce")), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%s, its nonce doesn't match", ErrInvalidIDToken)
+	case claimString(claims, "sub") == "":
+		return nil, fmt.Errorf("%s, its subject is missing", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// findKey returns the key of the provider with the given id. The keys are
+// fetched again if the key isn't known, since providers rotate their keys,
+// but at most once per oidcKeysInterval. The lock isn't held while fetching.
+func (oidcAuth *OIDCAuthenticator) findKey(provider *oidcProvider, kid string) (crypto.PublicKey, error) {
+	oidcAuth.mutex.Lock()
+	key, ok := oidcAuth.lookupKey(kid)
+	fetched := oidcAuth.keysFetched
+	now := time.Now()
+	refresh := !ok && (fetched.IsZero() || now.Sub(fetched) >= oidcKeysInterval)
+	if refresh {
+		oidcAuth.keysFetched = now
+	}
+	oidcAuth.mutex.Unlock()
+
+	if ok {
+		return key, nil
+	} else if !refresh {
+		return nil, fmt.Errorf("%s, it's signed with unknown key '%s'", ErrInvalidIDToken, kid)
+	}
+
+	keys, err := oidcAuth.fetchKeys(provider)
+	if err != nil {
+		// Failed fetches don't count, so the next login tries again
+		oidcAuth.mutex.Lock()
+		if oidcAuth.keysFetched.Equal(now) {
+			oidcAuth.keysFetched = fetched
+		}
+		oidcAuth.mutex.Unlock()
+		return nil, err
+	}
+
+	oidcAuth.mutex.Lock()
+	defer oidcAuth.mutex.Unlock()
+
+	oidcAuth.keys = keys
+	key, ok = oidcAuth.lookupKey(kid)
+	if !ok {
+		return nil, fmt.Errorf("%s, it's signed with unknown key '%s'", ErrInvalidIDToken, kid)
+	}
+	return key, nil
+}
+
+// fetchKeys fetches the signing keys of the provider.
+func (oidcAuth *OIDCAuthenticator) fetchKeys(provider *oidcProvider) (map[string]crypto.PublicKey, error) {
+	log.Debugf("Fetching keys of OpenID Connect provider %s", oidcAuth.Issuer)
+
+	var keySet struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := oidcAuth.getJSON(provider.JWKSURI, "", &keySet)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys of OpenID Connect provider: %s", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Debugf("Skipping key '%s' of OpenID Connect provider: %s", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// lookupKey returns the known key with the given id. Tokens without a key id
+// can only be signed with the key of providers that have a single key.
+func (oidcAuth *OIDCAuthenticator) lookupKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(oidcAuth.keys) == 1 {
+		for _, key := range oidcAuth.keys {
+			return key, true
+		}
+	}
+
+	key, ok := oidcAuth.keys[kid]
+	return key, ok
+}
+
+func (oidcAuth *OIDCAuthenticator) missingClaims(claims map[string]interface{}) bool {
+	for _, name := range []string{oidcAuth.UIDClaim, oidcAuth.NameClaim, oidcAuth.EmailClaim, oidcAuth.GroupsClaim} {
+		if _, ok := claims[name]; name != "" && !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// addUserInfo adds the claims returned by the userinfo endpoint that are
+// missing from the ID token.
+func (oidcAuth *OIDCAuthenticator) addUserInfo(provider *oidcProvider, accessToken string, claims map[string]interface{}) error {
+	userInfo := make(map[string]interface{})
+	err := oidcAuth.getJSON(provider.UserInfoEndpoint, accessToken, &userInfo)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user info from OpenID Connect provider: %s", err)
+	} else if claimString(userInfo, "sub") != claimString(claims, "sub") {
+		return errors.New("user info of OpenID Connect provider is of another user")
+	}
+
+	for name, value := range userInfo {
+		if _, ok := claims[name]; !ok {
+			claims[name] = value
+		}
+	}
+	return nil
+}
+
+func (oidcAuth *OIDCAuthenticator) getJSON(url string, accessToken string, target interface{}) error {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	if accessToken != "" {
+		request.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	return oidcAuth.doJSON(request, target)
+}
+
+// doJSON sends the request and decodes the JSON response. Error responses
+// are decoded as well, since they may describe the error.
+func (oidcAuth *OIDCAuthenticator) doJSON(request *http.Request, target interface{}) error {
+	request.Header.Set("Accept", "application/json")
+	response, err := oidcAuth.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	decoder.UseNumber()
+	err = decoder.Decode(target)
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", request.URL.Path, response.Status)
+	}
+	return err
+}
+
+// publicKey returns the RSA or elliptic curve public key.
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, nErr := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, eErr := base64.RawURLEncoding.DecodeString(jwk.E)
+		if nErr != nil || eErr != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA key")
+		}
+
+		exponent := new(big.Int).SetBytes(e)
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Crv)
+		}
+
+		x, xErr := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, yErr := base64.RawURLEncoding.DecodeString(jwk.Y)
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if xErr != nil || yErr != nil || !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid elliptic curve key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", jwk.Kty)
+	}
+}
+
+// verifySignature verifies the signature of a token signed with RSA or
+// ECDSA. Other algorithms, in particular none, are rejected.
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%s, it's signed with unsupported algorithm '%s'", ErrInvalidIDToken, alg)
+	}
+
+	digest := hash.New()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'R' && rsa.VerifyPKCS1v15(key, hash, sum, signature) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(signature) == 2*size {
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, sum, r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s, its signature is wrong", ErrInvalidIDToken)
+}
+
+// claimString returns the claim as a string, or an empty string if it's
+// missing.
+func claimString(claims map[string]interface{}, name string) string {
+	switch value := claims[name].(type) {
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	default:
+		return ""
+	}
+}
+
+// claimStrings returns a claim that is a string or a list of strings.
+func claimStrings(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if text, ok := item.(string); ok {
+				values = append(values, text)
+			}
+		}
+		return values
+	default:
+		return []string{}
+	}
+}
+
+// claimNumber returns a claim that is a number of seconds, like the expiry.
+func claimNumber(claims map[string]interface{}, name string) (int64, error) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("claim %s is missing", name)
+	}
+
+	value, err := number.Float64()
+	return int64(value), err
+}
+
+func containsString(values []string, value string) bool {
+	for _, item := range values {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,348 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bingo/internal/config"
+)
+
+// oidcTestProvider is an OpenID Connect provider running in the test
+// process. Its token endpoint checks the PKCE verifier and returns the ID
+// token set by the test.
+type oidcTestProvider struct {
+	server    *httptest.Server
+	keys      map[string]*rsa.PrivateKey
+	idToken   string
+	challenge string
+	fetches   int
+	mutex     sync.Mutex
+}
+
+func newOIDCTestProvider(t *testing.T) *oidcTestProvider {
+	provider := &oidcTestProvider{keys: map[string]*rsa.PrivateKey{"key-1": newTestRSAKey(t)}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 provider.server.URL,
+			"authorization_endpoint": provider.server.URL + "/authorize",
+			"token_endpoint":         provider.server.URL + "/token",
+			"jwks_uri":               provider.server.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/token", provider.serveToken)
+	mux.HandleFunc("/keys", provider.serveKeys)
+
+	provider.server = httptest.NewServer(mux)
+	t.Cleanup(provider.server.Close)
+	return provider
+}
+
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func (provider *oidcTestProvider) serveToken(w http.ResponseWriter, r *http.Request) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(challenge[:]) != provider.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "id_token": provider.idToken})
+}
+
+func (provider *oidcTestProvider) serveKeys(w http.ResponseWriter, r *http.Request) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	provider.fetches++
+	keys := []map[string]string{}
+	for kid, key := range provider.keys {
+		keys = append(keys, map[string]string{
+			"kty": "RSA",
+			"kid": kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (provider *oidcTestProvider) authenticator() *OIDCAuthenticator {
+	return &OIDCAuthenticator{
+		Issuer:      provider.server.URL,
+		ClientID:    "bingo",
+		RedirectURL: "https://bingo.example.org/login/oidc/callback",
+		UIDClaim:    "preferred_username",
+		NameClaim:   "name",
+		EmailClaim:  "email",
+		GroupsClaim: "groups",
+		Roles: GroupRoles{
+			Admin:   []string{"admins"},
+			Default: config.RoleEditor,
+		},
+		client: provider.server.Client(),
+	}
+}
+
+// claims returns the claims of a valid ID token for the login.
+func (provider *oidcTestProvider) claims(login *OIDCLogin) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                provider.server.URL,
+		"sub":                "248289761001",
+		"aud":                "bingo",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"nonce":              login.Nonce,
+		"preferred_username": "alice",
+		"name":               "Alice Example",
+		"email":              "alice@example.org",
+		"groups":             []string{"admins"},
+	}
+}
+
+// authenticate starts a login and finishes it with the ID token signed with
+// the key of the provider with the given id.
+func (provider *oidcTestProvider) authenticate(t *testing.T, oidcAuth *OIDCAuthenticator, kid string, key *rsa.PrivateKey, change func(claims map[string]interface{})) (*OIDCUser, error) {
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, err := oidcAuth.AuthCodeURL(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := provider.claims(login)
+	if change != nil {
+		change(claims)
+	}
+
+	provider.mutex.Lock()
+	provider.challenge = authURL.Query().Get("code_challenge")
+	provider.idToken = signTestToken(t, "RS256", kid, key, claims)
+	provider.mutex.Unlock()
+
+	return oidcAuth.Authenticate("code", login)
+}
+
+func (provider *oidcTestProvider) keyFetches() int {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+	return provider.fetches
+}
+
+func signTestToken(t *testing.T, alg string, kid string, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	if alg == "none" {
+		return signed + "."
+	}
+
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestOIDCAuthenticate(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+
+	user, err := provider.authenticate(t, oidcAuth, "key-1", provider.keys["key-1"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := OIDCUser{
+		Issuer:  provider.server.URL,
+		Subject: "248289761001",
+		UID:     "alice",
+		Name:    "Alice Example",
+		Email:   "alice@example.org",
+		Role:    config.RoleAdmin,
+	}
+	if *user != expected {
+		t.Errorf("expected %+v, got %+v", expected, *user)
+	}
+}
+
+func TestOIDCAuthenticateRejects(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+	otherKey := newTestRSAKey(t)
+
+	tests := []struct {
+		name   string
+		kid    string
+		key    *rsa.PrivateKey
+		change func(claims map[string]interface{})
+		reason string
+	}{
+		{"bad signature", "key-1", otherKey, nil, "signature is wrong"},
+		{"unknown kid", "key-2", otherKey, nil, "unknown key"},
+		{"wrong audience", "key-1", nil, func(claims map[string]interface{}) { claims["aud"] = "other" }, "meant for another client"},
+		{"wrong authorized party", "key-1", nil, func(claims map[string]interface{}) { claims["azp"] = "other" }, "issued to another client"},
+		{"wrong issuer", "key-1", nil, func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.org" }, "issued by"},
+		{"expired", "key-1", nil, func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-2 * oidcLeeway).Unix() }, "expired"},
+		{"missing expiry", "key-1", nil, func(claims map[string]interface{}) { delete(claims, "exp") }, "expired"},
+		{"nonce mismatch", "key-1", nil, func(claims map[string]interface{}) { claims["nonce"] = "other" }, "nonce"},
+		{"missing subject", "key-1", nil, func(claims map[string]interface{}) { delete(claims, "sub") }, "subject"},
+	}
+
+	for _, test := range tests {
+		key := test.key
+		if key == nil {
+			key = provider.keys["key-1"]
+		}
+
+		_, err := provider.authenticate(t, oidcAuth, test.kid, key, test.change)
+		if err == nil || !strings.HasPrefix(err.Error(), ErrInvalidIDToken.Error()) || !strings.Contains(err.Error(), test.reason) {
+			t.Errorf("%s: expected %v because of the %s, got %v", test.name, ErrInvalidIDToken, test.reason, err)
+		}
+	}
+}
+
+func TestOIDCAuthenticateRejectsUnsigned(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := oidcAuth.AuthCodeURL(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, _ := url.Parse(location)
+	provider.challenge = authURL.Query().Get("code_challenge")
+	provider.idToken = signTestToken(t, "none", "key-1", nil, provider.claims(login))
+
+	_, err = oidcAuth.Authenticate("code", login)
+	if err == nil || !strings.Contains(err.Error(), "unsupported algorithm") {
+		t.Errorf("expected an error about the algorithm, got %v", err)
+	}
+}
+
+func TestOIDCAuthenticateRejectsVerifier(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+
+	login, err := NewOIDCLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = oidcAuth.AuthCodeURL(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	login.Verifier = "other"
+	_, err = oidcAuth.Authenticate("code", login)
+	if err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected the provider to reject the verifier, got %v", err)
+	}
+}
+
+func TestOIDCFindKeyLimitsFetches(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+	rotated := newTestRSAKey(t)
+
+	for i := 0; i < 5; i++ {
+		_, err := provider.authenticate(t, oidcAuth, "key-2", rotated, nil)
+		if err == nil {
+			t.Fatal("expected a token signed with an unknown key to be rejected")
+		}
+	}
+	if fetches := provider.keyFetches(); fetches != 1 {
+		t.Errorf("expected the keys to be fetched once, got %d fetches", fetches)
+	}
+
+	// The provider rotates its key, which is fetched once the interval passed
+	provider.mutex.Lock()
+	provider.keys["key-2"] = rotated
+	provider.mutex.Unlock()
+
+	_, err := provider.authenticate(t, oidcAuth, "key-2", rotated, nil)
+	if err == nil {
+		t.Fatal("expected the keys not to be fetched again before the interval passed")
+	}
+
+	oidcAuth.mutex.Lock()
+	oidcAuth.keysFetched = oidcAuth.keysFetched.Add(-oidcKeysInterval)
+	oidcAuth.mutex.Unlock()
+
+	_, err = provider.authenticate(t, oidcAuth, "key-2", rotated, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = provider.authenticate(t, oidcAuth, "key-1", provider.keys["key-1"], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fetches := provider.keyFetches(); fetches != 2 {
+		t.Errorf("expected the keys to be fetched twice, got %d fetches", fetches)
+	}
+}
+
+func TestOIDCFindKeyRetriesFailedFetch(t *testing.T) {
+	provider := newOIDCTestProvider(t)
+	oidcAuth := provider.authenticator()
+
+	_, err := oidcAuth.discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwksURI := oidcAuth.provider.JWKSURI
+	oidcAuth.provider.JWKSURI = provider.server.URL + "/missing"
+	_, err = provider.authenticate(t, oidcAuth, "key-1", provider.keys["key-1"], nil)
+	if err == nil || !strings.Contains(err.Error(), "failed to fetch keys") {
+		t.Errorf("expected the failed fetch to be reported, got %v", err)
+	}
+
+	oidcAuth.provider.JWKSURI = jwksURI
+	_, err = provider.authenticate(t, oidcAuth, "key-1", provider.keys["key-1"], nil)
+	if err != nil {
+		t.Errorf("expected a failed fetch not to delay the next one, got %v", err)
+	}
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+)
+
+// ProxyUser represents a user authenticated by a reverse proxy.
+type ProxyUser struct {
+	UID   string
+	Name  string
+	Email string
+	Role  config.Role
+}
+
+// ProxyAuthenticator authenticates users using the headers set by a reverse
+// proxy, such as oauth2-proxy, that handles logging in.
+type ProxyAuthenticator struct {
+	TrustedProxies []*net.IPNet
+	UIDHeader      string
+	NameHeader     string
+	EmailHeader    string
+	GroupsHeader   string
+	Roles          GroupRoles
+}
+
+// NewProxyAuthenticator creates a new ProxyAuthenticator using the global
+// configuration.
+func NewProxyAuthenticator() *ProxyAuthenticator {
+	conf := config.Get().Authentication
+	return &ProxyAuthenticator{
+		TrustedProxies: parseNetworks(conf.Proxy.TrustedProxies),
+		UIDHeader:      conf.Proxy.Headers.UID,
+		NameHeader:     conf.Proxy.Headers.Name,
+		EmailHeader:    conf.Proxy.Headers.Email,
+		GroupsHeader:   conf.Proxy.Headers.Groups,
+		Roles: GroupRoles{
+			Admin:   conf.Proxy.AdminGroups,
+			Editor:  conf.Proxy.EditorGroups,
+			Viewer:  conf.Proxy.ViewerGroups,
+			Default: conf.DefaultRole,
+		},
+	}
+}
+
+// Authenticate returns the user set in the headers of the request. The
+// headers are only trusted if the request comes from a trusted proxy, anyone
+// else could set them to impersonate any user.
+func (proxyAuth *ProxyAuthenticator) Authenticate(r *http.Request) (*ProxyUser, bool) {
+	uid := strings.TrimSpace(r.Header.Get(proxyAuth.UIDHeader))
+	if uid == "" {
+		return nil, false
+	}
+
+	if !proxyAuth.isTrusted(r.RemoteAddr) {
+		log.Warnf("Ignoring header %s of request from untrusted address %s", proxyAuth.UIDHeader, r.RemoteAddr)
+		return nil, false
+	}
+
+	user := &ProxyUser{
+		UID:   uid,
+		Name:  proxyAuth.header(r, proxyAuth.NameHeader),
+		Email: proxyAuth.header(r, proxyAuth.EmailHeader),
+		Role:  proxyAuth.Roles.Find(proxyAuth.groups(r)),
+	}
+
+	if user.Name == "" {
+		user.Name = user.UID
+	}
+
+	log.Tracef("User '%s' authenticated by proxy %s as %s", user.UID, r.RemoteAddr, user.Role)
+	return user, true
+}
+
+func (proxyAuth *ProxyAuthenticator) header(r *http.Request, name string) string {
+	if name == "" {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(name))
+}
+
+func (proxyAuth *ProxyAuthenticator) isTrusted(remoteAddr string) bool {
+	return containsIP(proxyAuth.TrustedProxies, hostOf(remoteAddr))
+}
+
+// groups returns the comma separated groups of the user.
+func (proxyAuth *ProxyAuthenticator) groups(r *http.Request) []string {
+	groups := []string{}
+	for _, group := range strings.Split(proxyAuth.header(r, proxyAuth.GroupsHeader), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// parseNetworks parses the given CIDR ranges. Single addresses are treated
+// as ranges containing only that address.
+func parseNetworks(ranges []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(ranges))
+	for _, value := range ranges {
+		if !strings.Contains(value, "/") {
+			if ip := net.ParseIP(value); ip != nil && ip.To4() != nil {
+				value += "/32"
+			} else {
+				value += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			log.Fatalf("Failed to parse trusted proxy '%s': %s", value, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
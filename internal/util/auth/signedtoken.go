@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+)
+
+const signingKeyBytes = 32
+
+// ErrInvalidSignedToken is returned when a signed token was changed, is used
+// for another purpose or has expired.
+var ErrInvalidSignedToken = errors.New("the link is invalid or has expired")
+
+// TokenSigner signs tokens that are sent to users, such as the tokens of
+// password reset links, so they don't have to be stored. A token is signed
+// for a purpose and can't be used for another one.
+type TokenSigner struct {
+	key []byte
+}
+
+// NewTokenSigner creates a new TokenSigner using the secret of the global
+// configuration. Without a secret a random key is used, which makes tokens
+// signed before the server restarts invalid.
+func NewTokenSigner() *TokenSigner {
+	secret := config.Get().Authentication.Secret
+	if secret != "" {
+		return &TokenSigner{key: []byte(secret)}
+	}
+
+	log.Warn("No auth.secret configured, links sent by mail are invalid after restarting")
+	key := make([]byte, signingKeyBytes)
+	_, err := rand.Read(key)
+	if err != nil {
+		log.Fatalf("Failed to generate signing key: %s", err)
+	}
+	return &TokenSigner{key: key}
+}
+
+// Sign creates a token containing the subject, which is valid for the given
+// purpose until it expires.
+func (signer *TokenSigner) Sign(purpose string, subject string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(subject + "|" + strconv.FormatInt(expires.Unix(), 10)))
+	return payload + "." + signer.signature(purpose, payload)
+}
+
+// Verify checks the signature and expiry of the token and returns its
+// subject.
+func (signer *TokenSigner) Verify(purpose string, token string, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(signer.signature(purpose, parts[0])), []byte(parts[1])) {
+		return "", ErrInvalidSignedToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidSignedToken
+	}
+
+	separator := strings.LastIndex(string(payload), "|")
+	if separator < 0 {
+		return "", ErrInvalidSignedToken
+	}
+
+	expires, err := strconv.ParseInt(string(payload[separator+1:]), 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return "", ErrInvalidSignedToken
+	}
+	return string(payload[:separator]), nil
+}
+
+func (signer *TokenSigner) signature(purpose string, payload string) string {
+	mac := hmac.New(sha256.New, signer.key)
+	mac.Write([]byte(purpose + "|" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
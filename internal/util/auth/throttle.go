@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/util/fmtutil"
+	"bingo/internal/util/log"
+)
+
+const (
+	// maxLoginDelay is the longest users have to wait between failed logins
+	// before they are locked out.
+	maxLoginDelay = time.Minute
+
+	// attemptKeyPrefix marks the keys of failed attempts. Session tokens never
+	// contain a colon, so the keys can't be mistaken for a session. Keys are 43
+	// characters long like session tokens, which is all MySQL stores.
+	attemptKeyPrefix = "login:"
+	attemptKeyLength = 43
+)
+
+// AttemptStore keeps the failed login attempts. The session stores of scs
+// implement it, so attempts are kept in the configured session store and are
+// shared by all instances using it.
+type AttemptStore interface {
+	Find(key string) ([]byte, bool, error)
+	Commit(key string, b []byte, expiry time.Time) error
+	Delete(key string) error
+}
+
+// ThrottleError is returned when a login is refused because of too many
+// failed attempts.
+type ThrottleError struct {
+	Wait    time.Duration
+	Locked  bool
+	Address bool
+}
+
+func (err *ThrottleError) Error() string {
+	wait := fmtutil.FormatDuration((err.Wait + time.Second - 1).Truncate(time.Second), 1)
+	switch {
+	case err.Locked && err.Address:
+		return fmt.Sprintf("too many failed logins from your address, try again in %s", wait)
+	case err.Locked:
+		return fmt.Sprintf("the account is locked after too many failed logins, try again in %s or ask an admin to unlock it", wait)
+	default:
+		return fmt.Sprintf("too many failed logins, try again in %s", wait)
+	}
+}
+
+// Lockout tells which lockouts a failed login caused.
+type Lockout struct {
+	Account bool
+	Address bool
+}
+
+// LoginThrottle tracks failed logins per account and per address. After a
+// few failures every further attempt has to wait twice as long as the one
+// before, and too many failures lock the account or address for a while.
+// Failures are forgotten once there were none for as long as a lockout.
+type LoginThrottle struct {
+	FreeAttempts     int
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	Duration         time.Duration
+	TrustedProxies   []*net.IPNet
+	store            AttemptStore
+	mutex            sync.Mutex
+}
+
+// loginAttempts are the failed logins of an account or address.
+type loginAttempts struct {
+	Failures    int   `json:"failures"`
+	LastFailure int64 `json:"last_failure"`
+	LockedUntil int64 `json:"locked_until"`
+}
+
+// NewLoginThrottle creates a new LoginThrottle keeping the attempts in the
+// given store using the global configuration.
+func NewLoginThrottle(store AttemptStore) *LoginThrottle {
+	conf := config.Get().Authentication.Lockout
+	return &LoginThrottle{
+		FreeAttempts:     conf.FreeAttempts,
+		MaxAttempts:      conf.MaxAttempts,
+		MaxAttemptsPerIP: conf.MaxAttemptsPerIP,
+		Duration:         time.Duration(conf.Duration) * time.Minute,
+		TrustedProxies:   parseNetworks(conf.TrustedProxies),
+		store:            store,
+	}
+}
+
+// Address returns the address of the client. Behind a trusted proxy it's
+// the last address of the X-Forwarded-For header that isn't a trusted proxy,
+// since the earlier ones can be set by the client.
+func (throttle *LoginThrottle) Address(r *http.Request) string {
+	address := hostOf(r.RemoteAddr)
+	if !containsIP(throttle.TrustedProxies, address) {
+		return address
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address = strings.TrimSpace(forwarded[i])
+		if address != "" && !containsIP(throttle.TrustedProxies, address) {
+			return address
+		}
+	}
+	return address
+}
+
+// Check returns a ThrottleError if the account or address is locked, or has
+// to wait before trying again.
+func (throttle *LoginThrottle) Check(uid string, address string, now time.Time) error {
+	account, err := throttle.find(accountKey(uid))
+	if err != nil {
+		return err
+	}
+
+	if wait, locked := throttle.wait(account, now); wait > 0 {
+		return &ThrottleError{Wait: wait, Locked: locked}
+	}
+
+	client, err := throttle.find(addressKey(address))
+	if err != nil {
+		return err
+	}
+
+	if wait, locked := throttle.wait(client, now); wait > 0 {
+		return &ThrottleError{Wait: wait, Locked: locked, Address: true}
+	}
+	return nil
+}
+
+// Fail records a failed login of the account from the address.
+func (throttle *LoginThrottle) Fail(uid string, address string, now time.Time) (Lockout, error) {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	lockout := Lockout{}
+	locked, err := throttle.fail(accountKey(uid), throttle.MaxAttempts, now)
+	if err != nil {
+		return lockout, err
+	}
+	lockout.Account = locked
+
+	locked, err = throttle.fail(addressKey(address), throttle.MaxAttemptsPerIP, now)
+	lockout.Address = locked
+	return lockout, err
+}
+
+// LockedUntil returns until when the account is locked.
+func (throttle *LoginThrottle) LockedUntil(uid string, now time.Time) (time.Time, bool) {
+	account, err := throttle.find(accountKey(uid))
+	if err != nil {
+		log.Errorf("Failed to find failed logins of user '%s': %s", uid, err)
+		return time.Time{}, false
+	}
+
+	until := time.Unix(account.LockedUntil, 0)
+	return until, until.After(now)
+}
+
+// Reset forgets the failed logins of the account, which also unlocks it.
+// Failures of addresses are kept, so logging in to an account of their own
+// doesn't let attackers continue guessing passwords of other accounts.
+func (throttle *LoginThrottle) Reset(uid string) error {
+	throttle.mutex.Lock()
+	defer throttle.mutex.Unlock()
+
+	return throttle.store.Delete(accountKey(uid))
+}
+
+// wait returns how long to wait before the next attempt, and whether it's
+// because of a lockout.
+func (throttle *LoginThrottle) wait(attempts *loginAttempts, now time.Time) (time.Duration, bool) {
+	if until := time.Unix(attempts.LockedUntil, 0); until.After(now) {
+		return until.Sub(now), true
+	}
+
+	if attempts.Failures <= throttle.FreeAttempts {
+		return 0, false
+	}
+
+	delay := maxLoginDelay
+	if shift := attempts.Failures - throttle.FreeAttempts - 1; shift < 16 {
+		delay = time.Duration(1<<shift) * time.Second
+	}
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+
+	next := time.Unix(attempts.LastFailure, 0).Add(delay)
+	if next.After(now) {
+		return next.Sub(now), false
+	}
+	return 0, false
+}
+
+// fail records a failure and returns whether it locked the key.
+func (throttle *LoginThrottle) fail(key string, max int, now time.Time) (bool, error) {
+	attempts, err := throttle.find(key)
+	if err != nil {
+		return false, err
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now.Unix()
+
+	locked := max > 0 && attempts.Failures >= max
+	if locked {
+		attempts.Failures = 0
+		attempts.LockedUntil = now.Add(throttle.Duration).Unix()
+	}
+
+	data, err := json.Marshal(attempts)
+	if err != nil {
+		return false, err
+	}
+
+	expiry := now.Add(throttle.Duration)
+	if until := time.Unix(attempts.LockedUntil, 0); until.After(expiry) {
+		expiry = until
+	}
+	return locked, throttle.store.Commit(key, data, expiry)
+}
+
+func (throttle *LoginThrottle) find(key string) (*loginAttempts, error) {
+	attempts := new(loginAttempts)
+	data, found, err := throttle.store.Find(key)
+	if err != nil || !found {
+		return attempts, err
+	}
+
+	err = json.Unmarshal(data, attempts)
+	return attempts, err
+}
+
+// accountKey returns the key of the failed logins of an account. Usernames
+// are hashed, so the key has the same length for every username. Failures
+// are counted for usernames that don't exist too, so locking out doesn't tell
+// which accounts exist.
+func accountKey(uid string) string {
+	return attemptKey("account:" + strings.ToLower(uid))
+}
+
+// addressKey returns the key of the failed logins from an address.
+func addressKey(address string) string {
+	return attemptKey("address:" + address)
+}
+
+func attemptKey(value string) string {
+	return storeKey(attemptKeyPrefix, value)
+}
+
+// storeKey returns a key of the session store with the given prefix, which
+// is as long as a session token whatever the value is.
+func storeKey(prefix string, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	hash := base64.RawURLEncoding.EncodeToString(sum[:])
+	return prefix + hash[:attemptKeyLength-len(prefix)]
+}
+
+// hostOf returns the host of an address with or without a port.
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// containsIP returns whether the address is in any of the networks.
+func containsIP(networks []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
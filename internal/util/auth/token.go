@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+const (
+	// TokenPrefix is prepended to every generated API token to make them easy to recognize.
+	TokenPrefix = "bingo_"
+
+	// TokenDisplayLength is the number of characters of the token that are stored in plain-text.
+	TokenDisplayLength = len(TokenPrefix) + 6
+
+	tokenBytes = 24
+)
+
+// GenerateToken creates a new random API token.
+func GenerateToken() (string, error) {
+	key, err := GenerateKey()
+	if err != nil {
+		return "", err
+	}
+
+	return TokenPrefix + key, nil
+}
+
+// GenerateKey creates a new random secret key encoded as hex.
+func GenerateKey() (string, error) {
+	bytes := make([]byte, tokenBytes)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+// HashToken creates a SHA-256 hash of the given API token. Tokens have enough
+// entropy that a slow password hash isn't needed, and a fast hash allows
+// looking the token up directly.
+func HashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// CheckTokenHash checks if the given token or key matches the hash in constant time.
+func CheckTokenHash(token string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashToken(token)), []byte(hash)) == 1
+}
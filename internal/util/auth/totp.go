@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// RecoveryCodeCount is the number of recovery codes users get when they
+	// enable two-factor authentication.
+	RecoveryCodeCount = 10
+
+	totpSecretBytes   = 20
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	recoveryCodeBytes = 10
+	qrCodeSize        = 256
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a new random TOTP secret encoded as base32, the
+// way authenticator apps expect it when it's typed in.
+func GenerateTOTPSecret() (string, error) {
+	bytes := make([]byte, totpSecretBytes)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(bytes), nil
+}
+
+// TOTPURL returns the otpauth URL of the secret that authenticator apps read
+// from the QR code.
+func TOTPURL(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// QRCode renders the content as a PNG image of a QR code.
+func QRCode(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, qrCodeSize)
+}
+
+// CheckTOTP checks the code against the secret as described in RFC 6238.
+// Codes of the previous and next time step are accepted too, to allow for
+// clocks being off a little. The time step of the matching code is returned,
+// so the caller can make sure that it isn't used twice.
+func CheckTOTP(secret string, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpCode computes the code of the given time step using HOTP as described
+// in RFC 4226.
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// GenerateRecoveryCodes creates new random recovery codes, which can be used
+// once each instead of a TOTP code. They are grouped in blocks of four
+// characters to make them easier to write down.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		bytes := make([]byte, recoveryCodeBytes)
+		_, err := rand.Read(bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(totpEncoding.EncodeToString(bytes))
+		codes[i] = code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode creates a hash of the recovery code. Like API tokens,
+// recovery codes have enough entropy for a fast hash. Case, spaces and dashes
+// are ignored.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return HashToken(code)
+}
@@ -3,6 +3,7 @@ package fmtutil
 import (
 	"html"
 	"strings"
+	"unicode"
 
 	"bingo/internal/util/log"
 
@@ -32,6 +33,88 @@ func FormatCode(lang string, content string) string {
 	return builder.String()
 }
 
+// DetectLanguage returns the name of the language matching the given filename,
+// or plaintext if no language matches.
+func DetectLanguage(filename string) string {
+	lexer := lexers.Match(filename)
+	if lexer == nil {
+		log.Debugf("No language matches filename '%s'", filename)
+		return "plaintext"
+	}
+
+	return lexer.Config().Name
+}
+
+// ParseLanguage returns the name of the language with the given name or alias,
+// which can be the name used by another program. Unknown languages fall back
+// to the language matching the filename, or plaintext.
+func ParseLanguage(lang string, filename string) string {
+	if lang != "" {
+		lexer := lexers.Get(lang)
+		if lexer != nil {
+			return lexer.Config().Name
+		}
+		log.Debugf("Unknown language '%s'", lang)
+	}
+
+	return DetectLanguage(filename)
+}
+
+// AnalyseLanguage returns the name of the language the content is most likely
+// written in, or plaintext if it can't be told.
+func AnalyseLanguage(content string) string {
+	lexer := lexers.Analyse(content)
+	if lexer == nil {
+		return "plaintext"
+	}
+
+	return lexer.Config().Name
+}
+
+// LanguageExtension returns the file extension commonly used by the given
+// language, or .txt if the language has no known extension.
+func LanguageExtension(lang string) string {
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return ".txt"
+	}
+
+	for _, pattern := range lexer.Config().Filenames {
+		if strings.HasPrefix(pattern, "*.") && !strings.ContainsAny(pattern[2:], "*?[") {
+			return pattern[1:]
+		}
+	}
+	return ".txt"
+}
+
+// Filename returns a filename for content with the given title and language.
+// The extension of the language is added unless the title already ends with it.
+func Filename(title string, lang string, fallback string) string {
+	name := Slug(title, fallback)
+
+	extension := LanguageExtension(lang)
+	if !strings.HasSuffix(strings.ToLower(name), extension) {
+		name += extension
+	}
+	return name
+}
+
+// Slug replaces characters of the title that aren't safe in filenames, or
+// returns the fallback if nothing is left of the title.
+func Slug(title string, fallback string) string {
+	slug := strings.Trim(strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-_.", r) {
+			return r
+		}
+		return '-'
+	}, strings.TrimSpace(title)), "-.")
+
+	if slug == "" {
+		return fallback
+	}
+	return slug
+}
+
 func getLexer(lang string) chroma.Lexer {
 	log.Debugf("Using lexer '%s'", lang)
 
@@ -0,0 +1,32 @@
+package fmtutil
+
+import (
+	"bingo/internal/util/log"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var (
+	diffContextLines = 3
+)
+
+// UnifiedDiff creates a unified diff between the given strings.
+func UnifiedDiff(fromName string, from string, toName string, to string) string {
+	log.Debugf("Creating diff between %s and %s", fromName, toName)
+
+	diff := difflib.UnifiedDiff{
+		A:        difflib.SplitLines(from),
+		B:        difflib.SplitLines(to),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  diffContextLines,
+	}
+
+	result, err := difflib.GetUnifiedDiffString(diff)
+	if err != nil {
+		log.Errorf("Failed to create diff: %s", err)
+		return ""
+	}
+
+	return result
+}
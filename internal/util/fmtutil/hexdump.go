@@ -0,0 +1,12 @@
+package fmtutil
+
+import "encoding/hex"
+
+// Hexdump returns a hex dump of at most limit bytes of the given data. A
+// truncated dump ends with a line containing an ellipsis.
+func Hexdump(data []byte, limit int) string {
+	if len(data) <= limit {
+		return hex.Dump(data)
+	}
+	return hex.Dump(data[:limit]) + "...\n"
+}
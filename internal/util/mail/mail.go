@@ -0,0 +1,183 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	netmail "net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"bingo/internal/config"
+	"bingo/internal/util/log"
+)
+
+const (
+	// SecurityNone sends mail in plain text.
+	SecurityNone = "none"
+
+	// SecuritySTARTTLS upgrades the connection to TLS after connecting, and
+	// refuses to send mail if the server doesn't support it.
+	SecuritySTARTTLS = "starttls"
+
+	// SecurityTLS connects using TLS, which is usually done on port 465.
+	SecurityTLS = "tls"
+
+	mailTimeout = 30 * time.Second
+)
+
+// Mailer sends mail using an SMTP server.
+type Mailer struct {
+	Host     string
+	Port     int
+	Security string
+	Username string
+	Password string
+	From     *netmail.Address
+}
+
+// NewMailer creates a new Mailer using the global configuration.
+func NewMailer() *Mailer {
+	conf := config.Get().SMTP
+	from, err := netmail.ParseAddress(conf.From)
+	if err != nil {
+		log.Fatalf("Failed to parse smtp.from '%s': %s", conf.From, err)
+	}
+
+	if conf.BaseURL == "" {
+		log.Fatalf("Failed to parse config: base_url is required to link to bingo in mails")
+	}
+
+	switch conf.Security {
+	case SecurityNone, SecuritySTARTTLS, SecurityTLS:
+	default:
+		log.Fatalf("Failed to parse config: unknown value 'smtp.security: %s'", conf.Security)
+	}
+
+	return &Mailer{
+		Host:     conf.Host,
+		Port:     conf.Port,
+		Security: conf.Security,
+		Username: conf.Username,
+		Password: conf.Password,
+		From:     from,
+	}
+}
+
+// Send sends a plain text mail to the given address.
+func (mailer *Mailer) Send(to string, subject string, body string) error {
+	recipient, err := netmail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid address '%s': %s", to, err)
+	}
+
+	message, err := mailer.message(recipient, subject, body)
+	if err != nil {
+		return err
+	}
+
+	client, err := mailer.connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to mail server: %s", err)
+	}
+	defer client.Close()
+
+	if mailer.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host))
+		if err != nil {
+			return fmt.Errorf("failed to authenticate with mail server: %s", err)
+		}
+	}
+
+	err = client.Mail(mailer.From.Address)
+	if err == nil {
+		err = client.Rcpt(recipient.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("mail server refused mail: %s", err)
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(message)
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %s", err)
+	}
+
+	log.Debugf("Sent mail '%s' to %s", subject, recipient.Address)
+	return client.Quit()
+}
+
+// connect opens a connection to the server, which is secured as configured.
+// Credentials are never sent over a plain text connection, unless the
+// server runs on localhost.
+func (mailer *Mailer) connect() (*smtp.Client, error) {
+	addr := net.JoinHostPort(mailer.Host, strconv.Itoa(mailer.Port))
+	tlsConfig := &tls.Config{ServerName: mailer.Host}
+
+	var conn net.Conn
+	var err error
+	if mailer.Security == SecurityTLS {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: mailTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, mailTimeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(mailTimeout))
+
+	client, err := smtp.NewClient(conn, mailer.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if mailer.Security == SecuritySTARTTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("the server doesn't support STARTTLS")
+		}
+
+		err = client.StartTLS(tlsConfig)
+		if err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// message formats the mail with its headers. The body is encoded as
+// quoted-printable, so long lines and non-ASCII text arrive unchanged.
+func (mailer *Mailer) message(to *netmail.Address, subject string, body string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", mailer.From.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&buf, "Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	writer := quotedprintable.NewWriter(&buf)
+	_, err := writer.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	if err != nil {
+		return nil, err
+	}
+
+	err = writer.Close()
+	return buf.Bytes(), err
+}
@@ -0,0 +1,31 @@
+// Helpers shared by the scripts encrypting and decrypting pastes. Keys are
+// encoded in base64url to keep them readable in the URL fragment.
+
+function toBase64(bytes) {
+    var binary = "";
+    for (var i = 0; i < bytes.length; i++) {
+        binary += String.fromCharCode(bytes[i]);
+    }
+    return window.btoa(binary);
+}
+
+function fromBase64(text) {
+    var binary = window.atob(text);
+    var bytes = new Uint8Array(binary.length);
+    for (var i = 0; i < binary.length; i++) {
+        bytes[i] = binary.charCodeAt(i);
+    }
+    return bytes;
+}
+
+function toBase64URL(bytes) {
+    return toBase64(bytes).split("+").join("-").split("/").join("_").split("=").join("");
+}
+
+function fromBase64URL(text) {
+    text = text.split("-").join("+").split("_").join("/");
+    while (text.length % 4 !== 0) {
+        text += "=";
+    }
+    return fromBase64(text);
+}
@@ -0,0 +1,72 @@
+// Decrypts the paste using the key in the URL fragment. The server only knows
+// the ciphertext, so the decrypted title and files are filled in here.
+(function () {
+    var card = document.getElementById("paste-card");
+    var title = document.getElementById("paste-title");
+    var content = document.getElementById("paste-content");
+    var parts = content.getAttribute("data-ciphertext").split(":");
+    var key = window.location.hash.substring(1);
+
+    function fail(message) {
+        content.textContent = message;
+    }
+
+    // Additional files are shown as stacked cards the same way the server
+    // renders pastes with multiple files
+    function showFiles(files) {
+        if (files.length === 1) {
+            content.textContent = files[0].content;
+            return;
+        }
+
+        card.classList.remove("card--grow");
+        card.classList.add("card--stacked");
+        content.parentNode.remove();
+
+        for (var i = 0; i < files.length; i++) {
+            var fileCard = document.createElement("div");
+            fileCard.className = "card card--stacked card--file";
+
+            var header = document.createElement("div");
+            header.className = "card__header";
+            var fileTitle = document.createElement("div");
+            fileTitle.className = "card__title";
+            fileTitle.textContent = files[i].filename;
+            header.appendChild(fileTitle);
+
+            var body = document.createElement("div");
+            body.className = "card__body";
+            var pre = document.createElement("pre");
+            pre.textContent = files[i].content;
+            body.appendChild(pre);
+
+            fileCard.appendChild(header);
+            fileCard.appendChild(body);
+            card.parentNode.appendChild(fileCard);
+        }
+    }
+
+    if (!window.crypto || !window.crypto.subtle) {
+        fail("Your browser doesn't support decrypting pastes.");
+        return;
+    } else if (key === "") {
+        fail("The link doesn't contain the key needed to decrypt this paste.");
+        return;
+    } else if (parts.length !== 3 || parts[0] !== "v1") {
+        fail("The paste is encrypted in an unknown format.");
+        return;
+    }
+
+    window.crypto.subtle.importKey("raw", fromBase64URL(key), "AES-GCM", false, ["decrypt"])
+        .then(function (imported) {
+            return window.crypto.subtle.decrypt({ name: "AES-GCM", iv: fromBase64(parts[1]) }, imported, fromBase64(parts[2]));
+        })
+        .then(function (plaintext) {
+            var paste = JSON.parse(new TextDecoder().decode(plaintext));
+            title.textContent = paste.title || "Untitled";
+            showFiles(paste.files || [{ content: paste.content }]);
+        })
+        .catch(function () {
+            fail("Failed to decrypt the paste, the key in the link is wrong.");
+        });
+})();
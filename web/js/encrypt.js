@@ -0,0 +1,139 @@
+// Encrypts the title and files of a new paste before the form is sent. The
+// key is appended to the form action as a fragment, which browsers never send
+// to the server but keep when following the redirect to the created paste.
+(function () {
+    var form = document.getElementById("paste-form");
+    var encrypted = form ? form.elements["encrypted"] : null;
+    if (!encrypted || !window.crypto || !window.crypto.subtle) {
+        return;
+    }
+
+    // Attachments can't be encrypted, so they're only sent with plain pastes
+    var attachment = form.elements["attachment"];
+    function updateAttachment() {
+        if (attachment) {
+            attachment.disabled = encrypted.value === "1";
+        }
+    }
+    encrypted.addEventListener("change", updateAttachment);
+    updateAttachment();
+
+    function fileBars() {
+        return form.querySelectorAll(".card__file");
+    }
+
+    function removeButton() {
+        var button = document.createElement("button");
+        button.type = "submit";
+        button.name = "remove_file";
+        button.className = "card__control card__button";
+        button.formNoValidate = true;
+        button.textContent = "Remove";
+        return button;
+    }
+
+    function updateRemoveButtons() {
+        var bars = fileBars();
+        for (var i = 0; i < bars.length; i++) {
+            var button = bars[i].querySelector("button[name=remove_file]");
+            if (bars.length === 1 && button) {
+                button.remove();
+            } else if (bars.length > 1 && !button) {
+                button = removeButton();
+                bars[i].appendChild(button);
+            }
+            if (button) {
+                button.value = i;
+            }
+        }
+    }
+
+    function addFile() {
+        var bars = fileBars();
+        var last = bars[bars.length - 1];
+        var bar = last.cloneNode(true);
+        var content = last.nextElementSibling.cloneNode(false);
+
+        bar.querySelector("input[name=filename]").value = "";
+        var language = bar.querySelector("select[name=language]");
+        if (language) {
+            language.value = "plaintext";
+        }
+        content.removeAttribute("required");
+        content.removeAttribute("pattern");
+        content.value = "";
+
+        last.nextElementSibling.after(bar, content);
+        updateRemoveButtons();
+    }
+
+    function removeFile(index) {
+        var bars = fileBars();
+        if (bars.length > 1) {
+            bars[index].nextElementSibling.remove();
+            bars[index].remove();
+            updateRemoveButtons();
+        }
+    }
+
+    // Posting the form to add or remove a file would send the content to the
+    // server unencrypted, so files are added and removed on the page instead
+    form.addEventListener("click", function (event) {
+        var button = event.target.closest("button");
+        if (encrypted.value !== "1" || !button) {
+            return;
+        }
+
+        if (button.name === "add_file") {
+            event.preventDefault();
+            addFile();
+        } else if (button.name === "remove_file") {
+            event.preventDefault();
+            removeFile(parseInt(button.value, 10));
+        }
+    });
+
+    form.addEventListener("submit", function (event) {
+        if (encrypted.value !== "1") {
+            return;
+        }
+        event.preventDefault();
+
+        var bars = fileBars();
+        var files = [];
+        for (var i = 0; i < bars.length; i++) {
+            var language = bars[i].querySelector("select[name=language]");
+            files.push({
+                filename: bars[i].querySelector("input[name=filename]").value,
+                language: language ? language.value : "plaintext",
+                content: bars[i].nextElementSibling.value
+            });
+        }
+
+        var title = form.elements["title"];
+        var payload = new TextEncoder().encode(JSON.stringify({ title: title.value, files: files }));
+        var iv = window.crypto.getRandomValues(new Uint8Array(12));
+        var key;
+
+        window.crypto.subtle.generateKey({ name: "AES-GCM", length: 256 }, true, ["encrypt"])
+            .then(function (generated) {
+                key = generated;
+                return window.crypto.subtle.encrypt({ name: "AES-GCM", iv: iv }, key, payload);
+            })
+            .then(function (ciphertext) {
+                // Only the ciphertext is sent, in place of the first file
+                for (var i = bars.length - 1; i > 0; i--) {
+                    bars[i].nextElementSibling.remove();
+                    bars[i].remove();
+                }
+                title.value = "";
+                bars[0].querySelector("input[name=filename]").value = "";
+                bars[0].nextElementSibling.value = "v1:" + toBase64(iv) + ":" + toBase64(new Uint8Array(ciphertext));
+                return window.crypto.subtle.exportKey("raw", key);
+            })
+            .then(function (rawKey) {
+                form.action = form.getAttribute("action") + "#" + toBase64URL(new Uint8Array(rawKey));
+                form.submit();
+            });
+    });
+})();